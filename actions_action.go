@@ -24,6 +24,7 @@ type ActionsAction struct {
 	Actions []Action       `yaml:"actions,omitempty" json:"actions,omitempty"`
 
 	vars [][]any
+	pos  SourcePos
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline" json:"-"`
@@ -92,6 +93,13 @@ func (a *ActionsAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *ActionsAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *ActionsAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 // func (a *ActionsAction) GetBaseAction() *BaseAction {
 // 	return nil
 // }
@@ -27,6 +27,9 @@ import (
 	"github.com/mitchellh/copystructure"
 	"github.com/peekjef72/passwd_encrypt/encrypt"
 	"github.com/spf13/cast"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 )
@@ -106,6 +109,20 @@ type Client struct {
 	content_mutex *sync.Mutex
 
 	ctx context.Context
+
+	// normalization rules for the urls used as label of query_status
+	status_url *QueryStatusUrlConfig
+
+	// key read from auth_key_file of auth config: used when scrape request doesn't set one
+	auth_key string
+
+	// auth config of target: credentials may be obtained from secret providers
+	auth *AuthConfig
+	// user, password and token last obtained from auth config
+	credentials [3]string
+
+	// limits of concurrent requests and rate, shared with the clients of the same host or target
+	limits *requestLimits
 }
 
 func newClient(target *TargetConfig, sc map[string]*YAMLScript, logger *slog.Logger, gc *GlobalConfig) *Client {
@@ -118,6 +135,10 @@ func newClient(target *TargetConfig, sc map[string]*YAMLScript, logger *slog.Log
 		invalid_auth_code: gc.invalid_auth_code,
 		tls_version:       gc.tls_version,
 		ctx:               context.TODO(),
+		status_url:        gc.QueryStatusUrl,
+		auth_key:          target.AuthConfig.authKey,
+		auth:              &target.AuthConfig,
+		limits:            newRequestLimits(gc, target),
 	}
 
 	params := &ClientInitParams{
@@ -134,6 +155,9 @@ func newClient(target *TargetConfig, sc map[string]*YAMLScript, logger *slog.Log
 		CustomProperties: target.CustomProperties,
 	}
 	cl.symtab["__collector_id"] = target.Name
+	// kept for the whole life of client: used to label internal metrics
+	cl.symtab["__target_id"] = target.Name
+	cl.symtab["__metrics_target"] = metricsTargetLabel(target)
 	if err := cl.Init(params); err != nil {
 		logger.Error(fmt.Sprintf("target client init error: %s", err.Error()))
 		return nil
@@ -154,6 +178,10 @@ func (c *Client) Clone(target *TargetConfig) *Client {
 		// content_mutex: sync.Mutex{},
 		invalid_auth_code: c.invalid_auth_code,
 		tls_version:       c.tls_version,
+		status_url:        c.status_url,
+		auth_key:          c.auth_key,
+		auth:              &target.AuthConfig,
+		limits:            c.limits.forTarget(target),
 	}
 
 	var err error
@@ -165,6 +193,22 @@ func (c *Client) Clone(target *TargetConfig) *Client {
 		}
 	}()
 
+	// the trace context and the debug scrape report are not copied but shared with the clone.
+	shared := make(map[string]any)
+	for _, key := range []string{traceContextKey, debugScrapeKey} {
+		if val, ok := c.symtab[key]; ok {
+			shared[key] = val
+			delete(c.symtab, key)
+		}
+	}
+	defer func() {
+		for key, val := range shared {
+			c.symtab[key] = val
+			if cl.symtab != nil {
+				cl.symtab[key] = val
+			}
+		}
+	}()
 	tmp = c.symtab
 	if tmp, err = copystructure.Copy(c.symtab); err != nil {
 		c.logger.Error(
@@ -251,6 +295,15 @@ func (c *Client) SetUrl(url string) string {
 	return uri
 }
 
+// QueryStatusLabel returns the label of query_status metric for a query: status_label if set,
+// else the url normalized by global query_status_url rules.
+func (c *Client) QueryStatusLabel(url, status_label string) string {
+	if status_label != "" {
+		return status_label
+	}
+	return c.status_url.Normalize(url)
+}
+
 // set the queries status for client
 func (c *Client) SetQueriesStatus(url string, status_code int, target_symtab map[string]any) {
 
@@ -383,6 +436,7 @@ func (c *Client) getResponse(resp *resty.Response, parser string) any {
 				// tmp := make([]byte, len(body))
 				// copy(tmp, body)
 				if err := json.Unmarshal(body, &data); err != nil {
+					c.parseFailure(parser)
 					c.logger.Error(
 						fmt.Sprintf("Fail to decode json results %v", err),
 						"coll", CollectorId(c.symtab, c.logger),
@@ -393,8 +447,20 @@ func (c *Client) getResponse(resp *resty.Response, parser string) any {
 			data = string(body)
 
 		case "prometheus":
-			if content_type == "" || strings.Contains(content_type, "text/plain") {
+			if strings.Contains(content_type, "application/vnd.google.protobuf") {
+				// protobuf is required to collect native histograms
+				if tmp_data, err := ParsePrometheusProtobufResponse(body); err != nil {
+					c.parseFailure(parser)
+					c.logger.Error(
+						fmt.Sprintf("Fail to parse prometheus protobuf content %v", err),
+						"coll", CollectorId(c.symtab, c.logger),
+						"script", ScriptName(c.symtab, c.logger))
+				} else {
+					data = tmp_data
+				}
+			} else if content_type == "" || strings.Contains(content_type, "text/plain") || strings.Contains(content_type, "application/openmetrics-text") {
 				if tmp_data, err := ParsePrometheusResponse(body); err != nil {
+					c.parseFailure(parser)
 					c.logger.Error(
 						fmt.Sprintf("Fail to parse prometheus content %v", err),
 						"coll", CollectorId(c.symtab, c.logger),
@@ -420,6 +486,7 @@ func (c *Client) getResponse(resp *resty.Response, parser string) any {
 				// copy(tmp, body)
 				var data_internal *Content
 				if err := xml.Unmarshal(body, &data_internal); err != nil {
+					c.parseFailure(parser)
 					c.logger.Error(
 						fmt.Sprintf("Fail to decode xml results %v", err),
 						"coll", CollectorId(c.symtab, c.logger),
@@ -434,6 +501,7 @@ func (c *Client) getResponse(resp *resty.Response, parser string) any {
 				// tmp := make([]byte, len(body))
 				// copy(tmp, body)
 				if err := yaml.Unmarshal(body, &data); err != nil {
+					c.parseFailure(parser)
 					c.logger.Error(
 						fmt.Sprintf("Fail to decode yaml results %v", err),
 						"coll", CollectorId(c.symtab, c.logger),
@@ -448,6 +516,75 @@ func (c *Client) getResponse(resp *resty.Response, parser string) any {
 	return data
 }
 
+// RefreshAuth updates the credentials of symbols table when the values obtained from secret providers have changed;
+// the client will then authenticate again.
+func (c *Client) RefreshAuth() {
+	if c.auth == nil || !c.auth.HasSecretRefs() {
+		return
+	}
+	user, password, token, err := c.auth.Values()
+	if err != nil {
+		c.logger.Warn(
+			fmt.Sprintf("can't refresh credentials from secret provider: %s", err),
+			"coll", CollectorId(c.symtab, c.logger),
+			"script", ScriptName(c.symtab, c.logger))
+	}
+	credentials := [3]string{user, password, token}
+	if credentials == c.credentials {
+		return
+	}
+	c.credentials = credentials
+	c.symtab["user"] = user
+	c.symtab["password"] = password
+	c.symtab["auth_token"] = token
+	c.symtab["auth_set"] = false
+	c.logger.Info(
+		"credentials have changed: authenticate again",
+		"coll", CollectorId(c.symtab, c.logger),
+		"script", ScriptName(c.symtab, c.logger))
+}
+
+// requestTrace builds the trace of a request for debug scrape; secrets are redacted.
+func (c *Client) requestTrace(method, url string, try int, body any, resp *resty.Response, err error, duration time.Duration) *RequestTrace {
+	script := GetMapValueString(c.symtab, "__name__")
+	req := &RequestTrace{
+		Collector: GetMapValueString(c.symtab, "__collector_id"),
+		Script:    script,
+		Method:    method,
+		Url:       url,
+		Try:       try,
+		Duration:  duration.Seconds(),
+	}
+	if body != nil {
+		if script == "login" {
+			// login payload contains credentials
+			req.RequestBody = redactedValue
+		} else {
+			req.RequestBody = truncateBody(redactor.String(fmt.Sprintf("%v", body)))
+		}
+	}
+	if err != nil {
+		req.Error = err.Error()
+	}
+	if resp != nil {
+		if resp.Request != nil && resp.Request.RawRequest != nil {
+			req.RequestHeaders = redactor.Headers(resp.Request.RawRequest.Header)
+		}
+		if resp.RawResponse != nil {
+			req.StatusCode = resp.StatusCode()
+			req.ResponseHeaders = redactor.Headers(resp.Header())
+			req.ResponseBody = truncateBody(redactor.String(string(resp.Body())))
+		}
+	}
+	return req
+}
+
+// parseFailure counts a response that parser has failed to decode.
+func (c *Client) parseFailure(parser string) {
+	target, collector := metricsIds(c.symtab)
+	parseFailures.WithLabelValues(target, collector, parser).Inc()
+}
+
 // sent HTTP Method to uri with params or body and get the response and the json obj
 func (c *Client) Execute(
 	method, uri string,
@@ -501,17 +638,64 @@ func (c *Client) Execute(
 	if len(params) > 0 {
 		req.SetQueryParams(params)
 	}
+	// span of the request is a child of the current action one, but the request keeps the deadline of client context.
+	var span trace.Span
+	if tracingEnabled {
+		_, span = tracer.Start(symtabContext(c.symtab, c.ctx), "http "+method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.URLFull(url),
+				attribute.String("target", TargetId(c.symtab)),
+				attribute.String("collector", GetMapValueString(c.symtab, "__collector_id"))))
+		defer func() {
+			if resp != nil && resp.RawResponse != nil {
+				span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode()))
+			}
+			endSpan(span, err)
+		}()
+	}
 	if c.ctx != context.TODO() {
-		req.SetContext(c.ctx)
+		if span != nil {
+			req.SetContext(trace.ContextWithSpan(c.ctx, span))
+		} else {
+			req.SetContext(c.ctx)
+		}
 	}
 
 	query_retry = 0
 	if tmp_query_retry, ok := GetMapValueInt(c.symtab, "queryRetry"); ok {
 		query_retry = tmp_query_retry
 	}
+	target, collector := metricsIds(c.symtab)
 
 	for i := 0; i <= query_retry; i++ {
+		if i > 0 {
+			httpRequestRetries.WithLabelValues(target, collector).Inc()
+			if span != nil {
+				span.SetAttributes(semconv.HTTPRequestResendCount(i))
+			}
+		}
+		wait_start := time.Now()
+		release, lerr := c.limits.acquire(c.ctx, url)
+		httpRequestQueueWait.WithLabelValues(target, collector).Observe(time.Since(wait_start).Seconds())
+		if lerr != nil {
+			c.logger.Debug(
+				"query not sent",
+				"coll", CollectorId(c.symtab, c.logger),
+				"script", ScriptName(c.symtab, c.logger),
+				"errmsg", lerr)
+			err = ErrContextDeadLineExceeded
+			timeouts.WithLabelValues(target, collector).Inc()
+			return resp, data, err
+		}
+		start := time.Now()
 		resp, err = req.Execute(method, url)
+		release()
+		httpRequestDuration.WithLabelValues(target, collector, method).Observe(time.Since(start).Seconds())
+		if report := scrapeReportFromSymtab(c.symtab); report != nil {
+			report.AddRequest(c.requestTrace(method, url, i+1, body, resp, err, time.Since(start)))
+		}
 		if err == nil {
 			// check if retry and invalid auth to replay Ping() script
 			code := resp.StatusCode()
@@ -551,6 +735,7 @@ func (c *Client) Execute(
 			code := resp.StatusCode()
 			if code == 599 || strings.Contains(err.Error(), "context deadline exceeded") {
 				err = ErrContextDeadLineExceeded
+				timeouts.WithLabelValues(target, collector).Inc()
 			} else {
 				delete(c.symtab, "response_headers")
 				delete(c.symtab, "response_cookies")
@@ -999,6 +1184,8 @@ type CallClientExecuteParams struct {
 	Parser   string
 	Trace    bool
 	Status   bool
+	// label of query_status metric; url is used when empty
+	StatusLabel string
 	// Check_invalid_Auth bool
 }
 
@@ -1072,6 +1259,9 @@ func (c *Client) callClientExecute(params *CallClientExecuteParams, symtab map[s
 					"script", ScriptName(c.symtab, c.logger),
 					"ciphertext", ciphertext)
 				auth_key := GetMapValueString(symtab, "auth_key")
+				if auth_key == "" {
+					auth_key = c.auth_key
+				}
 				c.logger.Debug(
 					"auth_key detected",
 					"coll", CollectorId(c.symtab, c.logger),
@@ -1089,6 +1279,7 @@ func (c *Client) callClientExecute(params *CallClientExecuteParams, symtab map[s
 					// level.Error(c.logger).Log("errmsg", err)
 					return err
 				}
+				redactor.AddSecrets(passwd)
 			}
 			symtab["auth_set"] = true
 			if user != "" {
@@ -1156,7 +1347,7 @@ func (c *Client) callClientExecute(params *CallClientExecuteParams, symtab map[s
 	}
 	// set status data for the query
 	if params.Status {
-		c.SetQueriesStatus(url, status_code, nil)
+		c.SetQueriesStatus(c.QueryStatusLabel(url, params.StatusLabel), status_code, nil)
 	}
 	// if params.Trace {
 	// 	c.logger.Debug(
@@ -1465,9 +1656,17 @@ func (cl *Client) Init(params *ClientInitParams) error {
 	cl.symtab["port"] = port
 	cl.symtab["base_url"] = base_url
 	cl.symtab["auth_mode"] = params.AuthConfig.Mode
-	cl.symtab["user"] = params.AuthConfig.Username
-	cl.symtab["password"] = string(params.AuthConfig.Password)
-	cl.symtab["auth_token"] = string(params.AuthConfig.Token)
+	user, password, token, err := params.AuthConfig.Values()
+	if err != nil {
+		cl.logger.Warn(
+			fmt.Sprintf("can't obtain credentials from secret provider: %s", err),
+			"coll", CollectorId(cl.symtab, cl.logger),
+			"script", ScriptName(cl.symtab, cl.logger))
+	}
+	cl.credentials = [3]string{user, password, token}
+	cl.symtab["user"] = user
+	cl.symtab["password"] = password
+	cl.symtab["auth_token"] = token
 	cl.symtab["auth_key"] = string(params.AuthConfig.authKey)
 	cl.symtab["auth_set"] = false
 	cl.symtab["verifySSL"] = verifySSL
@@ -1541,13 +1740,23 @@ func (cl *Client) Init(params *ClientInitParams) error {
 }
 
 // login to target
-func (cl *Client) Login() (bool, error) {
+func (cl *Client) Login() (logged bool, err error) {
 	set_name := cl.SetScriptName("login")
 	defer func() {
 		if set_name {
 			delete(cl.symtab, "__name__")
 		}
 	}()
+	defer func() {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		} else if !logged {
+			result = "failed"
+		}
+		target, _ := metricsIds(cl.symtab)
+		loginAttempts.WithLabelValues(target, result).Inc()
+	}()
 
 	// ** init the connection status func and symbol table
 	status := false
@@ -6,11 +6,15 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"testing"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"gopkg.in/yaml.v3"
 )
 
@@ -148,3 +152,144 @@ func TestParseTextLineResponse(t *testing.T) {
 	}
 
 }
+
+func TestParseFailureMetric(t *testing.T) {
+	client := &Client{
+		client: resty.New(),
+		symtab: map[string]any{
+			"__target_id":      "test_target",
+			"__metrics_target": "test_target",
+			"__collector_id":   "test_collector",
+		},
+		logger: slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+	}
+	raw_http := &http.Response{
+		Header: make(map[string][]string),
+	}
+	raw_http.Header.Add(contentTypeHeader, "application/json")
+	resp := &resty.Response{
+		RawResponse: raw_http,
+	}
+	resp.SetBody([]byte("{ invalid json"))
+
+	counter := parseFailures.WithLabelValues("test_target", "test_collector", "json")
+	before := testutil.ToFloat64(counter)
+	client.getResponse(resp, "json")
+	if after := testutil.ToFloat64(counter); after != before+1 {
+		t.Errorf(`getResponse() parse failures counter: got %v, want %v`, after, before+1)
+	}
+}
+
+func TestTargetMetrics(t *testing.T) {
+	if label := metricsTargetLabel(&TargetConfig{Name: "t1"}); label != "t1" {
+		t.Errorf(`metricsTargetLabel() static target: got %q, want "t1"`, label)
+	}
+	if label := metricsTargetLabel(&TargetConfig{Name: "host.domain:8443", targetType: TargetTypeDynamic}); label != dynamicTargetLabel {
+		t.Errorf(`metricsTargetLabel() dynamic target: got %q, want %q`, label, dynamicTargetLabel)
+	}
+
+	parseFailures.WithLabelValues("removed_target", "coll", "json").Inc()
+	timeouts.WithLabelValues("removed_target", "coll").Inc()
+	parseFailures.WithLabelValues("kept_target", "coll", "json").Inc()
+	// a scrape still running when the target is removed
+	scrapesInFlight.WithLabelValues("removed_target").Inc()
+	deleteTargetMetrics("removed_target")
+	scrapesInFlight.WithLabelValues("removed_target").Dec()
+	if v := testutil.ToFloat64(scrapesInFlight.WithLabelValues("removed_target")); v != 0 {
+		t.Errorf(`deleteTargetMetrics() scrapes in flight of removed target: got %v, want 0`, v)
+	}
+	scrapesInFlight.DeleteLabelValues("removed_target")
+	count := func(vec *prometheus.CounterVec, target string) int {
+		ch := make(chan prometheus.Metric, capMetricChan)
+		vec.Collect(ch)
+		close(ch)
+		n := 0
+		for metric := range ch {
+			out := &dto.Metric{}
+			metric.Write(out)
+			for _, label := range out.Label {
+				if label.GetName() == "target" && label.GetValue() == target {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if n := count(parseFailures, "removed_target") + count(timeouts, "removed_target"); n != 0 {
+		t.Errorf(`deleteTargetMetrics() series of removed target: got %d, want 0`, n)
+	}
+	if n := count(parseFailures, "kept_target"); n != 1 {
+		t.Errorf(`deleteTargetMetrics() series of other target: got %d, want 1`, n)
+	}
+	deleteTargetMetrics("kept_target")
+}
+
+func TestQueryStatusLabel(t *testing.T) {
+	var conf QueryStatusUrlConfig
+	if err := yaml.Unmarshal([]byte(`
+strip_query_string: true
+replace:
+  - regex: '/[0-9]+(/|$)'
+    replacement: '/{id}$1'
+`), &conf); err != nil {
+		t.Fatalf(`QueryStatusUrlConfig unmarshal error: %s`, err.Error())
+	}
+	client := &Client{
+		status_url: &conf,
+	}
+	tests := []struct {
+		url, label, want string
+	}{
+		{"/api/users?since=1760000000", "", "/api/users"},
+		{"/api/users/123/roles?id=5", "", "/api/users/{id}/roles"},
+		{"/api/users/123", "", "/api/users/{id}"},
+		{"/api/users/123", "user", "user"},
+	}
+	for _, test := range tests {
+		if got := client.QueryStatusLabel(test.url, test.label); got != test.want {
+			t.Errorf(`QueryStatusLabel(%q, %q) = %q, want %q`, test.url, test.label, got, test.want)
+		}
+	}
+
+	// without rules url is kept
+	client.status_url = nil
+	if got := client.QueryStatusLabel("/api/users?since=1", ""); got != "/api/users?since=1" {
+		t.Errorf(`QueryStatusLabel() without rules = %q`, got)
+	}
+}
+
+func TestAuthKey(t *testing.T) {
+	key_file := filepath.Join(t.TempDir(), "auth_key")
+	if err := os.WriteFile(key_file, []byte("0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var auth AuthConfig
+	if err := yaml.Unmarshal([]byte("user: admin\nauth_key_file: "+key_file), &auth); err != nil {
+		t.Fatal(err)
+	}
+	if auth.authKey != "0123456789abcdef" {
+		t.Errorf(`auth_key_file: expected "0123456789abcdef", got %q`, auth.authKey)
+	}
+	if err := yaml.Unmarshal([]byte("auth_key_file: /nonexistent/auth_key"), &auth); err == nil {
+		t.Errorf("auth_key_file: expected error for missing file")
+	}
+
+	globals := &GlobalConfig{AuthKeyHeader: authKeyHeader}
+	req, _ := http.NewRequest("GET", "/metrics?target=test&auth_key=from_param", nil)
+	if key, err := authKeyFor(req, globals); err != nil || key != "from_param" {
+		t.Errorf(`authKeyFor() param: expected "from_param", got %q (%v)`, key, err)
+	}
+	req.Header.Set(authKeyHeader, "from_header")
+	if key, err := authKeyFor(req, globals); err != nil || key != "from_header" {
+		t.Errorf(`authKeyFor() header: expected "from_header", got %q (%v)`, key, err)
+	}
+
+	globals.DisableAuthKeyParam = true
+	if key, err := authKeyFor(req, globals); err != nil || key != "from_header" {
+		t.Errorf(`authKeyFor() header with param disabled: expected "from_header", got %q (%v)`, key, err)
+	}
+	req.Header.Del(authKeyHeader)
+	if _, err := authKeyFor(req, globals); err == nil {
+		t.Errorf("authKeyFor() param disabled: expected error")
+	}
+}
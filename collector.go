@@ -7,11 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"strings"
 
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mitchellh/copystructure"
 	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Collector is a self-contained group of http queries and metric families to collect from results. It is
@@ -27,6 +33,8 @@ type Collector interface {
 	SetLogger(*slog.Logger)
 	SetSetStats(Target)
 	SetQueriesStatus(client *Client, queries_status map[string]any, status int)
+	GetTimeout() time.Duration
+	GetDuration() time.Duration
 }
 
 // collector implements Collector. It wraps a collection of queries, metrics and the database to collect them from.
@@ -38,6 +46,8 @@ type collector struct {
 	collect_script []*YAMLScript
 	// metricFamilies []*MetricFamily
 	status int
+	// duration of the last collect
+	duration time.Duration
 
 	// to protect the data during exchange
 	content_mutex *sync.Mutex
@@ -51,6 +61,9 @@ const (
 	CollectorStatusTimeout
 )
 
+// errCollectorTimeout is the cause of the context of a collector that has reached its own timeout.
+var errCollectorTimeout = errors.New("collector timeout")
+
 // NewCollector returns a new Collector with the given configuration and database. The metrics it creates will all have
 // the provided const labels applied.
 func NewCollector(
@@ -129,9 +142,14 @@ func (c *collector) SetQueriesStatus(client *Client, queries_status map[string]a
 		for _, act := range sc.queryActions {
 			if act.Type() == query_action {
 				if bool(act.Query.Status) {
-					if act.Query.query.vartype == field_raw {
+					// only static labels or urls can be set before the query is played
+					if act.Query.status_label != nil {
+						if act.Query.status_label.vartype == field_raw {
+							client.SetQueriesStatus(act.Query.status_label.raw, status, queries_status)
+						}
+					} else if act.Query.query.vartype == field_raw {
 						url := act.Query.query.raw
-						client.SetQueriesStatus(url, status, queries_status)
+						client.SetQueriesStatus(client.QueryStatusLabel(url, ""), status, queries_status)
 					}
 				}
 			}
@@ -157,6 +175,18 @@ func (c *collector) SetStatus(status int) {
 	c.status = status
 }
 
+// GetTimeout implement GetTimeout for collector
+// obtain the specific timeout of collector; 0 if not set
+func (c *collector) GetTimeout() time.Duration {
+	return time.Duration(c.config.Timeout)
+}
+
+// GetDuration implement GetDuration for collector
+// obtain the duration of the last collect
+func (c *collector) GetDuration() time.Duration {
+	return c.duration
+}
+
 func (c *collector) SetLogger(logger *slog.Logger) {
 	c.content_mutex.Lock()
 	c.logger = logger
@@ -190,11 +220,36 @@ func (c *collector) Collect(ctx context.Context, metric_ch chan<- Metric, coll_c
 	var (
 		reset_coll_id bool = false
 		status        int  = CollectorStatusError
+		start              = time.Now()
 	)
 
+	ctx, span := tracer.Start(ctx, "collector "+c.config.Name,
+		trace.WithAttributes(attribute.String("collector", c.config.Name)))
+	defer func() {
+		c.duration = time.Since(start)
+		span.SetAttributes(attribute.Int("status", c.status))
+		span.End()
+	}()
+
+	// queries are run with the collector context: it may have a specific timeout
+	c.client.SetContext(ctx)
+	if tracingEnabled {
+		c.client.symtab[traceContextKey] = ctx
+		defer delete(c.client.symtab, traceContextKey)
+	}
 	c.client.symtab["__method"] = c.client.callClientExecute
 	c.client.symtab["__metric_channel"] = metric_ch
 	c.client.symtab["__coll_channel"] = coll_ch
+	// params of the collector for the target: copied so that scripts can't modify them for next collects.
+	if len(c.config.Params) > 0 {
+		if params, err := copystructure.Copy(c.config.Params); err == nil {
+			maps.Copy(c.client.symtab, params.(map[string]any))
+		} else {
+			c.logger.Warn(
+				fmt.Sprintf("can't copy params of collector: %s", err),
+				"coll", c.config.Name)
+		}
+	}
 
 	cid := GetMapValueString(c.client.symtab, "__collector_id")
 	if cid == "" {
@@ -208,14 +263,33 @@ func (c *collector) Collect(ctx context.Context, metric_ch chan<- Metric, coll_c
 		c.logger.Debug(
 			fmt.Sprintf("starting script '%s/%s'", c.config.Name, scr.name),
 			"coll", CollectorId(c.client.symtab, c.logger))
-		if err := scr.Play(c.client.symtab, false, c.logger); err != nil {
+		err := ctx.Err()
+		if err == context.DeadlineExceeded {
+			// timeout already reached: don't start the script
+			err = ErrContextDeadLineExceeded
+			timeouts.WithLabelValues(metricsIds(c.client.symtab)).Inc()
+		} else {
+			err = scr.Play(c.client.symtab, false, c.logger)
+		}
+		if err != nil {
 			switch err {
 			case ErrInvalidLogin:
 				status = CollectorStatusInvalidLogin
 				coll_ch <- MsgLogin
 			case ErrContextDeadLineExceeded:
 				status = CollectorStatusTimeout
-				coll_ch <- MsgTimeout
+				// the scrape deadline may be reached before the collector timeout: the target must stop.
+				scrape_done := ctx.Err() != nil && context.Cause(ctx) != errCollectorTimeout
+				if c.GetTimeout() > 0 && !scrape_done {
+					// only this collector has reached its own timeout: MsgDone is sent below
+					// so that the target doesn't stop the other collectors.
+					c.logger.Warn(
+						fmt.Sprintf("collector has reached its timeout (%s)", c.GetTimeout()),
+						"coll", CollectorId(c.client.symtab, c.logger),
+						"script", ScriptName(c.client.symtab, c.logger))
+				} else {
+					coll_ch <- MsgTimeout
+				}
 			default:
 				c.logger.Warn(
 					err.Error(),
@@ -256,9 +330,11 @@ func (c *collector) Collect(ctx context.Context, metric_ch chan<- Metric, coll_c
 // newCachingCollector returns a new Collector wrapping the provided raw Collector.
 func newCachingCollector(rawColl *collector) Collector {
 	cc := &cachingCollector{
-		rawColl:     rawColl,
-		minInterval: time.Duration(rawColl.config.MinInterval),
-		cacheSem:    make(chan time.Time, 1),
+		rawColl:      rawColl,
+		minInterval:  time.Duration(rawColl.config.MinInterval),
+		maxStaleness: time.Duration(rawColl.config.MaxStaleness),
+		serveStale:   bool(rawColl.config.ServeStale),
+		cacheSem:     make(chan time.Time, 1),
 	}
 	cc.cacheSem <- time.Time{}
 	return cc
@@ -270,11 +346,46 @@ type cachingCollector struct {
 	rawColl *collector
 	// Convenience copy of rawColl.config.MinInterval.
 	minInterval time.Duration
+	// Convenience copy of rawColl.config.MaxStaleness: max age of a series not refreshed by the last collection.
+	maxStaleness time.Duration
+	// Convenience copy of rawColl.config.ServeStale: serve the last good cache when collection fails.
+	serveStale bool
 
 	// Used as a non=blocking semaphore protecting the cache. The value in the channel is the time of the cached metrics.
 	cacheSem chan time.Time
-	// Metrics saved from the last Collect() call.
-	cache []Metric
+	// Metrics saved from the last Collect() calls, with the time they were collected.
+	cache []*cachedMetric
+	// time of the last collection stored in cache, in unix nanoseconds; 0 if not set. Atomic since CacheAge() is read
+	// without holding cacheSem.
+	cacheTime atomic.Int64
+	// duration of the last collect
+	duration time.Duration
+}
+
+// cachedMetric is a series in cache with the time of the collection that produced it.
+type cachedMetric struct {
+	key    string
+	metric Metric
+	time   time.Time
+}
+
+// seriesKey returns a key that identifies the series of the metric: its name and its labels;
+// empty if metric is invalid.
+func seriesKey(metric Metric) string {
+	desc := metric.Desc()
+	if desc == nil {
+		return ""
+	}
+	out := &dto.Metric{}
+	if err := metric.Write(out); err != nil {
+		return ""
+	}
+	var key strings.Builder
+	key.WriteString(desc.Name())
+	for _, label := range out.Label {
+		key.WriteString(fmt.Sprintf("\xff%s=%s", label.GetName(), label.GetValue()))
+	}
+	return key.String()
 }
 
 // SetClient implement SetClient()for cachingCollector
@@ -317,6 +428,26 @@ func (cc *cachingCollector) SetQueriesStatus(client *Client, queries_status map[
 	cc.rawColl.SetQueriesStatus(client, queries_status, status)
 }
 
+// GetTimeout implement GetTimeout for cachingCollector
+func (cc *cachingCollector) GetTimeout() time.Duration {
+	return cc.rawColl.GetTimeout()
+}
+
+// GetDuration implement GetDuration for cachingCollector
+// obtain the duration of the last collect, including when cached metrics are returned
+func (cc *cachingCollector) GetDuration() time.Duration {
+	return cc.duration
+}
+
+// CacheAge returns the age of the cached metrics; false if nothing has been collected yet.
+func (cc *cachingCollector) CacheAge() (time.Duration, bool) {
+	cacheTime := cc.cacheTime.Load()
+	if cacheTime == 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, cacheTime)), true
+}
+
 // Collect implements Collector.
 func (cc *cachingCollector) Collect(ctx context.Context, ch chan<- Metric, coll_ch chan<- int) {
 	if ctx.Err() != nil {
@@ -325,6 +456,9 @@ func (cc *cachingCollector) Collect(ctx context.Context, ch chan<- Metric, coll_
 	}
 
 	collTime := time.Now()
+	defer func() {
+		cc.duration = time.Since(collTime)
+	}()
 	select {
 	case cacheTime := <-cc.cacheSem:
 		// Have the lock.
@@ -337,16 +471,39 @@ func (cc *cachingCollector) Collect(ctx context.Context, ch chan<- Metric, coll_
 				cc.minInterval.Seconds(), age.Seconds()))
 			cc.rawColl.logger.Debug("multilevel", logCtx...)
 			cacheChan := make(chan Metric, capMetricChan)
-			cc.cache = make([]Metric, 0, len(cc.cache))
+			fresh := make([]Metric, 0, len(cc.cache))
 			go func() {
 				cc.rawColl.Collect(ctx, cacheChan, coll_ch)
 				close(cacheChan)
 			}()
 			for metric := range cacheChan {
-				cc.cache = append(cc.cache, metric)
-				ch <- metric
+				fresh = append(fresh, metric)
+				// when last good cache may be served, wait for the collection status before sending metrics.
+				if !cc.serveStale {
+					ch <- metric
+				}
+			}
+			if cc.serveStale && cc.rawColl.status != CollectorStatusOk && len(cc.cache) > 0 {
+				// collection has failed: drop the partial data and serve the last good cache.
+				var logCtx []interface{}
+
+				logCtx = append(logCtx, cc.rawColl.logContext...)
+				logCtx = append(logCtx, "msg", fmt.Sprintf("Collection failed, returning last good cached metrics: cache_age=%.3fs", age.Seconds()))
+				cc.rawColl.logger.Debug("multilevel", logCtx...)
+				cc.sendCache(ch, collTime)
+			} else {
+				if cc.serveStale {
+					for _, metric := range fresh {
+						ch <- metric
+					}
+				}
+				// send the series of the previous collections that are not stale, and store them with fresh ones.
+				for _, cached := range cc.updateCache(fresh, collTime) {
+					ch <- cached.metric
+				}
+				cacheTime = collTime
+				cc.cacheTime.Store(collTime.UnixNano())
 			}
-			cacheTime = collTime
 		} else {
 			var logCtx []interface{}
 
@@ -354,9 +511,9 @@ func (cc *cachingCollector) Collect(ctx context.Context, ch chan<- Metric, coll_
 			logCtx = append(logCtx, "msg", fmt.Sprintf("Returning cached metrics: min_interval=%.3fs cache_age=%.3fs",
 				cc.minInterval.Seconds(), age.Seconds()))
 			cc.rawColl.logger.Debug("multilevel", logCtx...)
-			for _, metric := range cc.cache {
-				ch <- metric
-			}
+			cc.sendCache(ch, collTime)
+			// tell calling target that this collector is over.
+			coll_ch <- MsgDone
 		}
 		// Always replace the value in the semaphore channel.
 		cc.cacheSem <- cacheTime
@@ -367,3 +524,43 @@ func (cc *cachingCollector) Collect(ctx context.Context, ch chan<- Metric, coll_
 		ch <- NewInvalidMetric(cc.rawColl.logContext, ctx.Err())
 	}
 }
+
+// sendCache sends the cached series that are not stale.
+func (cc *cachingCollector) sendCache(ch chan<- Metric, now time.Time) {
+	for _, cached := range cc.cache {
+		if cc.maxStaleness > 0 && now.Sub(cached.time) > cc.maxStaleness {
+			continue
+		}
+		ch <- cached.metric
+	}
+}
+
+// updateCache replaces the cache by the fresh metrics collected at now; with max_staleness, series of previous
+// collections that are not refreshed are kept until they are stale.
+// It returns the previous series that are kept.
+func (cc *cachingCollector) updateCache(fresh []Metric, now time.Time) []*cachedMetric {
+	var kept []*cachedMetric
+
+	cache := make([]*cachedMetric, 0, len(fresh))
+	keys := make(map[string]bool, len(fresh))
+	for _, metric := range fresh {
+		key := seriesKey(metric)
+		if key == "" {
+			// invalid metrics are not cached
+			continue
+		}
+		keys[key] = true
+		cache = append(cache, &cachedMetric{key: key, metric: metric, time: now})
+	}
+	if cc.maxStaleness > 0 {
+		for _, cached := range cc.cache {
+			if keys[cached.key] || now.Sub(cached.time) > cc.maxStaleness {
+				continue
+			}
+			cache = append(cache, cached)
+			kept = append(kept, cached)
+		}
+	}
+	cc.cache = cache
+	return kept
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CollectorRef is a reference to collectors in a target config: a collector name or a pattern ("~ regex" or
+// "!~ regex"), or a map with the name of a collector, the params its scripts are played with, and an alias to
+// reference the same collector several times with different params.
+type CollectorRef struct {
+	Name   string         `yaml:"name" json:"name"`
+	Alias  string         `yaml:"alias,omitempty" json:"alias,omitempty"`   // name of the collector instance; default is Name
+	Params map[string]any `yaml:"params,omitempty" json:"params,omitempty"` // values of the params declared by the collector
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for CollectorRef.
+func (ref *CollectorRef) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&ref.Name)
+	}
+	type plain CollectorRef
+	if err := value.Decode((*plain)(ref)); err != nil {
+		return err
+	}
+	if ref.Name == "" {
+		return fmt.Errorf("collector reference without name")
+	}
+	if len(ref.Params) > 0 || ref.Alias != "" {
+		if strings.HasPrefix(ref.Name, "~") || strings.HasPrefix(ref.Name, "!~") {
+			return fmt.Errorf("params or alias can't be set for collector pattern '%s'", ref.Name)
+		}
+	}
+	return checkOverflow(ref.XXX, "collectors")
+}
+
+// MarshalYAML implements the yaml.Marshaler interface for CollectorRef: a reference without params is output as
+// its name.
+func (ref CollectorRef) MarshalYAML() (interface{}, error) {
+	if len(ref.Params) == 0 && ref.Alias == "" {
+		return ref.Name, nil
+	}
+	type plain CollectorRef
+	return (plain)(ref), nil
+}
+
+// MarshalJSON implements the json.Marshaler interface for CollectorRef: a reference without params is output as
+// its name.
+func (ref CollectorRef) MarshalJSON() ([]byte, error) {
+	if len(ref.Params) == 0 && ref.Alias == "" {
+		return json.Marshal(ref.Name)
+	}
+	type plain CollectorRef
+	return json.Marshal((plain)(ref))
+}
+
+// instanceName returns the name of the collector referenced by ref in the target: its alias if set.
+func (ref CollectorRef) instanceName() string {
+	if ref.Alias != "" {
+		return ref.Alias
+	}
+	return ref.Name
+}
+
+// collectorRefNames returns the references of collectors names.
+func collectorRefNames(names ...string) []CollectorRef {
+	refs := make([]CollectorRef, len(names))
+	for idx, name := range names {
+		refs[idx] = CollectorRef{Name: name}
+	}
+	return refs
+}
+
+// restrictCollectorRefs returns the references of refs to collector name, or a reference to name if none.
+func restrictCollectorRefs(refs []CollectorRef, name string) []CollectorRef {
+	res := slices.DeleteFunc(slices.Clone(refs), func(ref CollectorRef) bool { return ref.Name != name && ref.Alias != name })
+	if len(res) == 0 {
+		res = collectorRefNames(name)
+	}
+	return res
+}
+
+// withParams returns an instance of collector c named alias whose params are the defaults of c overridden by
+// params.
+func (c *CollectorConfig) withParams(alias string, params map[string]any) (*CollectorConfig, error) {
+	for name := range params {
+		if _, ok := c.Params[name]; !ok {
+			return nil, fmt.Errorf("unknown param '%s' for collector '%s'", name, c.Name)
+		}
+	}
+	inst := *c
+	if alias != "" {
+		inst.Name = alias
+	}
+	inst.Params = make(map[string]any, len(c.Params))
+	maps.Copy(inst.Params, c.Params)
+	maps.Copy(inst.Params, params)
+	return &inst, nil
+}
+
+func resolveCollectorRefs(
+	collectorRefs []CollectorRef, collectors map[string]*CollectorConfig, ctx string) ([]*CollectorConfig, error) {
+	resolved := make([]*CollectorConfig, 0, len(collectorRefs))
+	instances := make(map[string]bool)
+	for _, ref := range collectorRefs {
+		cref := ref.Name
+		// check if cref(a collector name) is a pattern or not
+		if strings.HasPrefix(cref, "~") {
+			pat := regexp.MustCompile(strings.TrimSpace(cref[1:]))
+			for c_name, c := range collectors {
+				if pat.MatchString(c_name) {
+					resolved = append(resolved, c)
+				}
+			}
+		} else if strings.HasPrefix(cref, "!~") {
+			pat := regexp.MustCompile(strings.TrimSpace(cref[2:]))
+			for c_name, c := range collectors {
+				if !pat.MatchString(c_name) {
+					resolved = append(resolved, c)
+				}
+			}
+		} else {
+			c, found := collectors[cref]
+			if !found {
+				return nil, fmt.Errorf("unknown collector %q referenced in %s", cref, ctx)
+			}
+			if len(ref.Params) > 0 || ref.Alias != "" {
+				var err error
+				if c, err = c.withParams(ref.Alias, ref.Params); err != nil {
+					return nil, fmt.Errorf("%s in %s", err, ctx)
+				}
+				instances[c.Name] = true
+			}
+			resolved = append(resolved, c)
+		}
+	}
+	// instances with params must be distinguishable: their name is the collectorname label of status metrics.
+	count := make(map[string]int, len(resolved))
+	for _, c := range resolved {
+		count[c.Name]++
+		if count[c.Name] > 1 && instances[c.Name] {
+			return nil, fmt.Errorf("collector %q referenced several times in %s: set an alias", c.Name, ctx)
+		}
+	}
+	return resolved, nil
+}
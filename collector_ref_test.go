@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCollectorRefs(t *testing.T) {
+	config := `
+- status
+- name: page
+  params:
+    path: /server-status
+- name: page
+  alias: page_info
+  params:
+    path: /server-info
+    label: info
+- ~ ^other_
+`
+	var refs []CollectorRef
+	if err := yaml.Unmarshal([]byte(config), &refs); !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, checkCollectorRefs(refs, "test"))
+
+	collectors := map[string]*CollectorConfig{
+		"status":  {Name: "status"},
+		"page":    {Name: "page", Params: map[string]any{"path": "/", "label": "default"}},
+		"other_1": {Name: "other_1"},
+	}
+	resolved, err := resolveCollectorRefs(refs, collectors, "target")
+	if !assert.NoError(t, err) || !assert.Len(t, resolved, 4) {
+		return
+	}
+	assert.Same(t, collectors["status"], resolved[0])
+	assert.Equal(t, "page", resolved[1].Name)
+	assert.Equal(t, map[string]any{"path": "/server-status", "label": "default"}, resolved[1].Params)
+	assert.Equal(t, "page_info", resolved[2].Name)
+	assert.Equal(t, map[string]any{"path": "/server-info", "label": "info"}, resolved[2].Params)
+	assert.Same(t, collectors["other_1"], resolved[3])
+	// defaults of the collector are not modified
+	assert.Equal(t, map[string]any{"path": "/", "label": "default"}, collectors["page"].Params)
+
+	buf, err := json.Marshal(refs[:2])
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `["status", {"name": "page", "params": {"path": "/server-status"}}]`, string(buf))
+	}
+
+	// errors
+	_, err = resolveCollectorRefs([]CollectorRef{{Name: "page", Params: map[string]any{"unknown": 1}}}, collectors, "target")
+	assert.ErrorContains(t, err, "unknown param 'unknown'")
+	_, err = resolveCollectorRefs([]CollectorRef{{Name: "page"}, {Name: "page", Params: map[string]any{"path": "/x"}}}, collectors, "target")
+	assert.ErrorContains(t, err, "set an alias")
+	assert.Error(t, checkCollectorRefs([]CollectorRef{{Name: "page"}, {Name: "page", Params: map[string]any{"path": "/x"}}}, "target"))
+	assert.Error(t, yaml.Unmarshal([]byte(`[{name: "~ ^page", params: {path: /x}}]`), &refs))
+	assert.Error(t, yaml.Unmarshal([]byte(`[{name: page, param: {path: /x}}]`), &refs))
+
+	// params can't replace the symbols of the exporter
+	assert.NoError(t, yaml.Unmarshal([]byte("collector_name: page\nparams: {path: /}\n"), &CollectorConfig{}))
+	for _, name := range []string{"host", "headers", "auth_key", "__collector_id", "__anything"} {
+		err := yaml.Unmarshal([]byte("collector_name: page\nparams: {"+name+": x}\n"), &CollectorConfig{})
+		assert.ErrorContains(t, err, "param '"+name+"' is a reserved name")
+	}
+}
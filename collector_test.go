@@ -0,0 +1,138 @@
+// cSpell:ignore stretchr
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachingCollectorStaleness(t *testing.T) {
+	desc := NewAutomaticMetricDesc(nil, "test_metric", "test metric", dto.MetricType_GAUGE, nil, "name")
+	series := func(name string, value float64) Metric {
+		return NewMetric(desc, value, []string{"name"}, []string{name})
+	}
+	collect := func(cc *cachingCollector, now time.Time) map[string]float64 {
+		ch := make(chan Metric, capMetricChan)
+		cc.sendCache(ch, now)
+		close(ch)
+		res := make(map[string]float64)
+		for metric := range ch {
+			out := &dto.Metric{}
+			metric.Write(out)
+			res[out.Label[0].GetValue()] = out.Gauge.GetValue()
+		}
+		return res
+	}
+
+	now := time.Now()
+	cc := &cachingCollector{
+		maxStaleness: 5 * time.Minute,
+	}
+	// first collection: a and b
+	kept := cc.updateCache([]Metric{series("a", 1), series("b", 1)}, now)
+	assert.Equal(t, 0, len(kept))
+
+	// second collection failed half-way: only a is refreshed; b is kept because it is not stale.
+	kept = cc.updateCache([]Metric{series("a", 2), NewInvalidMetric(nil, nil)}, now.Add(time.Minute))
+	assert.Equal(t, 1, len(kept))
+	assert.Equal(t, map[string]float64{"a": 2, "b": 1}, collect(cc, now.Add(2*time.Minute)))
+
+	// b is older than max_staleness: it is dropped.
+	assert.Equal(t, map[string]float64{"a": 2}, collect(cc, now.Add(6*time.Minute)))
+	kept = cc.updateCache([]Metric{series("a", 3)}, now.Add(6*time.Minute))
+	assert.Equal(t, 0, len(kept))
+	assert.Equal(t, 1, len(cc.cache))
+
+	// without max_staleness, series not refreshed are dropped at once.
+	cc.maxStaleness = 0
+	cc.updateCache([]Metric{series("c", 1)}, now.Add(7*time.Minute))
+	assert.Equal(t, map[string]float64{"c": 1}, collect(cc, now.Add(time.Hour)))
+}
+
+func TestCachingCollectorCacheAge(t *testing.T) {
+	cc := &cachingCollector{}
+	_, ok := cc.CacheAge()
+	assert.False(t, ok)
+
+	// CacheAge is read by scrapes while another one stores a collection.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			cc.CacheAge()
+		}
+	}()
+	for range 100 {
+		cc.cacheTime.Store(time.Now().Add(-time.Minute).UnixNano())
+	}
+	<-done
+	age, ok := cc.CacheAge()
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, age, time.Minute)
+}
+
+func TestCollectorTimeout(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	c := &collector{
+		config: &CollectorConfig{
+			Name:    "test",
+			Timeout: model.Duration(time.Millisecond),
+		},
+		client: &Client{
+			symtab: map[string]any{},
+			logger: logger,
+		},
+		logger:         logger,
+		collect_script: []*YAMLScript{{name: "test"}},
+		content_mutex:  &sync.Mutex{},
+	}
+	assert.Equal(t, time.Millisecond, c.GetTimeout())
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), c.GetTimeout(), errCollectorTimeout)
+	defer cancel()
+	<-ctx.Done()
+
+	met_ch := make(chan Metric, capMetricChan)
+	coll_ch := make(chan int, capCollectChan)
+	c.Collect(ctx, met_ch, coll_ch)
+	close(coll_ch)
+
+	// collector with its own timeout: status is Timeout but target must not be stopped.
+	assert.Equal(t, CollectorStatusTimeout, c.GetStatus())
+	msgs := []int{}
+	for msg := range coll_ch {
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, []int{MsgDone}, msgs)
+	// client queries are run with the collector context
+	deadline, _ := ctx.Deadline()
+	client_deadline, ok := c.client.ctx.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, deadline, client_deadline)
+
+	// scrape deadline reached before the collector timeout: target must be stopped.
+	scrape_ctx, scrape_cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer scrape_cancel()
+	ctx, cancel = context.WithTimeoutCause(scrape_ctx, time.Hour, errCollectorTimeout)
+	defer cancel()
+	<-ctx.Done()
+
+	coll_ch = make(chan int, capCollectChan)
+	c.Collect(ctx, met_ch, coll_ch)
+	close(coll_ch)
+	assert.Equal(t, CollectorStatusTimeout, c.GetStatus())
+	msgs = []int{}
+	for msg := range coll_ch {
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, []int{MsgTimeout, MsgDone}, msgs)
+}
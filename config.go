@@ -4,6 +4,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"maps"
@@ -13,6 +14,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -26,30 +29,68 @@ import (
 // Load attempts to parse the given config file and return a Config object.
 func LoadConfig(
 	configFile string,
+	environment string,
 	logger *slog.Logger,
 	collectorName string,
 	registry *goja_modules.JSRegistry) (*Config, error) {
 	logger.Info(fmt.Sprintf("Loading configuration from %s", configFile))
-	buf, err := os.ReadFile(configFile)
+	loader := &configLoader{}
+	node, err := loader.load(configFile, environment)
 	if err != nil {
 		return nil, err
 	}
+	if loader.overlay != "" {
+		logger.Info(fmt.Sprintf("Loaded configuration overlay %s", loader.overlay))
+	}
 
 	c := Config{
 		configFile:    configFile,
+		environment:   environment,
+		includedFiles: loader.files[1:],
+		nodeFiles:     loader.nodeFiles,
 		logger:        logger,
 		collectorName: collectorName,
 		registry:      registry,
 	}
 
-	err = yaml.Unmarshal(buf, &c)
+	err = node.Decode(&c)
+	// only used to parse the config
+	c.nodeFiles = nil
 	if err != nil {
 		return nil, err
 	}
-
 	return &c, nil
 }
 
+// applyGlobals sets the process wide settings of config: redaction keys and config of secret providers. It must
+// only be called when config is applied, so that a rejected reload keeps the current ones.
+func (c *Config) applyGlobals() error {
+	if c.Globals == nil {
+		return nil
+	}
+	if err := redactor.SetKeys(c.Globals.RedactKeys); err != nil {
+		return err
+	}
+	secretsConfig.Store(c.Globals.Secrets)
+	return nil
+}
+
+// symbols set by the exporter, or read by it when set by scripts.
+var builtinSymbols = []string{
+	"APIEndPoint", "auth_key", "auth_mode", "auth_set", "auth_token", "base_url", "check_invalid_auth",
+	"collector_name", "cookies", "headers", "host", "item", "logged", "loop_var_idx", "passwd", "password", "port",
+	"proxyUrl", "queryRetry", "query_status", "response_cookies", "response_headers", "root", "scheme", "set_stats",
+	"status_code", "timeout", "trace_infos", "uri", "user", "verifySSL", "_root",
+}
+
+// nodeFile returns the file node is read from: the config file, one of the files it includes or its overlay.
+func (c *Config) nodeFile(node *yaml.Node) string {
+	if file, ok := c.nodeFiles[node]; ok {
+		return file
+	}
+	return c.configFile
+}
+
 // type httpAPIConfig map[string]*YAMLScript
 // type Profile map[string]*YAMLScript
 type ScriptConfig map[string]*YAMLScript
@@ -67,7 +108,7 @@ type Config struct {
 	Globals        *GlobalConfig             `yaml:"global"`
 	CollectorFiles []string                  `yaml:"collector_files,omitempty"`
 	Targets        []*TargetConfig           `yaml:"targets,omitempty"`
-	Collectors     []*CollectorConfig        `yaml:"collectors,omitempty"`
+	Collectors     []*CollectorConfig        `yaml:"-"` // parsed with the files they are read from
 	Profiles       map[string]*profileParser `yaml:"profiles"`
 	ProfileFiles   []string                  `yaml:"profiles_file_config"`
 	AuthConfigs    map[string]*AuthConfig    `yaml:"auth_configs,omitempty"`
@@ -75,29 +116,65 @@ type Config struct {
 	HttpAPIConfigOld map[string]yaml.Node `yaml:"httpapi_config"`
 
 	configFile string
-	logger     *slog.Logger
+	// environment of the overlay of config file: config.<environment>.yml
+	environment string
+	// files included by config file and its overlay file
+	includedFiles []string
+	// file of each node of the config, its includes and its overlay; only set while parsing.
+	nodeFiles map[*yaml.Node]string
+	logger    *slog.Logger
 	// collectorName is a restriction: collectors set for a target are replaced by this only one.
 	collectorName string
 	collectors    map[string]*CollectorConfig
 	profiles      map[string]*Profile
-	registry      *goja_modules.JSRegistry
+	// names of abstract profiles: they can't be used by targets
+	abstractProfiles map[string]bool
+	registry         *goja_modules.JSRegistry
+	// targets_files globs of pseudo targets: they are removed from Targets after loading.
+	targetsFiles []string
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface for Config.
-func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
 	type plain Config
 
-	if err := unmarshal((*plain)(c)); err != nil {
+	if err := value.Decode((*plain)(c)); err != nil {
 		return err
 	}
+	// collectors are parsed below
+	delete(c.XXX, "collectors")
 
 	if len(c.Targets) == 0 {
 		return fmt.Errorf("at least one target in `targets` must be defined")
 	}
 
+	// collectors defined in config, or in the files it includes.
+	if node := mapValue(value, "collectors"); node != nil {
+		var nodes []*yaml.Node
+		switch node.Kind {
+		case yaml.SequenceNode:
+			nodes = node.Content
+		case yaml.ScalarNode:
+			// null
+		default:
+			return fmt.Errorf("collectors must be a list of collectors")
+		}
+		for _, node := range nodes {
+			cc := &CollectorConfig{
+				symtab:   map[string]any{},
+				registry: c.registry,
+				file:     c.nodeFile(node),
+			}
+			if err := node.Decode(cc); err != nil {
+				return fmt.Errorf("reading %s: %s", cc.file, err)
+			}
+			c.Collectors = append(c.Collectors, cc)
+		}
+	}
+
 	// Load any externally defined collectors.
 	if err := c.loadCollectorFiles(); err != nil {
 		return err
@@ -156,6 +233,7 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			if err != nil {
 				return err
 			}
+			c.targetsFiles = append(c.targetsFiles, t.TargetsFiles...)
 		} else {
 			c.logger.Info(fmt.Sprintf("static target '%s' found", t.Name))
 		}
@@ -199,8 +277,7 @@ collectors:
 	for _, t := range c.Targets {
 		// substitute the collector names list set in config by the value forced in command line argument
 		if c.collectorName != "" {
-			t.CollectorRefs = nil
-			t.CollectorRefs = append(t.CollectorRefs, c.collectorName)
+			t.CollectorRefs = restrictCollectorRefs(t.CollectorRefs, c.collectorName)
 		}
 		cs, err := resolveCollectorRefs(t.CollectorRefs, colls, fmt.Sprintf("target %q", t.Name))
 		if err != nil {
@@ -238,7 +315,7 @@ collectors:
 		}
 		if len(c.HttpAPIConfigOld) > 0 {
 			var err error
-			profile.Scripts, err = build_YAMLScript(c.registry, c.HttpAPIConfigOld)
+			profile.Scripts, err = build_YAMLScript(c.registry, "", nil, c.HttpAPIConfigOld)
 			if err != nil {
 				return err
 			}
@@ -246,33 +323,32 @@ collectors:
 		c.profiles["default"] = profile
 	}
 
-	// build profiles from config definition
-	profiles := make(map[string]*Profile)
-	for profile_name, profile := range c.Profiles {
-		tmp := &Profile{
-			MetricPrefix: profile.MetricPrefix,
-			registry:     c.registry,
-		}
-		if len(profile.ScriptsNodes) > 0 {
-			var err error
-			tmp.Scripts, err = build_YAMLScript(c.registry, profile.ScriptsNodes)
-			if err != nil {
-				return err
-			}
+	// collect profiles definitions from config and from profile files: they are built once their extends are
+	// resolved, so that a profile can extend one defined in another file.
+	parsers := make(map[string]*profileParser, len(c.Profiles))
+	// profiles defined in config, or in the files it includes: their actions keep the files they are read from.
+	for name, parser := range c.Profiles {
+		if node := mapValue(mapValue(value, "profiles"), name); node != nil {
+			parser.file = c.nodeFile(node)
 		}
-		profiles[profile_name] = tmp
+		parser.nodeFiles = c.nodeFiles
+	}
+	maps.Copy(parsers, c.Profiles)
+	// Load any externally defined profiles.
+	if err := c.loadProfileFiles(parsers); err != nil {
+		return err
 	}
+	profiles, abstract, err := buildProfiles(c.registry, parsers)
+	if err != nil {
+		return err
+	}
+	c.abstractProfiles = abstract
 	if c.profiles == nil {
 		c.profiles = profiles
 	} else {
 		maps.Copy(c.profiles, profiles)
 	}
 
-	// Load any externally defined profiles.
-	if err := c.loadProfileFiles(); err != nil {
-		return err
-	}
-
 	// check each profile scripts:
 	for profile_name, profile := range c.profiles {
 		if profile.MetricPrefix == "" {
@@ -350,6 +426,9 @@ ret`,
 		if t.QueryRetry == -1 {
 			t.QueryRetry = c.Globals.QueryRetry
 		}
+		if c.abstractProfiles[t.ProfileName] {
+			return fmt.Errorf("profile %q of target %q is abstract: it can only be extended", t.ProfileName, t.Name)
+		}
 		if profile, found := c.profiles[t.ProfileName]; !found {
 			if default_profile != nil {
 				var msg string
@@ -383,6 +462,80 @@ func (c *Config) FindAuthConfig(auth_name string) *AuthConfig {
 	return auth
 }
 
+// SetupTarget resolves the collectors, auth config and profile of a target added at runtime, and sets its default
+// values from globals, as it is done for targets loaded from config files.
+func (c *Config) SetupTarget(t *TargetConfig) error {
+	if len(t.TargetsFiles) > 0 {
+		return fmt.Errorf("targets_files can't be set for target '%s'", t.Name)
+	}
+	if c.collectorName != "" {
+		t.CollectorRefs = restrictCollectorRefs(t.CollectorRefs, c.collectorName)
+	}
+	cs, err := resolveCollectorRefs(t.CollectorRefs, c.collectors, fmt.Sprintf("target %q", t.Name))
+	if err != nil {
+		return err
+	}
+	if len(cs) == 0 {
+		return fmt.Errorf("target %s has no collector defined", t.Name)
+	}
+	t.collectors = cs
+
+	if t.AuthName != "" {
+		auth := c.FindAuthConfig(t.AuthName)
+		if auth == nil {
+			return fmt.Errorf("auth_name '%s' not found for target '%s", t.AuthName, t.Name)
+		}
+		t.AuthConfig = *auth
+	}
+
+	if t.ScrapeTimeout == 0 {
+		t.ScrapeTimeout = c.Globals.ScrapeTimeout
+	}
+	if t.QueryRetry == -1 {
+		t.QueryRetry = c.Globals.QueryRetry
+	}
+	if c.abstractProfiles[t.ProfileName] {
+		return fmt.Errorf("profile %q of target %q is abstract: it can only be extended", t.ProfileName, t.Name)
+	}
+	profile, found := c.profiles[t.ProfileName]
+	if !found {
+		return fmt.Errorf("profile %q not found for target name %q", t.ProfileName, t.Name)
+	}
+	t.profile = profile
+	return nil
+}
+
+// WatchedFiles returns the patterns of the files the config is built from: config file, its includes and overlay,
+// collector_files, profiles_file_config and targets_files globs, and javascript modules. Relative globs are
+// resolved from the config file directory, like when files are loaded.
+func (c *Config) WatchedFiles() []string {
+	baseDir := filepath.Dir(c.configFile)
+	patterns := []string{c.configFile}
+	patterns = append(patterns, c.includedFiles...)
+	if c.environment != "" {
+		// overlay file may be created later.
+		patterns = append(patterns, overlayFile(c.configFile, c.environment))
+	}
+	for _, globs := range [][]string{c.CollectorFiles, c.ProfileFiles, c.targetsFiles} {
+		for _, glob := range globs {
+			if len(glob) > 0 && !filepath.IsAbs(glob) {
+				glob = filepath.Join(baseDir, glob)
+			}
+			patterns = append(patterns, glob)
+		}
+	}
+	if c.registry != nil {
+		// modules are resolved by require() from the working directory; extension may be omitted.
+		for _, module_path := range c.registry.Modules {
+			patterns = append(patterns, module_path)
+			if filepath.Ext(module_path) == "" {
+				patterns = append(patterns, module_path+".js")
+			}
+		}
+	}
+	return patterns
+}
+
 func (c *Config) FindCollector(collector_name string) *CollectorConfig {
 	var coll *CollectorConfig
 	coll, found := c.collectors[collector_name]
@@ -466,8 +619,14 @@ func (c *Config) JSON() ([]byte, error) {
 type profileParser struct {
 	MetricPrefix string               `yaml:"metric_prefix,omitempty" json:"metric_prefix,omitempty"`
 	Modules      map[string]string    `yaml:"modules,omitempty" json:"modules,omitempty"`
+	Extends      string               `yaml:"extends,omitempty" json:"extends,omitempty"`   // name of the profile whose definition is inherited
+	Abstract     bool                 `yaml:"abstract,omitempty" json:"abstract,omitempty"` // profile can only be extended: targets can't use it
 	ScriptsNodes map[string]yaml.Node `yaml:"scripts" json:"scripts"`
 	registry     *goja_modules.JSRegistry
+	// file the profile is defined in
+	file string
+	// files of the actions inherited from extended profiles
+	nodeFiles map[*yaml.Node]string
 	// scripts      map[string]*YAMLScript
 }
 
@@ -482,7 +641,7 @@ func (p *Profile) UnmarshalYAML(value *yaml.Node) error {
 	p.MetricPrefix = tmp.MetricPrefix
 	if len(tmp.ScriptsNodes) > 0 {
 		var err error
-		p.Scripts, err = build_YAMLScript(p.registry, tmp.ScriptsNodes)
+		p.Scripts, err = build_YAMLScript(p.registry, "", nil, tmp.ScriptsNodes)
 		if err != nil {
 			return err
 		}
@@ -497,8 +656,7 @@ func (p *Profile) UnmarshalYAML(value *yaml.Node) error {
 }
 
 type profiles struct {
-	profiles map[string]*Profile
-	registry *goja_modules.JSRegistry
+	profiles map[string]*profileParser
 }
 
 func (p *profiles) UnmarshalYAML(value *yaml.Node) error {
@@ -513,27 +671,21 @@ func (p *profiles) UnmarshalYAML(value *yaml.Node) error {
 					return err
 				}
 			} else {
-				// this is a profile
-				profile := &Profile{
-					registry: p.registry,
-					Scripts:  make(map[string]*YAMLScript),
-				}
-				if err := node.Decode(&profile); err != nil {
+				// this is a profile: it is built when its extends is resolved
+				profile := &profileParser{}
+				if err := node.Decode(profile); err != nil {
 					return fmt.Errorf("in profile '%s' %s", *profile_name, err.Error())
 				}
-
-				p.profiles[*profile_name] = &Profile{
-					MetricPrefix: profile.MetricPrefix,
-					Scripts:      profile.Scripts,
-				}
+				p.profiles[*profile_name] = profile
 			}
 		}
 	}
 	return nil
 }
 
-// loadProfileFiles resolves all profile file globs to files and loads the profiles they define.
-func (c *Config) loadProfileFiles() error {
+// loadProfileFiles resolves all profile file globs to files and loads the definitions of the profiles they define
+// into parsers.
+func (c *Config) loadProfileFiles(parsers map[string]*profileParser) error {
 	baseDir := filepath.Dir(c.configFile)
 	for _, pfglob := range c.ProfileFiles {
 		// Resolve relative paths by joining them to the configuration file's directory.
@@ -552,24 +704,23 @@ func (c *Config) loadProfileFiles() error {
 		// And load the Profiles defined in each file.
 		for _, pf := range pfs {
 			c.logger.Debug(fmt.Sprintf("Loading profiles from %s", pf))
-			buf, err := os.ReadFile(pf)
+			node, err := readConfigFile(pf)
 			if err != nil {
 				return fmt.Errorf("reading profiles file %s: %s", pf, err)
 			}
 
 			var profiles profiles = profiles{
-				profiles: make(map[string]*Profile),
-				registry: c.registry,
+				profiles: make(map[string]*profileParser),
 			}
-			err = yaml.Unmarshal(buf, &profiles)
-			if err != nil {
-				return fmt.Errorf("reading %s: %s", pf, err)
+			if node != nil {
+				if err := node.Decode(&profiles); err != nil {
+					return fmt.Errorf("reading %s: %s", pf, err)
+				}
 			}
-			if c.profiles == nil {
-				c.profiles = profiles.profiles
-			} else {
-				maps.Copy(c.profiles, profiles.profiles)
+			for _, profile := range profiles.profiles {
+				profile.file = pf
 			}
+			maps.Copy(parsers, profiles.profiles)
 			// c.Profiles = make(map[string]*Profile)
 			// if len(profiles.profiles) > 0 {
 			// 	for profile_name, profile_Parser := range profiles.profiles {
@@ -619,7 +770,7 @@ func (c *Config) loadCollectorFiles() error {
 		// And load the CollectorConfig defined in each file.
 		for _, cf := range cfs {
 			c.logger.Debug(fmt.Sprintf("Loading collectors from %s", cf))
-			buf, err := os.ReadFile(cf)
+			node, err := readConfigFile(cf)
 			if err != nil {
 				return fmt.Errorf("reading collectors file %s: %s", cf, err)
 			}
@@ -627,10 +778,12 @@ func (c *Config) loadCollectorFiles() error {
 			cc := CollectorConfig{
 				symtab:   map[string]any{},
 				registry: c.registry,
+				file:     cf,
 			}
-			err = yaml.Unmarshal(buf, &cc)
-			if err != nil {
-				return fmt.Errorf("reading %s: %s", cf, err)
+			if node != nil {
+				if err := node.Decode(&cc); err != nil {
+					return fmt.Errorf("reading %s: %s", cf, err)
+				}
 			}
 			c.Collectors = append(c.Collectors, &cc)
 			c.logger.Info(fmt.Sprintf("Loaded collector %s from %s", cc.Name, cf))
@@ -660,15 +813,16 @@ func (c *Config) loadTargetsFiles(targetFilepath []string) error {
 		// And load the CollectorConfig defined in each file.
 		for _, tf := range tfs {
 			c.logger.Debug(fmt.Sprintf("Loading targets from %s", tf))
-			buf, err := os.ReadFile(tf)
+			node, err := readConfigFile(tf)
 			if err != nil {
 				return fmt.Errorf("reading targets_files for %s: %s", tf, err)
 			}
 
 			target := TargetConfig{}
-			err = yaml.Unmarshal(buf, &target)
-			if err != nil {
-				return fmt.Errorf("parsing targets_files for %s: %s", tf, err)
+			if node != nil {
+				if err := node.Decode(&target); err != nil {
+					return fmt.Errorf("parsing targets_files for %s: %s", tf, err)
+				}
 			}
 			target.setFromFile(tf)
 			c.Targets = append(c.Targets, &target)
@@ -703,6 +857,21 @@ type GlobalConfig struct {
 	LogLevel            string `yaml:"log.level,omitempty" json:"log.level,omitempty"`
 	TLSVersion          string `yaml:"tls_version,omitempty" json:"tls_version,omitempty"`
 
+	QueryStatusUrl *QueryStatusUrlConfig `yaml:"query_status_url,omitempty" json:"query_status_url,omitempty"`
+	Tracing        *TracingConfig        `yaml:"tracing,omitempty" json:"tracing,omitempty"`         // OpenTelemetry tracing; read at start only
+	RedactKeys     []string              `yaml:"redact_keys,omitempty" json:"redact_keys,omitempty"` // patterns of secret names added to default ones
+	TargetsApi     *TargetsApiConfig     `yaml:"targets_api,omitempty" json:"targets_api,omitempty"` // api to manage targets at runtime; disabled if not set
+	Secrets        *SecretsConfig        `yaml:"secrets,omitempty" json:"secrets,omitempty"`         // providers of $exec: and $vault: values of auth configs
+
+	AuthKeyHeader       string             `yaml:"auth_key_header,omitempty" json:"auth_key_header,omitempty"`               // header of scrape requests that contains the auth_key; default X-Auth-Key
+	DisableAuthKeyParam ConvertibleBoolean `yaml:"disable_auth_key_param,omitempty" json:"disable_auth_key_param,omitempty"` // refuse auth_key query parameter of scrape requests
+
+	ProbeManyConcurrency int `yaml:"probe_many_concurrency,omitempty" json:"probe_many_concurrency,omitempty"` // maximum number of targets collected in parallel by /probe_many
+
+	MaxConcurrentRequests        int     `yaml:"max_concurrent_requests,omitempty" json:"max_concurrent_requests,omitempty"`                   // maximum number of requests sent at a time to all targets; 0 is unlimited
+	MaxConcurrentRequestsPerHost int     `yaml:"max_concurrent_requests_per_host,omitempty" json:"max_concurrent_requests_per_host,omitempty"` // maximum number of requests sent at a time to a host; 0 is unlimited
+	RequestsPerSecondPerHost     float64 `yaml:"requests_per_second_per_host,omitempty" json:"requests_per_second_per_host,omitempty"`         // maximum rate of requests sent to a host; 0 is unlimited
+
 	invalid_auth_code []int
 	tls_version       uint
 
@@ -724,11 +893,15 @@ func (g *GlobalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	g.ScrapeDurationHelp = scrapeDurationHelp
 	g.CollectorStatusHelp = collectorStatusHelp
 	g.QueryStatusHelp = queryStatusHelp
+	g.AuthKeyHeader = authKeyHeader
 	g.tls_version = 0
 
 	// Default tp 3
 	g.QueryRetry = 3
 
+	// Default to 10 targets collected in parallel by /probe_many
+	g.ProbeManyConcurrency = defaultProbeManyConcurrency
+
 	// Default to httpapi
 	g.MetricPrefix = "httpapi"
 
@@ -743,6 +916,24 @@ func (g *GlobalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if g.ScrapeTimeout <= 0 {
 		return fmt.Errorf("global.connection_timeout must be strictly positive, have %s", g.ScrapeTimeout)
 	}
+	if g.ProbeManyConcurrency <= 0 {
+		return fmt.Errorf("global.probe_many_concurrency must be strictly positive, have %d", g.ProbeManyConcurrency)
+	}
+	if g.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("global.max_concurrent_requests must be positive, have %d", g.MaxConcurrentRequests)
+	}
+	if g.MaxConcurrentRequestsPerHost < 0 {
+		return fmt.Errorf("global.max_concurrent_requests_per_host must be positive, have %d", g.MaxConcurrentRequestsPerHost)
+	}
+	if g.RequestsPerSecondPerHost < 0 {
+		return fmt.Errorf("global.requests_per_second_per_host must be positive, have %g", g.RequestsPerSecondPerHost)
+	}
+
+	for _, pattern := range g.RedactKeys {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("global.redact_keys: invalid pattern '%s': %s", pattern, err)
+		}
+	}
 
 	if g.InvalidHttpCode == nil {
 		g.invalid_auth_code = []int{401, 403}
@@ -770,6 +961,68 @@ func (g *GlobalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return checkOverflow(g.XXX, "global")
 }
 
+// QueryStatusUrlConfig defines how the urls of queries are normalized to build the label of query_status metric.
+type QueryStatusUrlConfig struct {
+	StripQueryString ConvertibleBoolean  `yaml:"strip_query_string,omitempty" json:"strip_query_string,omitempty"`
+	Replace          []*UrlReplaceConfig `yaml:"replace,omitempty" json:"replace,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for QueryStatusUrlConfig.
+func (q *QueryStatusUrlConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain QueryStatusUrlConfig
+	if err := unmarshal((*plain)(q)); err != nil {
+		return err
+	}
+	return checkOverflow(q.XXX, "query_status_url")
+}
+
+// Normalize returns the url transformed by the rules: query string is removed then regex are replaced in order.
+func (q *QueryStatusUrlConfig) Normalize(url string) string {
+	if q == nil {
+		return url
+	}
+	if q.StripQueryString {
+		if idx := strings.IndexByte(url, '?'); idx != -1 {
+			url = url[:idx]
+		}
+	}
+	for _, rep := range q.Replace {
+		url = rep.regex.ReplaceAllString(url, rep.Replacement)
+	}
+	return url
+}
+
+// UrlReplaceConfig is a regex replacement applied on the urls of queries.
+type UrlReplaceConfig struct {
+	Regex       string `yaml:"regex" json:"regex"`
+	Replacement string `yaml:"replacement" json:"replacement"`
+
+	regex *regexp.Regexp
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for UrlReplaceConfig.
+func (r *UrlReplaceConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain UrlReplaceConfig
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	if r.Regex == "" {
+		return fmt.Errorf("missing regex for query_status_url replace")
+	}
+	regex, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid regex '%s' for query_status_url replace: %s", r.Regex, err)
+	}
+	r.regex = regex
+	return checkOverflow(r.XXX, "query_status_url replace")
+}
+
 // *
 // Targets
 // *
@@ -792,14 +1045,18 @@ type TargetConfig struct {
 	VerifySSLString  string            `yaml:"verifySSL,omitempty" json:"verifySSL,omitempty"`
 	ScrapeTimeout    model.Duration    `yaml:"scrape_timeout" json:"scrape_timeout"`                   // per-scrape timeout, global
 	Labels           map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`               // labels to apply to all metrics collected from the targets
-	CollectorRefs    []string          `yaml:"collectors" json:"collectors"`                           // names of collectors to execute on the target
+	CollectorRefs    []CollectorRef    `yaml:"collectors" json:"collectors"`                           // names of collectors to execute on the target, with their params
 	TargetsFiles     []string          `yaml:"targets_files,omitempty" json:"targets_files,omitempty"` // slice of path and pattern for files that contains targets
 	QueryRetry       int               `yaml:"query_retry,omitempty" json:"query_retry,omitempty"`     // target specific number of times to retry a query
 	ProfileName      string            `yaml:"profile" json:"profile"`
 	CustomProperties map[string]string `yaml:"customs,omitempty" json:"customs,omitempty"` // customs properties to add to target symbols table to they can be used in scripts
 
+	MaxConcurrentRequests int     `yaml:"max_concurrent_requests,omitempty" json:"max_concurrent_requests,omitempty"` // maximum number of requests sent at a time to the target; replaces global per host limit
+	RequestsPerSecond     float64 `yaml:"requests_per_second,omitempty" json:"requests_per_second,omitempty"`         // maximum rate of requests sent to the target; replaces global per host limit
+
 	collectors       []*CollectorConfig // resolved collector references
 	fromFile         string             // filepath if loaded from targets_files pattern
+	fromApi          bool               // created or updated with targets api
 	verifySSLUserSet bool
 	verifySSL        ConvertibleBoolean
 	targetType       int
@@ -870,6 +1127,13 @@ func (t *TargetConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			t.verifySSLUserSet = true
 		}
 
+		if t.MaxConcurrentRequests < 0 {
+			return fmt.Errorf("max_concurrent_requests must be positive for target %s, have %d", t.Name, t.MaxConcurrentRequests)
+		}
+		if t.RequestsPerSecond < 0 {
+			return fmt.Errorf("requests_per_second must be positive for target %s, have %g", t.Name, t.RequestsPerSecond)
+		}
+
 		checkCollectorRefs(t.CollectorRefs, t.Name)
 
 		if len(t.Labels) > 0 {
@@ -927,22 +1191,24 @@ func (t *TargetConfig) checkLabelCollisions() error {
 // method to build a temporary TargetConfig from "default" with host_name & and auth_name
 func (t *TargetConfig) Clone(host_path string, auth_name string) (*TargetConfig, error) {
 	new := &TargetConfig{
-		Name:             host_path,
-		Scheme:           t.Scheme,
-		Host:             t.Host,
-		Port:             t.Port,
-		BaseUrl:          t.BaseUrl,
-		AuthConfig:       t.AuthConfig,
-		ProxyUrl:         t.ProxyUrl,
-		ScrapeTimeout:    t.ScrapeTimeout,
-		Labels:           t.Labels,
-		QueryRetry:       t.QueryRetry,
-		ProfileName:      t.ProfileName,
-		CollectorRefs:    t.CollectorRefs,
-		collectors:       t.collectors,
-		verifySSLUserSet: t.verifySSLUserSet,
-		verifySSL:        t.verifySSL,
-		profile:          t.profile,
+		Name:                  host_path,
+		Scheme:                t.Scheme,
+		Host:                  t.Host,
+		Port:                  t.Port,
+		BaseUrl:               t.BaseUrl,
+		AuthConfig:            t.AuthConfig,
+		ProxyUrl:              t.ProxyUrl,
+		ScrapeTimeout:         t.ScrapeTimeout,
+		Labels:                t.Labels,
+		QueryRetry:            t.QueryRetry,
+		ProfileName:           t.ProfileName,
+		MaxConcurrentRequests: t.MaxConcurrentRequests,
+		RequestsPerSecond:     t.RequestsPerSecond,
+		CollectorRefs:         t.CollectorRefs,
+		collectors:            t.collectors,
+		verifySSLUserSet:      t.verifySSLUserSet,
+		verifySSL:             t.verifySSL,
+		profile:               t.profile,
 	}
 
 	url_elmt, err := url.Parse(host_path)
@@ -989,13 +1255,19 @@ func (t *TargetConfig) Clone(host_path string, auth_name string) (*TargetConfig,
 
 // CollectorConfig defines a set of metrics and how they are collected.
 type CollectorConfig struct {
-	Name           string                 `yaml:"collector_name" json:"collector_name"`                   // name of this collector
-	MetricPrefix   string                 `yaml:"metric_prefix,omitempty" json:"metric_prefix,omitempty"` // a prefix to ad dto all metric name; may be redefined in collector files
-	MinInterval    model.Duration         `yaml:"min_interval,omitempty" json:"min_interval,omitempty"`   // minimum interval between query executions
-	Templates      map[string]string      `yaml:"templates,omitempty" json:"templates,omitempty"`         // share custom templates/funcs for results templating
-	CollectScripts map[string]*YAMLScript `yaml:"scripts,omitempty" json:"scripts,omitempty"`             // map of all independent scripts to collect metrics - each script can run in parallel
+	Name           string                 `yaml:"collector_name" json:"collector_name"`                                 // name of this collector
+	MetricPrefix   string                 `yaml:"metric_prefix,omitempty" json:"metric_prefix,omitempty"`               // a prefix to ad dto all metric name; may be redefined in collector files
+	MinInterval    model.Duration         `yaml:"min_interval,omitempty" json:"min_interval,omitempty"`                 // minimum interval between query executions
+	Timeout        model.Duration         `yaml:"timeout,omitempty" json:"timeout,omitempty"`                           // max duration of collector scripts execution; default 0: only scrape timeout applies
+	MaxStaleness   model.Duration         `yaml:"max_staleness,omitempty" json:"max_staleness,omitempty"`               // with min_interval: max age of a cached series not refreshed by last collection; default 0: series are dropped
+	ServeStale     ConvertibleBoolean     `yaml:"serve_stale_on_error,omitempty" json:"serve_stale_on_error,omitempty"` // with min_interval: serve the last good cache when collection fails instead of partial data
+	Templates      map[string]string      `yaml:"templates,omitempty" json:"templates,omitempty"`                       // share custom templates/funcs for results templating
+	Params         map[string]any         `yaml:"params,omitempty" json:"params,omitempty"`                             // params of the collector with their default values; set as symbols of scripts
+	CollectScripts map[string]*YAMLScript `yaml:"scripts,omitempty" json:"scripts,omitempty"`                           // map of all independent scripts to collect metrics - each script can run in parallel
 	symtab         map[string]any
 	registry       *goja_modules.JSRegistry
+	// file the collector is defined in
+	file string
 
 	customTemplate *exporterTemplate // to store the custom Templates used by this collector
 	// id to print in log and to follow request action
@@ -1005,11 +1277,15 @@ type CollectorConfig struct {
 }
 
 type CollectorConfigParser struct {
-	Name           string                 `yaml:"collector_name"`          // name of this collector
-	MetricPrefix   string                 `yaml:"metric_prefix,omitempty"` // a prefix to ad dto all metric name; may be redefined in collector files
-	MinInterval    model.Duration         `yaml:"min_interval,omitempty"`  // minimum interval between query executions
-	Templates      map[string]string      `yaml:"templates,omitempty"`     // share custom templates/funcs for results templating
-	CollectScripts map[string]yaml.Node   `yaml:"scripts,omitempty"`       // map of all independent scripts to collect metrics - each script can run in parallel
+	Name           string                 `yaml:"collector_name"`                 // name of this collector
+	MetricPrefix   string                 `yaml:"metric_prefix,omitempty"`        // a prefix to ad dto all metric name; may be redefined in collector files
+	MinInterval    model.Duration         `yaml:"min_interval,omitempty"`         // minimum interval between query executions
+	Timeout        model.Duration         `yaml:"timeout,omitempty"`              // max duration of collector scripts execution
+	MaxStaleness   model.Duration         `yaml:"max_staleness,omitempty"`        // max age of a cached series not refreshed by last collection
+	ServeStale     ConvertibleBoolean     `yaml:"serve_stale_on_error,omitempty"` // serve the last good cache when collection fails
+	Templates      map[string]string      `yaml:"templates,omitempty"`            // share custom templates/funcs for results templating
+	Params         map[string]any         `yaml:"params,omitempty"`               // params of the collector with their default values
+	CollectScripts map[string]yaml.Node   `yaml:"scripts,omitempty"`              // map of all independent scripts to collect metrics - each script can run in parallel
 	XXX            map[string]interface{} `yaml:",inline" json:"-"`
 }
 
@@ -1034,7 +1310,24 @@ func (c *CollectorConfig) UnmarshalYAML(value *yaml.Node) error {
 	// }
 	c.Name = tmp.Name
 	c.MetricPrefix = tmp.MetricPrefix
+	c.MinInterval = tmp.MinInterval
+	c.Timeout = tmp.Timeout
+	c.MaxStaleness = tmp.MaxStaleness
+	c.ServeStale = tmp.ServeStale
 	c.Templates = tmp.Templates
+	c.Params = tmp.Params
+	// params are set in the symbols table of the scripts: they can't replace the symbols of the exporter.
+	for name := range c.Params {
+		if strings.HasPrefix(name, "__") || slices.Contains(builtinSymbols, name) {
+			return fmt.Errorf("for collector %s param '%s' is a reserved name", c.Name, name)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("for collector %s timeout must be positive", c.Name)
+	}
+	if c.MaxStaleness < 0 {
+		return fmt.Errorf("for collector %s max_staleness must be positive", c.Name)
+	}
 
 	// build the default templates/funcs that my be used by all templates
 	if len(c.Templates) > 0 {
@@ -1055,10 +1348,19 @@ func (c *CollectorConfig) UnmarshalYAML(value *yaml.Node) error {
 	}
 	if len(tmp.CollectScripts) > 0 {
 		var err error
-		c.CollectScripts, err = build_YAMLScript(c.registry, tmp.CollectScripts)
+		c.CollectScripts, err = build_YAMLScript(c.registry, c.file, nil, tmp.CollectScripts)
 		if err != nil {
 			return err
 		}
+		// play_script actions are only resolved in the scripts of profiles.
+		for name, script := range c.CollectScripts {
+			if script == nil {
+				continue
+			}
+			if played := playedScripts(script.Actions); len(played) > 0 {
+				return fmt.Errorf("for collector %s script %s: play_script '%s' is only allowed in profiles", c.Name, name, played[0])
+			}
+		}
 		// c.CollectScripts = make(map[string]*YAMLScript)
 		// for collect_script_name, script_Node := range tmp.CollectScripts {
 		// 	script := &YAMLScript{
@@ -1098,11 +1400,31 @@ func (c *CollectorConfig) UnmarshalYAML(value *yaml.Node) error {
 	return checkOverflow(c.XXX, "collector")
 }
 
-func build_YAMLScript(registry *goja_modules.JSRegistry, nodes map[string]yaml.Node) (map[string]*YAMLScript, error) {
+// playedScripts returns the names of the scripts played by the play_script actions of actions and of their sub-actions.
+func playedScripts(actions []Action) []string {
+	var names []string
+	for _, action := range actions {
+		switch a := action.(type) {
+		case *PlayScriptAction:
+			names = append(names, a.PlayScriptActionName)
+		case *ActionsAction:
+			names = append(names, playedScripts(a.Actions)...)
+		case *MetricsAction:
+			names = append(names, playedScripts(a.Actions)...)
+		}
+	}
+	return names
+}
+
+// build_YAMLScript parses the scripts defined by nodes in file; nodeFiles are the files of actions defined in another
+// file.
+func build_YAMLScript(registry *goja_modules.JSRegistry, file string, nodeFiles map[*yaml.Node]string, nodes map[string]yaml.Node) (map[string]*YAMLScript, error) {
 	scripts := make(map[string]*YAMLScript)
 	for script_name, script_Node := range nodes {
 		script := &YAMLScript{
-			registry: registry,
+			registry:  registry,
+			file:      file,
+			nodeFiles: nodeFiles,
 		}
 		if err := script_Node.Decode(&script); err != nil {
 			return nil, fmt.Errorf("script '%s' parsing error : '%s'", script_name, err.Error())
@@ -1141,11 +1463,15 @@ func (cs CollectorConfigList) String() string {
 }
 
 type dumpCollectorConfig struct {
-	Name           string                 `yaml:"collector_name" json:"collector_name"`                   // name of this collector
-	MetricPrefix   string                 `yaml:"metric_prefix,omitempty" json:"metric_prefix,omitempty"` // a prefix to ad dto all metric name; may be redefined in collector files
-	MinInterval    model.Duration         `yaml:"min_interval,omitempty" json:"min_interval,omitempty"`   // minimum interval between query executions
-	Templates      map[string]string      `yaml:"templates,omitempty" json:"templates,omitempty"`         // share custom templates/funcs for results templating
-	CollectScripts map[string]ActionsList `yaml:"scripts,omitempty" json:"scripts,omitempty"`             // map of all independent scripts to collect metrics - each script can run in parallel
+	Name           string                 `yaml:"collector_name" json:"collector_name"`                                 // name of this collector
+	MetricPrefix   string                 `yaml:"metric_prefix,omitempty" json:"metric_prefix,omitempty"`               // a prefix to ad dto all metric name; may be redefined in collector files
+	MinInterval    model.Duration         `yaml:"min_interval,omitempty" json:"min_interval,omitempty"`                 // minimum interval between query executions
+	Timeout        model.Duration         `yaml:"timeout,omitempty" json:"timeout,omitempty"`                           // max duration of collector scripts execution; default 0: only scrape timeout applies
+	MaxStaleness   model.Duration         `yaml:"max_staleness,omitempty" json:"max_staleness,omitempty"`               // max age of a cached series not refreshed by last collection
+	ServeStale     ConvertibleBoolean     `yaml:"serve_stale_on_error,omitempty" json:"serve_stale_on_error,omitempty"` // serve the last good cache when collection fails
+	Templates      map[string]string      `yaml:"templates,omitempty" json:"templates,omitempty"`                       // share custom templates/funcs for results templating
+	Params         map[string]any         `yaml:"params,omitempty" json:"params,omitempty"`                             // params of the collector, with their values for a target
+	CollectScripts map[string]ActionsList `yaml:"scripts,omitempty" json:"scripts,omitempty"`                           // map of all independent scripts to collect metrics - each script can run in parallel
 }
 
 func GetCollectorsDef(src_colls []*CollectorConfig) []*dumpCollectorConfig {
@@ -1155,7 +1481,11 @@ func GetCollectorsDef(src_colls []*CollectorConfig) []*dumpCollectorConfig {
 			Name:           coll.Name,
 			MetricPrefix:   coll.MetricPrefix,
 			MinInterval:    coll.MinInterval,
+			Timeout:        coll.Timeout,
+			MaxStaleness:   coll.MaxStaleness,
+			ServeStale:     coll.ServeStale,
 			Templates:      coll.Templates,
+			Params:         coll.Params,
 			CollectScripts: GetScriptsDef(coll.CollectScripts),
 		}
 	}
@@ -1209,8 +1539,11 @@ type AuthConfig struct {
 	Password    Secret             `yaml:"password,omitempty" json:"password,omitempty"`
 	Token       Secret             `yaml:"token,omitempty" json:"token,omitempty"`
 	DisableWarn ConvertibleBoolean `yaml:"disable_warn,omitempty" json:"disable_warn,omitempty"`
+	AuthKeyFile string             `yaml:"auth_key_file,omitempty" json:"auth_key_file,omitempty"` // file containing the key to decrypt password
 
 	authKey string
+	// values of user, password and token obtained from secret providers
+	secrets map[string]*secretRef
 }
 
 func check_env_var(value string) string {
@@ -1249,56 +1582,86 @@ func (auth *AuthConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	auth.Username = check_env_var(auth.Username)
 	auth.Password = Secret(check_env_var(string(auth.Password)))
 	auth.Token = Secret(check_env_var(string(auth.Token)))
+	if auth.AuthKeyFile != "" {
+		content, err := os.ReadFile(auth.AuthKeyFile)
+		if err != nil {
+			return fmt.Errorf("can't read auth_key_file: %s", err)
+		}
+		auth.authKey = strings.TrimSpace(string(content))
+		if auth.authKey == "" {
+			return fmt.Errorf("auth_key_file '%s' is empty", auth.AuthKeyFile)
+		}
+	}
+	// values obtained from secret providers: $file:, $exec:, $vault:
+	for name, value := range map[string]string{
+		"user":     auth.Username,
+		"password": string(auth.Password),
+		"token":    string(auth.Token),
+	} {
+		if !isSecretRef(value) {
+			continue
+		}
+		ref, err := newSecretRef(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", name, err)
+		}
+		if auth.secrets == nil {
+			auth.secrets = make(map[string]*secretRef)
+		}
+		auth.secrets[name] = ref
+	}
+	// secrets must never be logged
+	redactor.AddSecrets(string(auth.Password), string(auth.Token), auth.authKey)
 
 	return nil
 }
 
+// HasSecretRefs returns true if some values of auth config are obtained from secret providers.
+func (auth *AuthConfig) HasSecretRefs() bool {
+	return len(auth.secrets) > 0
+}
+
+// Values returns the user, password and token of auth config, obtained from secret providers if required.
+// If a provider fails, the last value obtained is returned with the error.
+func (auth *AuthConfig) Values() (user string, password string, token string, err error) {
+	user, password, token = auth.Username, string(auth.Password), string(auth.Token)
+	var errs []string
+	for name, value := range map[string]*string{
+		"user":     &user,
+		"password": &password,
+		"token":    &token,
+	} {
+		if ref, ok := auth.secrets[name]; ok {
+			val, err := ref.Value()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+			}
+			*value = val
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		err = errors.New(strings.Join(errs, "; "))
+	}
+	return
+}
+
 // *************************************************************************************************
-func checkCollectorRefs(collectorRefs []string, ctx string) error {
+func checkCollectorRefs(collectorRefs []CollectorRef, ctx string) error {
 	// At least one collector, no duplicates
 	if len(collectorRefs) == 0 {
 		return fmt.Errorf("no collectors defined for %s", ctx)
 	}
 	for i, ci := range collectorRefs {
 		for _, cj := range collectorRefs[i+1:] {
-			if ci == cj {
-				return fmt.Errorf("duplicate collector reference %q in %s", ci, ctx)
+			if ci.instanceName() == cj.instanceName() {
+				return fmt.Errorf("duplicate collector reference %q in %s", ci.instanceName(), ctx)
 			}
 		}
 	}
 	return nil
 }
 
-func resolveCollectorRefs(
-	collectorRefs []string, collectors map[string]*CollectorConfig, ctx string) ([]*CollectorConfig, error) {
-	resolved := make([]*CollectorConfig, 0, len(collectorRefs))
-	for _, cref := range collectorRefs {
-		// check if cref(a collector name) is a pattern or not
-		if strings.HasPrefix(cref, "~") {
-			pat := regexp.MustCompile(strings.TrimSpace(cref[1:]))
-			for c_name, c := range collectors {
-				if pat.MatchString(c_name) {
-					resolved = append(resolved, c)
-				}
-			}
-		} else if strings.HasPrefix(cref, "!~") {
-			pat := regexp.MustCompile(strings.TrimSpace(cref[2:]))
-			for c_name, c := range collectors {
-				if !pat.MatchString(c_name) {
-					resolved = append(resolved, c)
-				}
-			}
-		} else {
-			c, found := collectors[cref]
-			if !found {
-				return nil, fmt.Errorf("unknown collector %q referenced in %s", cref, ctx)
-			}
-			resolved = append(resolved, c)
-		}
-	}
-	return resolved, nil
-}
-
 func checkLabel(label string, ctx ...string) error {
 	if label == "" {
 		return fmt.Errorf("empty label defined in %s", strings.Join(ctx, " "))
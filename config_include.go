@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey is the directive of the top level of a config file to merge other config files (fragments) into it.
+const includeKey = "include"
+
+// envVarRegexp matches environment variables references in config files: ${NAME}, ${NAME:-default} and
+// ${NAME:?message}, or an escaped reference $${...}. Only upper case names are replaced, so that javascript template
+// literals like `${item}` are kept.
+var envVarRegexp = regexp.MustCompile(`(\$?)\$\{([A-Z_][A-Z0-9_]*)(?::([-?])([^}]*))?\}`)
+
+// expandEnv replaces the references to environment variables in a value of a config file:
+//   - ${NAME}: value of NAME; the reference is kept if NAME is not set.
+//   - ${NAME:-default}: value of NAME, default if NAME is not set or empty.
+//   - ${NAME:?message}: value of NAME, an error with message if NAME is not set or empty.
+//   - $${NAME}: literal ${NAME}.
+func expandEnv(value string) (string, error) {
+	var errs []string
+	res := envVarRegexp.ReplaceAllStringFunc(value, func(match string) string {
+		sub := envVarRegexp.FindStringSubmatch(match)
+		if len(sub[1]) > 0 {
+			// escaped
+			return match[1:]
+		}
+		name := sub[2]
+		value, set := os.LookupEnv(name)
+		switch sub[3] {
+		case "-":
+			if value == "" {
+				return sub[4]
+			}
+		case "?":
+			if value == "" {
+				msg := sub[4]
+				if msg == "" {
+					msg = "not set"
+				}
+				errs = append(errs, fmt.Sprintf("%s: %s", name, msg))
+				return match
+			}
+		default:
+			if !set {
+				return match
+			}
+		}
+		return value
+	})
+	if len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, "; "))
+	}
+	return res, nil
+}
+
+// expandEnvNode replaces the references to environment variables in the scalar values of a yaml tree. Values are
+// replaced after parsing, so that they can't change the structure of the document, whatever chars they contain.
+func expandEnvNode(node *yaml.Node) error {
+	var errs []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode {
+			if !strings.Contains(node.Value, "${") {
+				return
+			}
+			value, err := expandEnv(node.Value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("line %d: %s", node.Line, err))
+				return
+			}
+			if value != node.Value {
+				node.Value = value
+				// the type of a plain value is resolved from the value set, e.g. a port number.
+				if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+					node.Tag = ""
+				}
+			}
+			return
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+	if len(errs) > 0 {
+		return fmt.Errorf("environment variables: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// readConfigFile returns the root node of a config file with environment variables replaced; nil if the file is empty.
+func readConfigFile(path string) (*yaml.Node, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	if err := expandEnvNode(doc.Content[0]); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return doc.Content[0], nil
+}
+
+// overlayFile returns the name of the overlay of config file for environment: config.yml -> config.<environment>.yml
+func overlayFile(configFile, environment string) string {
+	ext := filepath.Ext(configFile)
+	return strings.TrimSuffix(configFile, ext) + "." + environment + ext
+}
+
+// configLoader reads a config file with the fragments it includes and its environment overlay.
+type configLoader struct {
+	// files read, with the config file first.
+	files []string
+	// overlay file loaded; empty if none.
+	overlay string
+	// file each node is read from: nodes of the merged tree come from the config file, its fragments or its overlay.
+	nodeFiles map[*yaml.Node]string
+}
+
+// setNodesFile records file as the source of node and of all its children.
+func (l *configLoader) setNodesFile(node *yaml.Node, file string) {
+	if l.nodeFiles == nil {
+		l.nodeFiles = make(map[*yaml.Node]string)
+	}
+	l.nodeFiles[node] = file
+	for _, child := range node.Content {
+		l.setNodesFile(child, file)
+	}
+}
+
+// load returns the yaml tree of configFile: its includes merged, then the overlay for environment if set and if
+// the file exists.
+func (l *configLoader) load(configFile, environment string) (*yaml.Node, error) {
+	node, err := l.loadFile(configFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	if environment == "" {
+		return node, nil
+	}
+	overlay := overlayFile(configFile, environment)
+	if _, err := os.Stat(overlay); err != nil {
+		if os.IsNotExist(err) {
+			return node, nil
+		}
+		return nil, err
+	}
+	over, err := l.loadFile(overlay, nil)
+	if err != nil {
+		return nil, err
+	}
+	l.overlay = overlay
+	// overlay replaces lists: e.g. the targets of an environment.
+	return mergeNodes(node, over, false), nil
+}
+
+// loadFile returns the yaml tree of file with the files it includes merged. stack is the list of the files including
+// file, to detect loops.
+func (l *configLoader) loadFile(file string, stack []string) (*yaml.Node, error) {
+	if slices.Contains(stack, file) {
+		return nil, fmt.Errorf("include loop: %s -> %s", strings.Join(stack, " -> "), file)
+	}
+	node, err := readConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+	l.files = append(l.files, file)
+
+	if node == nil {
+		// empty file
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	l.setNodesFile(node, file)
+	if node.Kind != yaml.MappingNode {
+		return node, nil
+	}
+
+	var includes []string
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value != includeKey {
+			continue
+		}
+		value := node.Content[idx+1]
+		switch value.Kind {
+		case yaml.ScalarNode:
+			includes = []string{value.Value}
+		case yaml.SequenceNode:
+			if err := value.Decode(&includes); err != nil {
+				return nil, fmt.Errorf("reading %s: %s: %s", file, includeKey, err)
+			}
+		default:
+			return nil, fmt.Errorf("reading %s: %s must be a file name or a list of file names", file, includeKey)
+		}
+		node.Content = slices.Delete(node.Content, idx, idx+2)
+		break
+	}
+	if len(includes) == 0 {
+		return node, nil
+	}
+
+	// fragments are merged in order, then the including file is merged over them.
+	var res *yaml.Node
+	baseDir := filepath.Dir(file)
+	for _, glob := range includes {
+		// Resolve relative paths by joining them to the including file's directory.
+		if len(glob) > 0 && !filepath.IsAbs(glob) {
+			glob = filepath.Join(baseDir, glob)
+		}
+		files, err := filepath.Glob(glob)
+		if err != nil {
+			// The only error can be a bad pattern.
+			return nil, fmt.Errorf("reading %s: include %s: %s", file, glob, err)
+		}
+		if len(files) == 0 && !strings.ContainsAny(glob, "*?[") {
+			return nil, fmt.Errorf("reading %s: include %s: file not found", file, glob)
+		}
+		for _, f := range files {
+			frag, err := l.loadFile(f, append(stack, file))
+			if err != nil {
+				return nil, err
+			}
+			if frag.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("reading %s: included file %s is not a map", file, f)
+			}
+			if res == nil {
+				res = frag
+			} else {
+				res = mergeNodes(res, frag, true)
+			}
+		}
+	}
+	if res == nil {
+		return node, nil
+	}
+	return mergeNodes(res, node, true), nil
+}
+
+// mergeNodes deep merges over into base and returns base: values of maps are merged by keys, other values of over
+// replace the ones of base. Lists of over are appended to the ones of base if appendLists is set, else they replace
+// them.
+func mergeNodes(base, over *yaml.Node, appendLists bool) *yaml.Node {
+	switch {
+	case base.Kind == yaml.MappingNode && over.Kind == yaml.MappingNode:
+		for idx := 0; idx+1 < len(over.Content); idx += 2 {
+			key, value := over.Content[idx], over.Content[idx+1]
+			found := false
+			for jdx := 0; jdx+1 < len(base.Content); jdx += 2 {
+				if base.Content[jdx].Value == key.Value {
+					base.Content[jdx+1] = mergeNodes(base.Content[jdx+1], value, appendLists)
+					found = true
+					break
+				}
+			}
+			if !found {
+				base.Content = append(base.Content, key, value)
+			}
+		}
+		return base
+	case appendLists && base.Kind == yaml.SequenceNode && over.Kind == yaml.SequenceNode:
+		base.Content = append(base.Content, over.Content...)
+		return base
+	}
+	return over
+}
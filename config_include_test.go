@@ -0,0 +1,225 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/peekjef72/httpapi_exporter/goja_modules"
+	"github.com/peekjef72/httpapi_exporter/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("EXPORTER_HOST", "server.domain")
+	t.Setenv("EXPORTER_EMPTY", "")
+
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"host: ${EXPORTER_HOST}", "host: server.domain"},
+		{"host: ${EXPORTER_UNSET}", "host: ${EXPORTER_UNSET}"},
+		{"port: ${EXPORTER_PORT:-443}", "port: 443"},
+		{"port: ${EXPORTER_EMPTY:-443}", "port: 443"},
+		{"host: ${EXPORTER_HOST:?host required}", "host: server.domain"},
+		{"host: $${EXPORTER_HOST}", "host: ${EXPORTER_HOST}"},
+		{"vers: `${matches[1]}.${item}`", "vers: `${matches[1]}.${item}`"},
+	} {
+		out, err := expandEnv(tc.in)
+		if assert.NoError(t, err, tc.in) {
+			assert.Equal(t, tc.out, out)
+		}
+	}
+
+	_, err := expandEnv("host: ${EXPORTER_UNSET:?host required}")
+	assert.ErrorContains(t, err, "EXPORTER_UNSET: host required")
+}
+
+func TestExpandEnvNode(t *testing.T) {
+	// values that would change the structure of the document if they were replaced in the text.
+	password := "p@ss: word #1\n*ref &anchor !tag"
+	t.Setenv("EXPORTER_PASSWORD", password)
+	t.Setenv("EXPORTER_PORT", "8443")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	content := `
+auth:
+  user: admin
+  password: ${EXPORTER_PASSWORD}
+  quoted: "${EXPORTER_PASSWORD}"
+  token: $${EXPORTER_PASSWORD}
+port: ${EXPORTER_PORT}
+port_str: "${EXPORTER_PORT}"
+# comments are not replaced: ${EXPORTER_UNSET:?not required}
+`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	node, err := readConfigFile(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var res struct {
+		Auth    map[string]string `yaml:"auth"`
+		Port    int               `yaml:"port"`
+		PortStr any               `yaml:"port_str"`
+	}
+	if assert.NoError(t, node.Decode(&res)) {
+		assert.Equal(t, map[string]string{
+			"user":     "admin",
+			"password": password,
+			"quoted":   password,
+			"token":    "${EXPORTER_PASSWORD}",
+		}, res.Auth)
+		assert.Equal(t, 8443, res.Port)
+		assert.Equal(t, "8443", res.PortStr)
+	}
+
+	if err := os.WriteFile(file, []byte("host: ${EXPORTER_UNSET:?host required}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readConfigFile(file)
+	assert.ErrorContains(t, err, "line 1: EXPORTER_UNSET: host required")
+}
+
+func TestConfigLoader(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yml": `
+include:
+  - conf.d/*.yml
+global:
+  scrape_timeout: 5s
+targets:
+  - name: local
+    host: ${EXPORTER_TEST_HOST:-localhost}
+`,
+		"conf.d/auth.yml": `
+global:
+  query_retry: 3
+auth_configs:
+  default:
+    mode: basic
+`,
+		"conf.d/targets.yml": `
+targets:
+  - name: shared
+`,
+		"config.prod.yml": `
+global:
+  scrape_timeout: 30s
+targets:
+  - name: prod
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config_file := filepath.Join(dir, "config.yml")
+
+	// includes: maps are merged, lists appended
+	loader := &configLoader{}
+	node, err := loader.load(config_file, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var res map[string]any
+	if !assert.NoError(t, node.Decode(&res)) {
+		return
+	}
+	assert.NotContains(t, res, includeKey)
+	assert.Equal(t, map[string]any{"scrape_timeout": "5s", "query_retry": 3}, res["global"])
+	assert.Equal(t, []any{map[string]any{"name": "shared"}, map[string]any{"name": "local", "host": "localhost"}}, res["targets"])
+	assert.Contains(t, res, "auth_configs")
+	assert.Len(t, loader.files, 3)
+	assert.Empty(t, loader.overlay)
+	// nodes keep the file they are read from
+	targets := mapValue(node, "targets")
+	if assert.Len(t, targets.Content, 2) {
+		assert.Equal(t, filepath.Join(dir, "conf.d/targets.yml"), loader.nodeFiles[targets.Content[0]])
+		assert.Equal(t, config_file, loader.nodeFiles[targets.Content[1]])
+	}
+
+	// overlay: maps are merged, lists replaced
+	loader = &configLoader{}
+	node, err = loader.load(config_file, "prod")
+	if !assert.NoError(t, err) {
+		return
+	}
+	res = nil
+	if !assert.NoError(t, node.Decode(&res)) {
+		return
+	}
+	assert.Equal(t, map[string]any{"scrape_timeout": "30s", "query_retry": 3}, res["global"])
+	assert.Equal(t, []any{map[string]any{"name": "prod"}}, res["targets"])
+	assert.Equal(t, filepath.Join(dir, "config.prod.yml"), loader.overlay)
+
+	// missing overlay is ignored
+	_, err = (&configLoader{}).load(config_file, "dev")
+	assert.NoError(t, err)
+
+	// include loop
+	if err := os.WriteFile(filepath.Join(dir, "conf.d/loop.yml"), []byte("include: ../config.yml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = (&configLoader{}).load(config_file, "")
+	assert.ErrorContains(t, err, "include loop")
+}
+
+func TestLoadConfigSourceFiles(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	registry, _ := goja_modules.InitJSRegistry(logger, template.Js_func_map())
+	dir := t.TempDir()
+	copyDir(t, "contribs/apache/etc/apache", dir)
+	config_file := filepath.Join(dir, "config.yml")
+	frag_file := filepath.Join(dir, "conf.d", "collectors.yml")
+	overlay_file := filepath.Join(dir, "config.prod.yml")
+	files := map[string]string{
+		frag_file: `collectors:
+  - collector_name: apache_inline
+    scripts:
+      get_inline:
+        - name: facts
+          set_fact:
+            inline: 1
+`,
+		overlay_file: `profiles:
+  inline:
+    scripts:
+      ping:
+        - name: ping
+          query:
+            url: /
+`,
+	}
+	config, _ := os.ReadFile(config_file)
+	files[config_file] = "include: conf.d/*.yml\n" + string(config)
+	for path, content := range files {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := LoadConfig(config_file, "prod", logger, "", registry)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// collectors and profiles defined in included files and overlay are located in them
+	if coll, ok := c.collectors["apache_inline"]; assert.True(t, ok) {
+		assert.Equal(t, frag_file, coll.file)
+		assert.Equal(t, SourcePos{File: frag_file, Line: 5, Column: 11}, coll.CollectScripts["get_inline"].Actions[0].GetPos())
+	}
+	if prof, ok := c.profiles["inline"]; assert.True(t, ok) {
+		assert.Equal(t, SourcePos{File: overlay_file, Line: 5, Column: 11}, prof.Scripts["ping"].Actions[0].GetPos())
+	}
+	assert.Equal(t, filepath.Join(dir, "metrics", "apache_status.collector.yml"), c.collectors["apache_status"].file)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// state of a watched file: a change of modification time or size triggers a reload.
+type watchedFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// ConfigWatcher polls the files the configuration is built from, and asks for a reload when they have changed.
+// Changes are debounced: the reload is asked when no more change has been detected for the debounce period, so that
+// files written by config management tools in several steps are reloaded once.
+type ConfigWatcher struct {
+	// returns the patterns of the files to watch
+	patterns func() []string
+	actionCh chan<- actionMsg
+	interval time.Duration
+	debounce time.Duration
+	logger   *slog.Logger
+
+	// files states at last poll
+	files map[string]watchedFileState
+	// time of the last change detected and not yet reloaded; zero if none
+	changed time.Time
+}
+
+// NewConfigWatcher returns a watcher of the files matching patterns that sends reload actions to actionCh.
+func NewConfigWatcher(patterns func() []string, actionCh chan<- actionMsg, interval, debounce time.Duration, logger *slog.Logger) *ConfigWatcher {
+	w := &ConfigWatcher{
+		patterns: patterns,
+		actionCh: actionCh,
+		interval: interval,
+		debounce: debounce,
+		logger:   logger,
+	}
+	w.files = w.snapshot()
+	return w
+}
+
+// snapshot returns the current states of the files matching the watched patterns.
+func (w *ConfigWatcher) snapshot() map[string]watchedFileState {
+	files := make(map[string]watchedFileState)
+	for _, pattern := range w.patterns() {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			// only bad patterns: they have been reported when config was loaded.
+			continue
+		}
+		for _, file := range matches {
+			info, err := os.Stat(file)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			files[file] = watchedFileState{
+				modTime: info.ModTime(),
+				size:    info.Size(),
+			}
+		}
+	}
+	return files
+}
+
+// Run polls the files until stop is closed.
+func (w *ConfigWatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			w.poll(now)
+		}
+	}
+}
+
+// poll checks the files, and asks for a reload if changes are older than debounce period.
+func (w *ConfigWatcher) poll(now time.Time) {
+	files := w.snapshot()
+	if !maps.Equal(files, w.files) {
+		w.logger.Debug("config watcher: files changed", "files", len(files))
+		w.files = files
+		w.changed = now
+		return
+	}
+	if w.changed.IsZero() || now.Sub(w.changed) < w.debounce {
+		return
+	}
+	w.changed = time.Time{}
+
+	// states and patterns of the files reloaded: a file changed while the reload is running is detected at next poll.
+	patterns := w.patterns()
+	w.logger.Info("config watcher: files changed, reloading.")
+	msg := actionMsg{
+		actionType: ACTION_RELOAD,
+		trigger:    reloadTriggerWatch,
+		retCh:      make(chan error),
+	}
+	w.actionCh <- msg
+	if err := <-msg.retCh; err != nil {
+		// config is not modified: files will be checked again at next change.
+		w.logger.Warn(fmt.Sprintf("config watcher: reload failed: %s", err))
+	}
+	// new config may reference other files: their current states are watched from now.
+	reloaded := files
+	files = make(map[string]watchedFileState)
+	for file, state := range w.snapshot() {
+		if old, ok := reloaded[file]; ok {
+			files[file] = old
+		} else if !matchAny(patterns, file) {
+			files[file] = state
+		}
+		// else file has been created during the reload: it is reported as changed at next poll.
+	}
+	w.files = files
+}
+
+// matchAny returns true if file matches one of the glob patterns.
+func matchAny(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	dir := t.TempDir()
+	config_file := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(config_file, []byte("global: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	patterns := func() []string { return []string{config_file, filepath.Join(dir, "targets", "*.yml")} }
+
+	actionCh := make(chan actionMsg)
+	reloads := 0
+	go func() {
+		for msg := range actionCh {
+			reloads++
+			assert.Equal(t, reloadTriggerWatch, msg.trigger)
+			msg.retCh <- errors.New("invalid config")
+		}
+	}()
+	w := NewConfigWatcher(patterns, actionCh, time.Second, 2*time.Second, logger)
+	assert.Equal(t, 1, len(w.files))
+
+	now := time.Now()
+	w.poll(now)
+	assert.True(t, w.changed.IsZero(), "no change detected")
+
+	// new file matching a glob
+	os.Mkdir(filepath.Join(dir, "targets"), 0o700)
+	if err := os.WriteFile(filepath.Join(dir, "targets", "t1.yml"), []byte("name: t1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(now.Add(time.Second))
+	assert.Equal(t, now.Add(time.Second), w.changed)
+	// debounce: no reload before 2s without change
+	w.poll(now.Add(2 * time.Second))
+	if err := os.WriteFile(config_file, []byte("global: {}\ntargets: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(now.Add(3 * time.Second))
+	w.poll(now.Add(4 * time.Second))
+	assert.Equal(t, 0, reloads)
+
+	w.poll(now.Add(5 * time.Second))
+	assert.Equal(t, 1, reloads)
+	assert.True(t, w.changed.IsZero())
+	assert.Equal(t, 2, len(w.files))
+	// reload is asked once
+	w.poll(now.Add(10 * time.Second))
+	assert.Equal(t, 1, reloads)
+	close(actionCh)
+
+	// files changed or created while reload is running are reloaded again
+	actionCh = make(chan actionMsg)
+	reloads = 0
+	go func() {
+		for msg := range actionCh {
+			reloads++
+			if reloads == 1 {
+				os.WriteFile(config_file, []byte("global: {}\ntargets: [ {name: t2} ]\n"), 0o600)
+				os.WriteFile(filepath.Join(dir, "targets", "t3.yml"), []byte("name: t3\n"), 0o600)
+			}
+			msg.retCh <- nil
+		}
+	}()
+	w = NewConfigWatcher(patterns, actionCh, time.Second, 2*time.Second, logger)
+	if err := os.WriteFile(filepath.Join(dir, "targets", "t1.yml"), []byte("name: t1\nhost: h1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(now)
+	w.poll(now.Add(2 * time.Second))
+	assert.Equal(t, 1, reloads)
+	w.poll(now.Add(3 * time.Second))
+	assert.False(t, w.changed.IsZero(), "changes during reload detected")
+	w.poll(now.Add(5 * time.Second))
+	assert.Equal(t, 2, reloads)
+	assert.Equal(t, 3, len(w.files))
+	w.poll(now.Add(10 * time.Second))
+	assert.Equal(t, 2, reloads)
+	close(actionCh)
+}
@@ -10,7 +10,10 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/version"
 	"gopkg.in/yaml.v3"
 )
@@ -51,6 +54,7 @@ const (
           <div><a href="/targets">Targets</a></div>
           <div><a href="/loglevel">loglevel</a></div>
           <div><a href="/status">Status</a></div>
+          <div><a href="/debug/scrape">Debug scrape</a></div>
           <div><a href="/debug/pprof">Profiling</a></div>
           <div><a href="/httpapi_exporter_metrics">Exporter Metrics</a></div>
           <div><a href="{{ .DocsUrl }}">Help</a></div>
@@ -119,6 +123,72 @@ const (
 	</table>
   {{- end }}
 
+    {{ define "content.debug_scrape" -}}
+      <h2>Debug scrape</h2>
+      <form action="/debug/scrape" method="get">
+        <label>target <input type="text" name="target" value="{{ with .Report }}{{ .Target }}{{ end }}"/></label>
+        <label>collector <input type="text" name="collector" value="{{ with .Report }}{{ range $i, $c := .Collectors }}{{ if $i }},{{ end }}{{ $c }}{{ end }}{{ end }}"/></label>
+        <input type="submit" value="Scrape"/>
+      </form>
+      {{ with .Report -}}
+      <table>
+        <tbody>
+          <tr class="odd"><th>Target</th><td>{{ .Target }}</td></tr>
+          <tr><th>Start</th><td>{{ .Start }}</td></tr>
+          <tr class="odd"><th>Duration (s)</th><td>{{ printf "%.3f" .Duration }}</td></tr>
+          <tr><th>Actions</th><td>{{ range .ActionsSummary }}{{ . }} {{ end }}</td></tr>
+          {{ if .Error }}<tr class="odd"><th>Error</th><td>{{ .Error }}</td></tr>{{ end }}
+        </tbody>
+      </table>
+      <h3>Actions</h3>
+      <table>
+        <thead>
+          <tr><th>Collector</th><th>Script</th><th>Action</th><th>Source</th><th>Duration (s)</th><th>Loop items</th><th>When</th><th>Symbols changed</th><th>Error</th></tr>
+        </thead>
+        <tbody>
+          {{ range $i, $act := .Actions -}}
+          <tr{{ if odd $i }} class="odd"{{ end }}>
+            <td>{{ $act.Collector }}</td>
+            <td>{{ $act.Script }}</td>
+            <td><pre style="margin: 0; padding: 0; border: 0;">{{ $act.Indent }}{{ $act.Type }} {{ $act.Name }}</pre></td>
+            <td>{{ $act.Source }}</td>
+            <td>{{ printf "%.3f" $act.Duration }}</td>
+            <td>{{ if $act.LoopCount }}{{ $act.LoopCount }}<pre>{{ json $act.LoopItems }}</pre>{{ end }}</td>
+            <td>{{ range $act.When }}#{{ .Index }} {{ .Cond }}: {{ .Result }}{{ if .Error }} ({{ .Error }}){{ end }}<br/>{{ end }}</td>
+            <td>{{ if $act.Symbols }}<pre>{{ json $act.Symbols }}</pre>{{ end }}</td>
+            <td>{{ $act.Error }}</td>
+          </tr>
+          {{- end }}
+        </tbody>
+      </table>
+      <h3>HTTP requests</h3>
+      <table>
+        <thead>
+          <tr><th>Collector</th><th>Request</th><th>Status</th><th>Duration (s)</th><th>Request details</th><th>Response details</th><th>Error</th></tr>
+        </thead>
+        <tbody>
+          {{ range $i, $req := .Requests -}}
+          <tr{{ if odd $i }} class="odd"{{ end }}>
+            <td>{{ $req.Collector }}</td>
+            <td>{{ $req.Method }} {{ $req.Url }}{{ if gt $req.Try 1 }} (try {{ $req.Try }}){{ end }}</td>
+            <td>{{ $req.StatusCode }}</td>
+            <td>{{ printf "%.3f" $req.Duration }}</td>
+            <td><pre>{{ json $req.RequestHeaders }}{{ if $req.RequestBody }}
+
+{{ $req.RequestBody }}{{ end }}</pre></td>
+            <td><pre>{{ json $req.ResponseHeaders }}{{ if $req.ResponseBody }}
+
+{{ $req.ResponseBody }}{{ end }}</pre></td>
+            <td>{{ $req.Error }}</td>
+          </tr>
+          {{- end }}
+        </tbody>
+      </table>
+      <h3>Metrics</h3>
+      <pre>{{ .Metrics }}</pre>
+      {{- end }}
+    {{- end }}
+
     {{ define "content.error" -}}
       <h2>Error</h2>
       <pre>{{ .Err }}</pre>
@@ -153,16 +223,22 @@ type tdata struct {
 	Message string
 	// `/error` only
 	Err error
+	// `/debug/scrape` only
+	Report *ScrapeReport
 }
 
 var (
-	allTemplates    = template.Must(template.New("").Parse(templates))
-	healthTemplate  = pageTemplate("health")
-	homeTemplate    = pageTemplate("home")
-	configTemplate  = pageTemplate("config")
-	targetsTemplate = pageTemplate("targets")
-	statusTemplate  = pageTemplate("status")
-	errorTemplate   = pageTemplate("error")
+	allTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+		"json": debugJSON,
+		"odd":  func(i int) bool { return i%2 == 1 },
+	}).Parse(templates))
+	healthTemplate      = pageTemplate("health")
+	homeTemplate        = pageTemplate("home")
+	configTemplate      = pageTemplate("config")
+	targetsTemplate     = pageTemplate("targets")
+	statusTemplate      = pageTemplate("status")
+	debugScrapeTemplate = pageTemplate("debug_scrape")
+	errorTemplate       = pageTemplate("error")
 )
 
 func pageTemplate(name string) *template.Template {
@@ -235,7 +311,19 @@ func ConfigHandlerFunc(metricsPath string, exporter Exporter) func(http.Response
 	}
 }
 
-// ReloadHandlerFunc is the HTTP handler for the POST reload entry point (`/reload`).
+// reloadResponse is the json body of `/reload` responses.
+type reloadResponse struct {
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+	Data    struct {
+		Reload bool        `json:"reload"`
+		Diff   *ConfigDiff `json:"diff,omitempty"`
+	} `json:"data"`
+}
+
+// ReloadHandlerFunc is the HTTP handler for the POST reload entry point (`/reload`). The response contains the
+// changes between current and new config; if the new config is invalid, the current one is kept.
+// With `check=health` parameter, the targets added or changed are health checked before the new config is applied.
 func ReloadHandlerFunc(metricsPath string, exporter Exporter, reloadCh chan<- actionMsg) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var result []byte
@@ -251,12 +339,27 @@ func ReloadHandlerFunc(metricsPath string, exporter Exporter, reloadCh chan<- ac
 			"received /reload from %s", "client", r.RemoteAddr)
 
 		msg := actionMsg{
-			actionType: ACTION_RELOAD,
-			retCh:      make(chan error),
+			actionType:  ACTION_RELOAD,
+			trigger:     reloadTriggerHttp,
+			checkHealth: r.URL.Query().Get("check") == "health",
+			diffCh:      make(chan *ConfigDiff, 1),
+			retCh:       make(chan error),
 		}
 		reloadCh <- msg
-		if err := <-msg.retCh; err != nil {
-			HandleError(http.StatusInternalServerError, err, metricsPath, exporter, w, r)
+		err := <-msg.retCh
+		diff := <-msg.diffCh
+
+		status := http.StatusOK
+		message := "OK reload done."
+		if err != nil {
+			message = err.Error()
+			// config can't be loaded, or is loaded but invalid
+			status = http.StatusInternalServerError
+			if diff != nil {
+				status = http.StatusUnprocessableEntity
+			}
+		} else if diff != nil {
+			message = fmt.Sprintf("OK reload done: %s.", diff)
 		}
 
 		accept_type := r.Header.Get(acceptHeader)
@@ -271,21 +374,38 @@ func ReloadHandlerFunc(metricsPath string, exporter Exporter, reloadCh chan<- ac
 		switch accept_type {
 		case textPLAIN:
 			w.Header().Set(contentTypeHeader, textPLAIN)
-			result = []byte(`OK reload asked.`)
+			result = []byte(message)
 		case applicationJSON:
+			res := &reloadResponse{
+				Message: message,
+			}
+			if err == nil {
+				res.Status = 1
+				res.Data.Reload = true
+			}
+			res.Data.Diff = diff
+			var m_err error
+			if result, m_err = json.Marshal(res); m_err != nil {
+				HandleError(http.StatusInternalServerError, m_err, metricsPath, exporter, w, r)
+				return
+			}
 			w.Header().Set(contentTypeHeader, applicationJSON)
-			result = []byte(`{"message":"ok","status": 1,"data": {"reload": true}}`)
 			w.Header().Set(contentLengthHeader, fmt.Sprint(len(result)))
 		default:
+			if err != nil {
+				HandleError(status, err, metricsPath, exporter, w, r)
+				return
+			}
 			w.Header().Set(contentTypeHeader, textHTML)
 			healthTemplate.Execute(w, &tdata{
 				ExporterName: exporter.Config().Globals.ExporterName,
 				MetricsPath:  metricsPath,
 				DocsUrl:      docsUrl,
-				Message:      `OK reload asked.`,
+				Message:      message,
 			})
 			return
 		}
+		w.WriteHeader(status)
 		w.Write(result)
 	}
 }
@@ -484,6 +604,100 @@ func TargetsHandlerFunc(metricsPath string, exporter Exporter) func(http.Respons
 	}
 }
 
+// DebugScrapeHandlerFunc is the HTTP handler for the `/debug/scrape` page. It runs a one-off collection of a target,
+// records every action, condition and http request played, and outputs the report with the metrics collected.
+// The page is not found unless enabled by --web.enable-debug-scrape flag.
+func DebugScrapeHandlerFunc(metricsPath string, exporter Exporter, enabled bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report *ScrapeReport
+
+		if !enabled {
+			err := errors.New("debug scrape is disabled: start the exporter with --web.enable-debug-scrape")
+			HandleError(http.StatusNotFound, err, metricsPath, exporter, w, r)
+			return
+		}
+
+		params := r.URL.Query()
+		accept_json := strings.Contains(r.Header.Get(acceptHeader), applicationJSON)
+		tName := strings.TrimSpace(params.Get("target"))
+		if tName != "" {
+			target, err := exporter.FindTarget(tName)
+			if err != nil {
+				HandleError(http.StatusNotFound, err, metricsPath, exporter, w, r)
+				return
+			}
+
+			// collectors may be set as a list of parameters or comma separated
+			collectors := make([]string, 0, len(params["collector"]))
+			for _, param := range params["collector"] {
+				for name := range strings.SplitSeq(param, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						collectors = append(collectors, name)
+					}
+				}
+			}
+			if err := setSpecificCollectors(exporter, target, collectors); err != nil {
+				HandleError(http.StatusNotFound, err, metricsPath, exporter, w, r)
+				return
+			}
+			auth_key, err := authKeyFor(r, exporter.Config().Globals)
+			if err != nil {
+				HandleError(http.StatusBadRequest, err, metricsPath, exporter, w, r)
+				return
+			}
+			if auth_key != "" {
+				target.SetSymbol("auth_key", auth_key)
+			}
+
+			report = NewScrapeReport(target.Name(), collectors)
+			ctx, cancel := contextFor(r, exporter, target)
+			defer cancel()
+			ctx = contextWithScrapeReport(ctx, report)
+
+			// Go through prometheus.Gatherers to sanitize and sort metrics, as for the metrics handler.
+			units := &unitsGatherer{gatherer: exporter.WithContext(ctx, target, false)}
+			mfs, err := prometheus.Gatherers{units}.Gather()
+			units.restore(mfs)
+			if err != nil {
+				report.Error = err.Error()
+			}
+			var buf strings.Builder
+			enc := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeTextPlain))
+			for _, mf := range mfs {
+				if err := enc.Encode(mf); err != nil {
+					exporter.Logger().Info(
+						fmt.Sprintf("Error encoding metric family %q: %s", mf.GetName(), err.Error()))
+				}
+			}
+			report.Metrics = buf.String()
+			report.Duration = time.Since(report.Start).Seconds()
+		} else if accept_json {
+			HandleError(http.StatusBadRequest, errors.New("Target parameter is missing"), metricsPath, exporter, w, r)
+			return
+		}
+
+		if accept_json {
+			res, err := json.Marshal(report)
+			if err != nil {
+				HandleError(http.StatusInternalServerError, err, metricsPath, exporter, w, r)
+				return
+			}
+			w.Header().Set(contentTypeHeader, applicationJSON)
+			w.Header().Set(contentLengthHeader, fmt.Sprint(len(res)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(res)
+		} else {
+			w.Header().Set(contentTypeHeader, textHTML)
+			debugScrapeTemplate.Execute(w, &tdata{
+				ExporterName: exporter.Config().Globals.ExporterName,
+				MetricsPath:  metricsPath,
+				DocsUrl:      docsUrl,
+				Report:       report,
+			})
+		}
+	}
+}
+
 // HandleError is an error handler that other handlers defer to in case of error. It is important to not have written
 // anything to w before calling HandleError(), or the 500 status code won't be set (and the content might be mixed up).
 func HandleError(status int, err error, metricsPath string, exporter Exporter, w http.ResponseWriter, r *http.Request) {
@@ -53,6 +53,7 @@ type DebugAction struct {
 
 	Debug *DebugActionConfig `yaml:"debug" json:"debug"`
 	vars  [][]any
+	pos   SourcePos
 }
 
 func (a *DebugAction) Type() int {
@@ -118,6 +119,13 @@ func (a *DebugAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *DebugAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *DebugAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 // func (a *DebugAction) GetBaseAction() *BaseAction {
 // 	return nil
 // }
@@ -172,11 +180,14 @@ func (a *DebugAction) CustomAction(script *YAMLScript, symtab map[string]any, lo
 			"name", a.GetName(symtab, logger))
 	}
 
+	// message may be built from any symbol: secret ones must not be displayed
+	str = redactor.StringWithSymbols(str, symtab)
 	logger.Debug(
 		fmt.Sprintf("    message: %s", str),
 		"coll", CollectorId(symtab, logger),
 		"script", ScriptName(symtab, logger),
-		"name", a.GetName(symtab, logger))
+		"name", a.GetName(symtab, logger),
+		"pos", a.pos.String())
 
 	return nil
 }
@@ -0,0 +1,241 @@
+// cSpell:ignore symtab
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// key of the symbols table that stores the recorder of a debug scrape.
+const debugScrapeKey = "__debug_scrape"
+
+const (
+	// max number of loop items kept for an action
+	debugMaxItems = 20
+	// max length of bodies of requests and responses
+	debugMaxBody = 4096
+)
+
+type debugScrapeCtxKey struct{}
+
+// ScrapeReport is the result of a debug scrape: the trace of all actions and http requests played and the
+// metrics collected.
+type ScrapeReport struct {
+	Target     string          `json:"target"`
+	Collectors []string        `json:"collectors,omitempty"`
+	Start      time.Time       `json:"start"`
+	Duration   float64         `json:"duration_seconds"`
+	Error      string          `json:"error,omitempty"`
+	Actions    []*ActionTrace  `json:"actions"`
+	Requests   []*RequestTrace `json:"requests"`
+	Metrics    string          `json:"metrics"`
+
+	mutex sync.Mutex
+	// depth of current action by collector
+	depth map[string]int
+	// last serialized values of symbols by collector, to record only changes
+	symbols map[string]map[string]string
+}
+
+// ActionTrace is the trace of an action played during a debug scrape.
+type ActionTrace struct {
+	Collector string         `json:"collector"`
+	Script    string         `json:"script"`
+	Type      string         `json:"type"`
+	Name      string         `json:"name"`
+	Source    string         `json:"source,omitempty"`
+	Depth     int            `json:"depth"`
+	Duration  float64        `json:"duration_seconds"`
+	LoopCount int            `json:"loop_count,omitempty"`
+	LoopItems []any          `json:"loop_items,omitempty"`
+	When      []*WhenTrace   `json:"when,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Symbols   map[string]any `json:"symbols_changed,omitempty"`
+
+	start time.Time
+}
+
+// WhenTrace is the result of a condition of an action for a loop item.
+type WhenTrace struct {
+	Index  int    `json:"index"`
+	Cond   string `json:"cond"`
+	Result bool   `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RequestTrace is an http request sent to the target and its response.
+type RequestTrace struct {
+	Collector       string              `json:"collector"`
+	Script          string              `json:"script"`
+	Method          string              `json:"method"`
+	Url             string              `json:"url"`
+	Try             int                 `json:"try"`
+	RequestHeaders  map[string][]string `json:"request_headers,omitempty"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	StatusCode      int                 `json:"status_code"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	ResponseBody    string              `json:"response_body,omitempty"`
+	Duration        float64             `json:"duration_seconds"`
+	Error           string              `json:"error,omitempty"`
+}
+
+// NewScrapeReport returns an empty report for target.
+func NewScrapeReport(target string, collectors []string) *ScrapeReport {
+	return &ScrapeReport{
+		Target:     target,
+		Collectors: collectors,
+		Start:      time.Now(),
+		Actions:    make([]*ActionTrace, 0),
+		Requests:   make([]*RequestTrace, 0),
+		depth:      make(map[string]int),
+		symbols:    make(map[string]map[string]string),
+	}
+}
+
+// contextWithScrapeReport returns a context that asks target to record the scrape in report.
+func contextWithScrapeReport(ctx context.Context, report *ScrapeReport) context.Context {
+	return context.WithValue(ctx, debugScrapeCtxKey{}, report)
+}
+
+// scrapeReportFromContext returns the report to record the scrape in; nil if not a debug scrape.
+func scrapeReportFromContext(ctx context.Context) *ScrapeReport {
+	if report, ok := ctx.Value(debugScrapeCtxKey{}).(*ScrapeReport); ok {
+		return report
+	}
+	return nil
+}
+
+// scrapeReportFromSymtab returns the report stored in symbols table; nil if not a debug scrape.
+func scrapeReportFromSymtab(symtab map[string]any) *ScrapeReport {
+	if report, ok := symtab[debugScrapeKey].(*ScrapeReport); ok {
+		return report
+	}
+	return nil
+}
+
+// StartAction adds a new action, defined at pos in config files, to the report.
+func (r *ScrapeReport) StartAction(symtab map[string]any, action_type, name string, pos SourcePos) *ActionTrace {
+	collector := GetMapValueString(symtab, "__collector_id")
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	act := &ActionTrace{
+		Collector: collector,
+		Script:    GetMapValueString(symtab, "__name__"),
+		Type:      action_type,
+		Name:      name,
+		Source:    pos.String(),
+		Depth:     r.depth[collector],
+		start:     time.Now(),
+	}
+	r.depth[collector]++
+	r.Actions = append(r.Actions, act)
+	return act
+}
+
+// EndAction sets the result of action and the symbols it has changed.
+func (r *ScrapeReport) EndAction(act *ActionTrace, symtab map[string]any, err error) {
+	act.Duration = time.Since(act.start).Seconds()
+	if err != nil {
+		act.Error = err.Error()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.depth[act.Collector]--
+	last, ok := r.symbols[act.Collector]
+	if !ok {
+		last = make(map[string]string)
+		r.symbols[act.Collector] = last
+	}
+	for key, value := range symtab {
+		if strings.HasPrefix(key, "__") {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			// functions, channels...
+			continue
+		}
+		if prev, ok := last[key]; ok && prev == string(raw) {
+			continue
+		}
+		last[key] = string(raw)
+		if act.Symbols == nil {
+			act.Symbols = make(map[string]any)
+		}
+		act.Symbols[key] = redactor.Value(key, value)
+	}
+}
+
+// SetLoopItems records the items of the loop of action.
+func (act *ActionTrace) SetLoopItems(items []any) {
+	act.LoopCount = len(items)
+	if len(items) > debugMaxItems {
+		items = items[:debugMaxItems]
+	}
+	act.LoopItems = make([]any, len(items))
+	for idx, item := range items {
+		act.LoopItems[idx] = redactor.Value("", item)
+	}
+}
+
+// AddWhen records the result of a condition of action.
+func (act *ActionTrace) AddWhen(index int, cond string, result bool, err error) {
+	when := &WhenTrace{
+		Index:  index,
+		Cond:   cond,
+		Result: result,
+	}
+	if err != nil {
+		when.Error = err.Error()
+	}
+	act.When = append(act.When, when)
+}
+
+// AddRequest adds an http request to the report.
+func (r *ScrapeReport) AddRequest(req *RequestTrace) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Requests = append(r.Requests, req)
+}
+
+// truncateBody returns body limited to debugMaxBody bytes.
+func truncateBody(body string) string {
+	if len(body) > debugMaxBody {
+		return fmt.Sprintf("%s... (%d bytes)", body[:debugMaxBody], len(body))
+	}
+	return body
+}
+
+// ActionsSummary returns the number of actions by type; used by html view.
+func (r *ScrapeReport) ActionsSummary() []string {
+	count := make(map[string]int)
+	for _, act := range r.Actions {
+		count[act.Type]++
+	}
+	res := make([]string, 0, len(count))
+	for action_type, nb := range count {
+		res = append(res, fmt.Sprintf("%s: %d", action_type, nb))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Indent returns the html indentation of action depending on its depth; used by html view.
+func (act *ActionTrace) Indent() string {
+	return strings.Repeat("    ", act.Depth)
+}
+
+// debugJSON returns the value formatted for html view.
+func debugJSON(value any) string {
+	res, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(res)
+}
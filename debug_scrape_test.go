@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugScrapeDisabled(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	e, err := NewExporter(filepath.Join("contribs", "apache", "etc", "apache", "config.yml"), "", logger, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	w := httptest.NewRecorder()
+	DebugScrapeHandlerFunc("/metrics", e, false)(w, httptest.NewRequest(http.MethodGet, "/debug/scrape", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "--web.enable-debug-scrape")
+
+	// enabled: the form to select a target is displayed
+	w = httptest.NewRecorder()
+	DebugScrapeHandlerFunc("/metrics", e, true)(w, httptest.NewRequest(http.MethodGet, "/debug/scrape", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -15,7 +16,6 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/promslog"
 )
 
 var (
@@ -33,6 +33,8 @@ type Exporter interface {
 	Targets() []Target
 	Logger() *slog.Logger
 	AddTarget(*TargetConfig) (Target, error)
+	SetTarget(*TargetConfig) (Target, error)
+	RemoveTarget(string) error
 	FindTarget(string) (Target, error)
 	GetFirstTarget() (Target, error)
 	SetStartTime(time.Time)
@@ -44,7 +46,7 @@ type Exporter interface {
 	GetLogLevel() string
 	IncreaseLogLevel(string)
 
-	ReloadConfig() error
+	ReloadConfig(checkHealth bool) (*ConfigDiff, error)
 }
 
 type exporter struct {
@@ -64,13 +66,16 @@ type exporter struct {
 }
 
 // NewExporter returns a new Exporter with the provided config.
-func NewExporter(configFile string, logger *slog.Logger, collectorName string) (Exporter, error) {
+func NewExporter(configFile string, environment string, logger *slog.Logger, collectorName string) (Exporter, error) {
 
 	registry, consolePrinter := goja_modules.InitJSRegistry(logger, template.Js_func_map())
-	c, err := LoadConfig(configFile, logger, collectorName, registry)
+	c, err := LoadConfig(configFile, environment, logger, collectorName, registry)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.applyGlobals(); err != nil {
+		return nil, err
+	}
 
 	var targets []Target
 	var logContext []interface{}
@@ -175,6 +180,9 @@ func (e *exporter) Gather() ([]*dto.MetricFamily, error) {
 			dtoMetricFamily = &dto.MetricFamily{}
 			dtoMetricFamily.Name = proto.String(metricDesc.Name())
 			dtoMetricFamily.Help = proto.String(metricDesc.Help())
+			if mc := metricDesc.Config(); mc != nil && mc.Unit != "" {
+				dtoMetricFamily.Unit = proto.String(mc.Unit)
+			}
 			switch {
 			case dtoMetric.Gauge != nil:
 				dtoMetricFamily.Type = dto.MetricType_GAUGE.Enum()
@@ -250,6 +258,55 @@ func (e *exporter) AddTarget(tg_config *TargetConfig) (Target, error) {
 	return target, nil
 }
 
+// SetTarget implements Exporter SetTarget.
+// add a static target to config or replace the one with the same name: its sessions are lost.
+func (e *exporter) SetTarget(tg_config *TargetConfig) (Target, error) {
+	var logContext []any
+
+	target, err := NewTarget(logContext, tg_config, e.config.Globals, tg_config.profile, e.logger)
+	if err != nil {
+		return nil, err
+	}
+	e.content_mutex.Lock()
+	defer e.content_mutex.Unlock()
+
+	// lists are copied: they may be used by running scrapes.
+	targets := slices.Clone(e.targets)
+	if idx := slices.IndexFunc(targets, func(t Target) bool { return t.Name() == tg_config.Name }); idx >= 0 {
+		targets[idx] = target
+		deleteTargetMetrics(tg_config.Name)
+	} else {
+		targets = append(targets, target)
+	}
+	configs := slices.Clone(e.config.Targets)
+	if idx := slices.IndexFunc(configs, func(t *TargetConfig) bool { return t.Name == tg_config.Name }); idx >= 0 {
+		configs[idx] = tg_config
+	} else {
+		configs = append(configs, tg_config)
+	}
+	e.targets = targets
+	e.config.Targets = configs
+
+	return target, nil
+}
+
+// RemoveTarget implements Exporter RemoveTarget.
+// remove a target from config.
+func (e *exporter) RemoveTarget(tName string) error {
+	e.content_mutex.Lock()
+	defer e.content_mutex.Unlock()
+
+	if !slices.ContainsFunc(e.targets, func(t Target) bool { return t.Name() == tName }) {
+		return ErrTargetNotFound
+	}
+	// lists are copied: they may be used by running scrapes.
+	e.targets = slices.DeleteFunc(slices.Clone(e.targets), func(t Target) bool { return t.Name() == tName })
+	e.config.Targets = slices.DeleteFunc(slices.Clone(e.config.Targets), func(t *TargetConfig) bool { return t.Name == tName })
+	deleteTargetMetrics(tName)
+
+	return nil
+}
+
 // GetFirstTarget implements Exporter.
 func (e *exporter) GetFirstTarget() (Target, error) {
 	var t_found Target
@@ -342,7 +399,7 @@ func (e *exporter) IncreaseLogLevel(new_lvl string) {
 		e.logLevel = new_lvl
 	}
 	logConfig.Level.Set(e.logLevel)
-	e.logger = promslog.New(&logConfig)
+	e.logger = NewLogger(&logConfig)
 	if e.consolePrinter != nil {
 		e.consolePrinter.SetLogger(e.logger)
 	}
@@ -363,32 +420,56 @@ func (e *exporter) IncreaseLogLevel(new_lvl string) {
 	log(fmt.Sprintf("set log.level to %s", e.logLevel))
 }
 
-func (e *exporter) ReloadConfig() error {
+// ReloadConfig implements Exporter ReloadConfig.
+// The new configuration is loaded and validated; if checkHealth is set, the targets added or changed are health
+// checked too. The current configuration is kept if any check fails. Unchanged targets are kept with their sessions.
+func (e *exporter) ReloadConfig(checkHealth bool) (*ConfigDiff, error) {
 	e.content_mutex.Lock()
-	configFile := e.config.configFile
-	collectorName := e.config.collectorName
+	cur := e.config
+	cur_targets := e.targets
 	e.content_mutex.Unlock()
 
-	c, err := LoadConfig(configFile, e.logger, collectorName, e.registry)
+	c, err := LoadConfig(cur.configFile, cur.environment, e.logger, cur.collectorName, e.registry)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if c.HttpAPIConfigOld != nil {
 		e.logger.Warn("DEPRECATED usage of httpapi_config")
 	}
 
+	diff := diffConfigs(cur, c)
+	diff.Errors = validateConfig(c)
+	if len(diff.Errors) > 0 {
+		return diff, diff.Error()
+	}
+
 	var targets []Target
 	var logContext []interface{}
 	if len(c.Targets) > 1 {
 		targets = make([]Target, 0, len(c.Targets)*3)
 	}
-	for _, t := range c.Targets {
+	var checked []Target
+	for idx, t := range c.Targets {
 		if len(t.TargetsFiles) > 0 {
 			continue
 		}
-		target, err := NewTarget(logContext, t, c.Globals, t.profile, e.logger)
-		if err != nil {
-			return err
+		var target Target
+		if slices.Contains(diff.TargetsUnchanged, t.Name) {
+			// keep the target and its session: its config is replaced by the current one, that is identical.
+			if i := slices.IndexFunc(cur_targets, func(cur_t Target) bool { return cur_t.Name() == t.Name }); i >= 0 {
+				target = cur_targets[i]
+				c.Targets[idx] = target.Config()
+			}
+		}
+		if target == nil {
+			target, err = NewTarget(logContext, t, c.Globals, t.profile, e.logger)
+			if err != nil {
+				diff.Errors = append(diff.Errors, err.Error())
+				return diff, diff.Error()
+			}
+			if t.targetType == TargetTypeStatic {
+				checked = append(checked, target)
+			}
 		}
 		if len(c.Targets) > 1 {
 			targets = append(targets, target)
@@ -396,12 +477,26 @@ func (e *exporter) ReloadConfig() error {
 			targets = []Target{target}
 		}
 	}
+	if checkHealth {
+		if diff.Errors = checkTargetsHealth(checked); len(diff.Errors) > 0 {
+			return diff, diff.Error()
+		}
+	}
 
+	// process wide settings of the new config are set only now that it is applied.
+	if err := c.applyGlobals(); err != nil {
+		diff.Errors = append(diff.Errors, err.Error())
+		return diff, diff.Error()
+	}
 	e.content_mutex.Lock()
 	e.config = c
 	e.targets = targets
 	e.SetReloadTime(time.Now())
 	e.content_mutex.Unlock()
+	diff.Applied = true
+	for _, name := range slices.Concat(diff.TargetsRemoved, diff.TargetsChanged) {
+		deleteTargetMetrics(name)
+	}
 
-	return nil
+	return diff, nil
 }
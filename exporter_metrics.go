@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// internal metrics of the exporter itself; they are exposed with the default go and process collectors
+// on /httpapi_exporter_metrics.
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: exporter_name,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of http requests sent to targets by target, collector and method.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"target", "collector", "method"},
+	)
+	httpRequestQueueWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: exporter_name,
+			Name:      "http_request_queue_wait_seconds",
+			Help:      "Time waited by http requests for the concurrency and rate limits before being sent, by target and collector.",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"target", "collector"},
+	)
+	httpRequestRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: exporter_name,
+			Name:      "http_request_retries_total",
+			Help:      "Number of http requests retried after an unsuccessful status by target and collector.",
+		},
+		[]string{"target", "collector"},
+	)
+	loginAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: exporter_name,
+			Name:      "login_attempts_total",
+			Help:      "Number of login attempts by target and result (ok, failed, error).",
+		},
+		[]string{"target", "result"},
+	)
+	parseFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: exporter_name,
+			Name:      "parse_failures_total",
+			Help:      "Number of responses that could not be decoded by target, collector and parser.",
+		},
+		[]string{"target", "collector", "parser"},
+	)
+	jsErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: exporter_name,
+			Name:      "js_errors_total",
+			Help:      "Number of javascript code execution errors by target and collector.",
+		},
+		[]string{"target", "collector"},
+	)
+	timeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: exporter_name,
+			Name:      "timeouts_total",
+			Help:      "Number of http requests that have reached the scrape or collector timeout by target and collector.",
+		},
+		[]string{"target", "collector"},
+	)
+	scrapesInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: exporter_name,
+			Name:      "scrapes_in_flight",
+			Help:      "Number of scrapes currently running by target.",
+		},
+		[]string{"target"},
+	)
+	configReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: exporter_name,
+			Name:      "config_reloads_total",
+			Help:      "Number of configuration reloads by trigger (signal, http, watch) and result (success, failure).",
+		},
+		[]string{"trigger", "result"},
+	)
+	configLastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: exporter_name,
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		},
+	)
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: exporter_name,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration load.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestDuration,
+		httpRequestQueueWait,
+		httpRequestRetries,
+		loginAttempts,
+		parseFailures,
+		jsErrors,
+		timeouts,
+		scrapesInFlight,
+		configReloads,
+		configLastReloadSuccessful,
+		configLastReloadSuccessTimestamp,
+	)
+}
+
+// countConfigReload records the result of a configuration reload.
+func countConfigReload(trigger string, err error) {
+	if err != nil {
+		configReloads.WithLabelValues(trigger, "failure").Inc()
+		configLastReloadSuccessful.Set(0)
+		return
+	}
+	configReloads.WithLabelValues(trigger, "success").Inc()
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+}
+
+// target label value of internal metrics for dynamic and model targets: their names are not bounded.
+const dynamicTargetLabel = "(dynamic)"
+
+// metricsTargetLabel returns the target label value of internal metrics for target t.
+func metricsTargetLabel(t *TargetConfig) string {
+	if t.targetType != TargetTypeStatic {
+		return dynamicTargetLabel
+	}
+	return t.Name
+}
+
+// deleteTargetMetrics removes the series of internal metrics of target name, when it is removed or replaced.
+// scrapesInFlight is kept: scrapes of the target may still be running and their deferred Dec() would recreate the
+// series at -1; it returns to 0 when they end.
+func deleteTargetMetrics(name string) {
+	labels := prometheus.Labels{"target": name}
+	for _, vec := range []*prometheus.MetricVec{
+		httpRequestDuration.MetricVec,
+		httpRequestQueueWait.MetricVec,
+		httpRequestRetries.MetricVec,
+		loginAttempts.MetricVec,
+		parseFailures.MetricVec,
+		jsErrors.MetricVec,
+		timeouts.MetricVec,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// TargetId returns the name of the target the symbols table belongs to; empty if not set.
+func TargetId(symtab map[string]any) string {
+	return GetMapValueString(symtab, "__target_id")
+}
+
+// metricsIds returns the target and collector labels values of internal metrics from symbols table.
+func metricsIds(symtab map[string]any) (string, string) {
+	return GetMapValueString(symtab, "__metrics_target"), GetMapValueString(symtab, "__collector_id")
+}
+
+// countJSError counts a javascript execution error for the target and collector of symbols table.
+func countJSError(item any) {
+	var target, collector string
+	if symtab, ok := item.(map[string]any); ok {
+		target, collector = metricsIds(symtab)
+	}
+	jsErrors.WithLabelValues(target, collector).Inc()
+}
@@ -7,6 +7,8 @@ import (
 	"html"
 	"log/slog"
 	"reflect"
+	"sort"
+	"text/template/parse"
 
 	"github.com/peekjef72/httpapi_exporter/goja_modules"
 
@@ -32,6 +34,8 @@ type Field struct {
 	tmpl    *exporterTemplate
 	vars    *Variable
 	jscode  *goja_modules.JSCode
+	// where the field is defined
+	pos SourcePos
 }
 
 const (
@@ -154,6 +158,9 @@ func (f *Field) GetValueSimple(
 	if f == nil {
 		return "", nil
 	}
+	defer func() {
+		err = f.locateError(err)
+	}()
 
 	switch f.vartype {
 	case field_template:
@@ -191,6 +198,7 @@ func (f *Field) GetValueSimple(
 	case field_js:
 		val, err := f.jscode.Run(item, logger)
 		if err != nil {
+			countJSError(item)
 			return "", newVarError(error_var_invalid_javascript_code,
 				fmt.Sprintf("invalid javascript code execution: %s", err.Error()))
 		}
@@ -342,6 +350,8 @@ const (
 type varError struct {
 	code    int
 	message string
+	// position of the field that raised the error
+	pos SourcePos
 }
 
 type VarError interface {
@@ -357,6 +367,9 @@ func newVarError(code int, msg string) *varError {
 }
 
 func (e *varError) Error() string {
+	if e.pos.IsSet() {
+		return fmt.Sprintf("%s: getVarError %d: %s", e.pos, e.code, e.message)
+	}
 	return fmt.Sprintf("getVarError %d: %s", e.code, e.message)
 }
 
@@ -373,6 +386,9 @@ func (f *Field) GetValueObject(
 	if f == nil {
 		return res_slice, nil
 	}
+	defer func() {
+		err = f.locateError(err)
+	}()
 
 	switch f.vartype {
 	case field_template:
@@ -424,6 +440,7 @@ func (f *Field) GetValueObject(
 
 		val, err := f.jscode.Run(symtab, logger)
 		if err != nil {
+			countJSError(symtab)
 			return res_slice, newVarError(error_var_invalid_javascript_code,
 				fmt.Sprintf("invalid javascript code execution: %s", err.Error()))
 		}
@@ -433,6 +450,36 @@ func (f *Field) GetValueObject(
 	return RawGetValueString(f.raw), nil
 }
 
+// SetPos sets the position of the definition of the field; used in evaluation errors.
+func (f *Field) SetPos(pos SourcePos) {
+	if f != nil {
+		f.pos = pos
+	}
+}
+
+// Pos returns the position of the definition of the field.
+func (f *Field) Pos() SourcePos {
+	if f == nil {
+		return SourcePos{}
+	}
+	return f.pos
+}
+
+// locateError sets the position of the field on an evaluation error; VarError are kept as is for callers that
+// check their code.
+func (f *Field) locateError(err error) error {
+	if err == nil || !f.pos.IsSet() {
+		return err
+	}
+	if ve, ok := err.(*varError); ok {
+		if !ve.pos.IsSet() {
+			ve.pos = f.pos
+		}
+		return ve
+	}
+	return withSourcePos(f.pos, err)
+}
+
 func (f *Field) String() string {
 	if f == nil {
 		return ""
@@ -449,6 +496,99 @@ func (f *Field) String() string {
 	return f.raw
 }
 
+// Identifiers returns the names of the symbols the field reads from the symbols table: the variables of a $var
+// field, the global identifiers of a js field, and the fields of the root of a template (in range and with blocks,
+// dot is not the root: only $.name are returned).
+func (f *Field) Identifiers() []string {
+	if f == nil {
+		return nil
+	}
+	idents := make(map[string]bool)
+	switch f.vartype {
+	case field_template:
+		if f.tmpl != nil && f.tmpl.Tree != nil {
+			templateIdentifiers(f.tmpl.Tree.Root, true, idents)
+		}
+	case field_var:
+		variableIdentifiers(f.vars, idents)
+	case field_js:
+		for _, ident := range f.jscode.SymbolIdentifiers() {
+			idents[ident] = true
+		}
+	}
+	res := make([]string, 0, len(idents))
+	for ident := range idents {
+		res = append(res, ident)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// variableIdentifiers adds to idents the names of v and of the variables used as attributes.
+func variableIdentifiers(v *Variable, idents map[string]bool) {
+	if v == nil {
+		return
+	}
+	if v.vartype == vartype_var {
+		idents[v.raw] = true
+	}
+	for _, attr := range v.attributes {
+		variableIdentifiers(attr, idents)
+	}
+}
+
+// templateIdentifiers adds to idents the fields of the root of the template referenced by node; root is false when
+// dot is not the root of the symbols table.
+func templateIdentifiers(node parse.Node, root bool, idents map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, sub := range n.Nodes {
+			templateIdentifiers(sub, root, idents)
+		}
+	case *parse.ActionNode:
+		templateIdentifiers(n.Pipe, root, idents)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateIdentifiers(cmd, root, idents)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateIdentifiers(arg, root, idents)
+		}
+	case *parse.FieldNode:
+		if root && len(n.Ident) > 0 {
+			idents[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		// $.name
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			idents[n.Ident[1]] = true
+		}
+	case *parse.ChainNode:
+		templateIdentifiers(n.Node, root, idents)
+	case *parse.IfNode:
+		templateIdentifiers(n.Pipe, root, idents)
+		templateIdentifiers(n.List, root, idents)
+		templateIdentifiers(n.ElseList, root, idents)
+	case *parse.RangeNode:
+		templateIdentifiers(n.Pipe, root, idents)
+		templateIdentifiers(n.List, false, idents)
+		templateIdentifiers(n.ElseList, root, idents)
+	case *parse.WithNode:
+		templateIdentifiers(n.Pipe, root, idents)
+		templateIdentifiers(n.List, false, idents)
+		templateIdentifiers(n.ElseList, root, idents)
+	case *parse.TemplateNode:
+		templateIdentifiers(n.Pipe, root, idents)
+	}
+}
+
 func (f *Field) MarshalText() (text []byte, err error) {
 
 	return []byte(f.String()), nil
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 
 	"github.com/peekjef72/httpapi_exporter/goja_modules/console"
@@ -104,6 +105,21 @@ func getIdentifiersFromExpression(val ast.Expression, identifiers map[string]str
 			getIdentifiersFromExpression(expr.Key, identifiers, token)
 			getIdentifiersFromExpression(expr.Value, identifiers, token)
 
+		case *ast.FunctionLiteral:
+			if expr.Name != nil {
+				getIdentifiersFromExpression(expr.Name, identifiers, "var")
+			}
+			getIdentifiersFromParameters(expr.ParameterList, identifiers, token)
+			getIdentifierFromStatement(expr.Body, identifiers, token)
+
+		case *ast.ArrowFunctionLiteral:
+			getIdentifiersFromParameters(expr.ParameterList, identifiers, token)
+			switch body := expr.Body.(type) {
+			case *ast.BlockStatement:
+				getIdentifierFromStatement(body, identifiers, token)
+			case *ast.ExpressionBody:
+				getIdentifiersFromExpression(body.Expression, identifiers, token)
+			}
 		}
 
 	}
@@ -136,14 +152,30 @@ func getIdentifierFromStatement(stmt ast.Statement, identifiers map[string]strin
 		getIdentifiersFromExpression(val.Expression, identifiers, token)
 
 	case *ast.ForInStatement:
+		getIdentifiersFromForInto(val.Into, identifiers, token)
 		getIdentifiersFromExpression(val.Source, identifiers, token)
 		getIdentifierFromStatement(val.Body, identifiers, token)
 
 	case *ast.ForOfStatement:
+		getIdentifiersFromForInto(val.Into, identifiers, token)
 		getIdentifiersFromExpression(val.Source, identifiers, token)
 		getIdentifierFromStatement(val.Body, identifiers, token)
 
 	case *ast.ForStatement:
+		switch init := val.Initializer.(type) {
+		case *ast.ForLoopInitializerExpression:
+			getIdentifiersFromExpression(init.Expression, identifiers, token)
+		case *ast.ForLoopInitializerVarDeclList:
+			for _, bind := range init.List {
+				getIdentifiersFromExpression(bind.Target, identifiers, "var")
+				getIdentifiersFromExpression(bind.Initializer, identifiers, token)
+			}
+		case *ast.ForLoopInitializerLexicalDecl:
+			for _, bind := range init.LexicalDeclaration.List {
+				getIdentifiersFromExpression(bind.Target, identifiers, "const")
+				getIdentifiersFromExpression(bind.Initializer, identifiers, token)
+			}
+		}
 		getIdentifiersFromExpression(val.Update, identifiers, token)
 		getIdentifiersFromExpression(val.Test, identifiers, token)
 		getIdentifierFromStatement(val.Body, identifiers, token)
@@ -198,7 +230,31 @@ func getIdentifierFromStatement(stmt ast.Statement, identifiers map[string]strin
 		getIdentifierFromStatement(val.Body, identifiers, token)
 
 	case *ast.FunctionDeclaration:
-		getIdentifierFromStatement(val.Function.Body, identifiers, token)
+		getIdentifiersFromExpression(val.Function, identifiers, token)
+	}
+}
+
+// getIdentifiersFromParameters adds the parameters of a function, declared by it.
+func getIdentifiersFromParameters(params *ast.ParameterList, identifiers map[string]string, token string) {
+	if params == nil {
+		return
+	}
+	for _, bind := range params.List {
+		getIdentifiersFromExpression(bind.Target, identifiers, "var")
+		getIdentifiersFromExpression(bind.Initializer, identifiers, token)
+	}
+	getIdentifiersFromExpression(params.Rest, identifiers, "var")
+}
+
+// getIdentifiersFromForInto adds the variables declared by a for in/of loop.
+func getIdentifiersFromForInto(into ast.ForInto, identifiers map[string]string, token string) {
+	switch val := into.(type) {
+	case *ast.ForIntoVar:
+		getIdentifiersFromExpression(val.Binding.Target, identifiers, "var")
+	case *ast.ForDeclaration:
+		getIdentifiersFromExpression(val.Target, identifiers, "const")
+	case *ast.ForIntoExpression:
+		getIdentifiersFromExpression(val.Expression, identifiers, token)
 	}
 }
 
@@ -373,6 +429,23 @@ func (p *logger_printer) SetLogger(logger *slog.Logger) {
 	p.logger = logger
 }
 
+// SymbolIdentifiers returns the global identifiers of the code that are not defined by the runtime (builtins and
+// modules): they are read from the symbols table when the code is run.
+func (js *JSCode) SymbolIdentifiers() []string {
+	var idents []string
+	for ident, token := range js.idents {
+		// declared by the code
+		if token != "global" {
+			continue
+		}
+		if js.vm == nil || js.vm.Get(ident) == nil {
+			idents = append(idents, ident)
+		}
+	}
+	sort.Strings(idents)
+	return idents
+}
+
 func (js *JSCode) SetSymbolTable(symtab map[string]any, logger *slog.Logger) {
 
 	// set console.xx to logger.xx function
@@ -570,3 +570,31 @@ func TestJSModuleExporterDate(t *testing.T) {
 // 	}
 
 // }
+
+func TestJSModuleSymbolIdentifiers(t *testing.T) {
+
+	registry, _ := InitJSRegistry(nil, nil)
+
+	code := `
+		var res = []
+		for( const [label, value] of Object.entries(results)) {
+			if( value > threshold ) {
+				res.push(label)
+			}
+		}
+		for( let idx = 0; idx < res.length; idx++ ) {
+			console.info(res[idx])
+		}
+		function first(list) {
+			return list[0]
+		}
+		res.filter((label) => label != ignored).map(first)
+	`
+	js, err := NewJSCode(registry, code)
+	if err != nil {
+		assert.Nil(t, err, fmt.Sprintf(`TestJSModuleSymbolIdentifiers compilation error: %s`, err.Error()))
+		return
+	}
+	// declared variables and runtime globals (Object, console) are not symbols
+	assert.Equal(t, []string{"ignored", "results", "threshold"}, js.SymbolIdentifiers())
+}
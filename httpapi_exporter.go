@@ -43,12 +43,18 @@ func init() {
 var (
 	metricsPath    = kingpin.Flag("web.telemetry-path", "Path under which to expose collector's internal metrics.").Default("/metrics").String()
 	configFile     = kingpin.Flag("config.file", "Exporter configuration file.").Short('c').Default("config/config.yml").String()
+	configEnv      = kingpin.Flag("config.env", "Environment of the configuration: overlay file config.<env>.yml is merged over the configuration file if it exists.").Envar("HTTPAPI_EXPORTER_CONFIG_ENV").String()
 	dry_run        = kingpin.Flag("dry-run", "Only check exporter configuration file and exit.").Short('n').Default("false").Bool()
 	target_name    = kingpin.Flag("target", "In dry-run mode specify the target name, else ignored.").Short('t').String()
 	model_name     = kingpin.Flag("model", "In dry-run mode specify the model name to build the dynamic target, else ignored.").Default("default").Short('m').String()
 	auth_key       = kingpin.Flag("auth.key", "In dry-run mode specify the auth_key to use, else ignored.").Short('a').String()
 	collector_name = kingpin.Flag("collector", "Specify the collector name restriction to collect, replace the collector_names set for each target.").Short('o').String()
+	watchConfig    = kingpin.Flag("config.watch", "Reload the configuration when config file, collector_files, profiles_file_config, targets_files or javascript modules change.").Default("false").Bool()
+	watchInterval  = kingpin.Flag("config.watch-interval", "Period of the checks of the configuration files changes.").Default("5s").Duration()
+	watchDebounce  = kingpin.Flag("config.watch-debounce", "Delay without new change before the configuration is reloaded.").Default("2s").Duration()
+	debugScrape    = kingpin.Flag("web.enable-debug-scrape", "Enable /debug/scrape endpoint: its reports contain the requests and responses exchanged with targets.").Default("false").Bool()
 	toolkitFlags   = kingpinflag.AddFlags(kingpin.CommandLine, metricsPublishingPort)
+	runCmd         = kingpin.Command("run", "Run the exporter (default command).").Default()
 	logConfig      = promslog.Config{Style: promslog.GoKitStyle}
 )
 
@@ -91,7 +97,13 @@ func BuildHandler(exporter Exporter, actionCh chan<- actionMsg) http.Handler {
 		newRoute(OpMatch, "/loglevel(?:/(.*))?", LogLevelHandlerFunc(*metricsPath, exporter, actionCh, "")),
 		newRoute(OpEqual, "/status", StatusHandlerFunc(*metricsPath, exporter)),
 		newRoute(OpMatch, "/targets(?:/(.*))?", TargetsHandlerFunc(*metricsPath, exporter)),
+		newRoute(OpEqual, "/sd", ServiceDiscoveryHandlerFunc(*metricsPath, exporter)),
+		newRoute(OpMatch, "/api/v1/targets(?:/(.+))?", TargetsApiHandlerFunc(*metricsPath, exporter)),
+		newRoute(OpEqual, "/probe_many", ProbeManyHandlerFunc(*metricsPath, exporter)),
 		newRoute(OpEqual, *metricsPath, func(w http.ResponseWriter, r *http.Request) { ExporterHandlerFor(exporter).ServeHTTP(w, r) }),
+		// one-off collection of a target with the trace of actions and requests: disabled by default, because the
+		// reports contain the bodies exchanged with targets (login responses, session tokens).
+		newRoute(OpEqual, "/debug/scrape", DebugScrapeHandlerFunc(*metricsPath, exporter, *debugScrape)),
 		// Expose exporter metrics separately, for debugging purposes.
 
 		// pprof handle
@@ -131,7 +143,13 @@ func BuildHandler(exporter Exporter, actionCh chan<- actionMsg) http.Handler {
 type actionMsg struct {
 	actionType int
 	logLevel   string
-	retCh      chan error
+	// origin of a reload: reloadTriggerHttp or reloadTriggerWatch
+	trigger string
+	// reload: health check the targets added or changed before applying the new config
+	checkHealth bool
+	// reload: receives the changes of config, before the result is sent to retCh; must be buffered
+	diffCh chan *ConfigDiff
+	retCh  chan error
 }
 
 const (
@@ -139,18 +157,34 @@ const (
 	ACTION_LOGLEVEL = iota
 )
 
+// origins of config reloads, used as trigger label of config_reloads_total metric
+const (
+	reloadTriggerSignal = "signal"
+	reloadTriggerHttp   = "http"
+	reloadTriggerWatch  = "watch"
+)
+
 func main() {
 
 	flag.AddFlags(kingpin.CommandLine, &logConfig)
 	kingpin.Version(version.Print(exporter_name)).VersionFlag.Short('V')
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+	if command == schemaCmd.FullCommand() {
+		os.Exit(runSchemaCommand(os.Stdout, os.Stderr))
+	}
+	if command == lintCmd.FullCommand() {
+		os.Exit(runLintCommand(os.Stdout, os.Stderr))
+	}
+	if command != runCmd.FullCommand() {
+		os.Exit(runSecretCommand(command, os.Stdin, os.Stdout, os.Stderr))
+	}
 
-	logger := promslog.New(&logConfig)
+	logger := NewLogger(&logConfig)
 	logger.Info(fmt.Sprintf("Starting %s", exporter_name), "version", version.Info())
 	logger.Info("Build context", "build_context", version.BuildContext())
 
-	exporter, err := NewExporter(*configFile, logger, *collector_name)
+	exporter, err := NewExporter(*configFile, *configEnv, logger, *collector_name)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error creating exporter: %s", err))
 		os.Exit(1)
@@ -158,10 +192,19 @@ func main() {
 
 	if exporter.Config().Globals.LogLevel != "" {
 		logConfig.Level.Set(exporter.Config().Globals.LogLevel)
-		logger = promslog.New(&logConfig)
+		logger = NewLogger(&logConfig)
 	}
 	exporter.SetLogLevel(logConfig.Level.String())
 
+	shutdownTracing := func(context.Context) error { return nil }
+	if tc := exporter.Config().Globals.Tracing; tc != nil {
+		if shutdownTracing, err = InitTracing(tc, exporter.Config().Globals.ExporterName); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("tracing enabled", "exporter", tc.Exporter)
+	}
+
 	if *dry_run {
 		logger.Info("configuration OK.")
 		// get the target if defined
@@ -253,12 +296,15 @@ func main() {
 				closer.Close()
 			}
 		}
+		shutdownTracing(context.Background())
 		logger.Info("dry-run is over. Exiting.")
 		os.Exit(0)
 	}
 
 	exporter.SetStartTime(time.Now())
 	exporter.SetReloadTime(time.Now())
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
 
 	user2 := make(chan os.Signal, 1)
 	init_sigusr2(user2)
@@ -272,20 +318,27 @@ func main() {
 				exporter.IncreaseLogLevel("")
 			case <-hup:
 				logger.Info("file reloading.")
-				if err := exporter.ReloadConfig(); err != nil {
+				diff, err := exporter.ReloadConfig(false)
+				countConfigReload(reloadTriggerSignal, err)
+				if err != nil {
 					logger.Error(fmt.Sprintf("reload err: %s.", err))
 				} else {
-					logger.Info("file reloaded.")
+					logger.Info("file reloaded.", "changes", diff.String())
 				}
 			case action := <-actionCh:
 				switch action.actionType {
 				case ACTION_RELOAD:
-					logger.Info("file reloading received.")
-					if err := exporter.ReloadConfig(); err != nil {
+					logger.Info("file reloading received.", "trigger", action.trigger)
+					diff, err := exporter.ReloadConfig(action.checkHealth)
+					countConfigReload(action.trigger, err)
+					if action.diffCh != nil {
+						action.diffCh <- diff
+					}
+					if err != nil {
 						logger.Error(fmt.Sprintf("reload err: %s.", err))
 						action.retCh <- err
 					} else {
-						logger.Info("file reloaded.")
+						logger.Info("file reloaded.", "changes", diff.String())
 						action.retCh <- nil
 					}
 				case ACTION_LOGLEVEL:
@@ -301,6 +354,12 @@ func main() {
 		}
 	}()
 
+	if *watchConfig {
+		watcher := NewConfigWatcher(func() []string { return exporter.Config().WatchedFiles() }, actionCh, *watchInterval, *watchDebounce, logger)
+		go watcher.Run(nil)
+		logger.Info("config files watcher started", "interval", *watchInterval, "debounce", *watchDebounce)
+	}
+
 	service := make(chan struct{})
 	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
@@ -323,6 +382,8 @@ func main() {
 		select {
 		case <-term:
 			logger.Info("Received SIGTERM, exiting gracefully...")
+			// flush pending spans
+			shutdownTracing(context.Background())
 			os.Exit(0)
 		case <-service:
 			os.Exit(1)
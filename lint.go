@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/peekjef72/httpapi_exporter/goja_modules"
+	"github.com/peekjef72/httpapi_exporter/template"
+	"gopkg.in/yaml.v3"
+)
+
+var lintCmd = kingpin.Command("lint", "Check the configuration (config file set by --config.file and --config.env) and report warnings on scripts with their file and line; exit code is 1 if the configuration can't be loaded, 2 if warnings are reported.")
+
+// checks of the linter
+const (
+	lintUndefinedVar = "undefined-variable"
+	lintUnusedFact   = "unused-fact"
+	lintUnreachable  = "unreachable-script"
+	lintLoopVar      = "loop-var-shadowing"
+	lintMetricHelp   = "metric-without-help"
+	lintSnakeCase    = "not-snake-case"
+)
+
+// scripts of a profile played by the exporter; others must be played by a play_script action.
+var profileScripts = []string{"init", "login", "logout", "clear", "ping"}
+
+var snakeCaseRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// lintDiag is a warning of the linter at a location of a config file.
+type lintDiag struct {
+	file   string
+	line   int
+	column int
+	check  string
+	msg    string
+}
+
+func (d lintDiag) String() string {
+	return fmt.Sprintf("%s:%d:%d: warning: %s [%s]", d.file, d.line, d.column, d.msg, d.check)
+}
+
+// lintRef is a reference to a symbol by a field of an action.
+type lintRef struct {
+	name string
+	file string
+	node *yaml.Node
+	// in a metric of a metrics action with a scope: relative to the scope
+	scoped bool
+}
+
+// lintScript is a script to check: its yaml definition and the actions parsed from it.
+type lintScript struct {
+	file    string
+	owner   string // "collector <name>" or "profile <name>"
+	name    string
+	profile bool
+	node    *yaml.Node
+	script  *YAMLScript
+}
+
+type linter struct {
+	registry *goja_modules.JSRegistry
+	scripts  []*lintScript
+	diags    []lintDiag
+
+	// symbols defined by scripts or config, with the location of set_fact definitions
+	defined map[string]bool
+	facts   map[string]lintRef
+	refs    []lintRef
+	// scripts played by play_script actions
+	played map[string]bool
+	// file of the nodes of the config tree: the config file, the files it includes or its overlay
+	nodeFiles map[*yaml.Node]string
+}
+
+// runLintCommand loads the configuration and outputs the warnings of the linter; it returns the exit code.
+func runLintCommand(stdout, stderr io.Writer) int {
+	logger := slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	registry, _ := goja_modules.InitJSRegistry(logger, template.Js_func_map())
+	c, err := LoadConfig(*configFile, *configEnv, logger, "", registry)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	diags, err := LintConfig(c)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	for _, d := range diags {
+		fmt.Fprintln(stdout, d)
+	}
+	if len(diags) > 0 {
+		return 2
+	}
+	return 0
+}
+
+// LintConfig returns the warnings on the scripts of the collectors and profiles of a loaded configuration.
+func LintConfig(c *Config) ([]lintDiag, error) {
+	l := &linter{
+		registry: c.registry,
+		defined:  make(map[string]bool),
+		facts:    make(map[string]lintRef),
+		played:   make(map[string]bool),
+	}
+	for _, name := range builtinSymbols {
+		l.defined[name] = true
+	}
+	// symbols set from config
+	for _, t := range c.Targets {
+		for name := range t.CustomProperties {
+			l.defined[name] = true
+		}
+	}
+	for _, coll := range c.collectors {
+		for name := range coll.Params {
+			l.defined[name] = true
+		}
+	}
+
+	if err := l.loadScripts(c); err != nil {
+		return nil, err
+	}
+	for _, ls := range l.scripts {
+		l.checkActions(ls, ls.script.Actions, ls.node.Content, nil, false)
+	}
+	l.checkSymbols()
+	l.checkScripts()
+
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i], l.diags[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		return a.column < b.column
+	})
+	return l.diags, nil
+}
+
+// loadScripts reads the definitions of the scripts of collectors and profiles from the config files.
+func (l *linter) loadScripts(c *Config) error {
+	loader := &configLoader{}
+	root, err := loader.load(c.configFile, c.environment)
+	if err != nil {
+		return err
+	}
+	l.nodeFiles = loader.nodeFiles
+	if node := mapValue(root, "collectors"); node != nil && node.Kind == yaml.SequenceNode {
+		for _, coll := range node.Content {
+			l.addCollectorScripts(c.configFile, coll)
+		}
+	}
+	if node := mapValue(root, "profiles"); node != nil {
+		l.addProfilesScripts(c.configFile, node)
+	}
+
+	files, err := resolveGlobs(c.configFile, c.CollectorFiles)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if node, err := readYAMLFile(file); err == nil {
+			l.addCollectorScripts(file, node)
+		}
+	}
+	files, err = resolveGlobs(c.configFile, c.ProfileFiles)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if node, err := readYAMLFile(file); err == nil {
+			l.addProfilesScripts(file, node)
+		}
+	}
+	return nil
+}
+
+// resolveGlobs returns the files matching globs; relative globs are resolved from the config file directory.
+func resolveGlobs(configFile string, globs []string) ([]string, error) {
+	var files []string
+	baseDir := filepath.Dir(configFile)
+	for _, glob := range globs {
+		if len(glob) > 0 && !filepath.IsAbs(glob) {
+			glob = filepath.Join(baseDir, glob)
+		}
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing files for %s: %s", glob, err)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// readYAMLFile returns the root node of a config file.
+func readYAMLFile(file string) (*yaml.Node, error) {
+	node, err := readConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("%s is empty", file)
+	}
+	return node, nil
+}
+
+func (l *linter) addCollectorScripts(file string, node *yaml.Node) {
+	name := "collector"
+	if n := mapValue(node, "collector_name"); n != nil {
+		name = "collector " + n.Value
+	}
+	if scripts := mapValue(node, "scripts"); scripts != nil && scripts.Kind == yaml.MappingNode {
+		for idx := 0; idx+1 < len(scripts.Content); idx += 2 {
+			l.addScript(file, name, scripts.Content[idx].Value, false, scripts.Content[idx+1])
+		}
+	}
+}
+
+func (l *linter) addProfilesScripts(file string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		owner := "profile " + node.Content[idx].Value
+		scripts := mapValue(node.Content[idx+1], "scripts")
+		if scripts == nil || scripts.Kind != yaml.MappingNode {
+			continue
+		}
+		for jdx := 0; jdx+1 < len(scripts.Content); jdx += 2 {
+			name, script := scripts.Content[jdx].Value, scripts.Content[jdx+1]
+			if script.Kind == yaml.MappingNode {
+				// prepend and append actions of a profile that extends another one
+				for _, key := range []string{scriptPrepend, scriptAppend} {
+					if actions := mapValue(script, key); actions != nil {
+						l.addScript(file, owner, name, true, actions)
+					}
+				}
+				continue
+			}
+			l.addScript(file, owner, name, true, script)
+		}
+	}
+}
+
+// addScript parses the actions of the script defined by node; scripts that can't be parsed have been reported
+// when the config was loaded.
+func (l *linter) addScript(file, owner, name string, profile bool, node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		return
+	}
+	script := &YAMLScript{registry: l.registry}
+	if err := node.Decode(script); err != nil {
+		return
+	}
+	l.scripts = append(l.scripts, &lintScript{
+		file:    file,
+		owner:   owner,
+		name:    name,
+		profile: profile,
+		node:    node,
+		script:  script,
+	})
+}
+
+// warn adds a warning at the location of node; node is located in the file of the config tree it is read from, else in
+// file.
+func (l *linter) warn(file string, node *yaml.Node, check, format string, args ...any) {
+	if f, ok := l.nodeFiles[node]; ok {
+		file = f
+	}
+	l.diags = append(l.diags, lintDiag{
+		file:   file,
+		line:   node.Line,
+		column: node.Column,
+		check:  check,
+		msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// addRefs adds the references of the fields found in value: a field, or lists and maps of fields.
+func (l *linter) addRefs(ls *lintScript, node *yaml.Node, value any, scoped bool) {
+	switch v := value.(type) {
+	case *Field:
+		for _, name := range v.Identifiers() {
+			l.refs = append(l.refs, lintRef{name: name, file: ls.file, node: node, scoped: scoped})
+		}
+	case []*Field:
+		for _, f := range v {
+			l.addRefs(ls, node, f, scoped)
+		}
+	case []any:
+		for _, elmt := range v {
+			l.addRefs(ls, node, elmt, scoped)
+		}
+	case [][]any:
+		for _, elmt := range v {
+			l.addRefs(ls, node, []any(elmt), scoped)
+		}
+	case map[any]any:
+		for key, elmt := range v {
+			l.addRefs(ls, node, key, scoped)
+			l.addRefs(ls, node, elmt, scoped)
+		}
+	}
+}
+
+// define adds the symbols set by a list of key/value fields: set_fact, set_stats or vars; keys node is the map
+// that defines them.
+func (l *linter) define(ls *lintScript, keys *yaml.Node, pairs [][]any, fact bool) {
+	for _, pair := range pairs {
+		key, ok := pair[0].(*Field)
+		if !ok || key.vartype != field_raw {
+			continue
+		}
+		node, _ := mapEntry(keys, key.raw)
+		if node == nil {
+			node = keys
+		}
+		l.defined[key.raw] = true
+		if fact {
+			if _, found := l.facts[key.raw]; !found {
+				l.facts[key.raw] = lintRef{name: key.raw, file: ls.file, node: node}
+			}
+			if !snakeCaseRegexp.MatchString(key.raw) {
+				l.warn(ls.file, node, lintSnakeCase, "variable name '%s' is not snake_case", key.raw)
+			}
+		}
+	}
+}
+
+// checkActions walks the actions of a script with their yaml nodes; loopVars are the loop variables of the
+// enclosing actions.
+func (l *linter) checkActions(ls *lintScript, actions ActionsList, nodes []*yaml.Node, loopVars []string, scoped bool) {
+	for idx, act := range actions {
+		if idx >= len(nodes) {
+			break
+		}
+		node := nodes[idx]
+
+		l.addRefs(ls, node, act.GetNameField(), scoped)
+		l.addRefs(ls, node, act.GetWhen(), scoped)
+		l.addRefs(ls, node, act.GetUntil(), scoped)
+		l.addRefs(ls, node, act.GetWith(), scoped)
+		vars := act.GetVars()
+		l.define(ls, mapValue(node, "vars"), vars, false)
+		for _, pair := range vars {
+			l.addRefs(ls, node, pair[1], scoped)
+		}
+
+		inner := loopVars
+		if len(act.GetWith()) > 0 {
+			loop_var := act.GetLoopVar()
+			loop_node, _ := mapEntry(node, "loop_var")
+			if loop_var == "" {
+				loop_var = "item"
+				if loop_node, _ = mapEntry(node, "with_items"); loop_node == nil {
+					loop_node, _ = mapEntry(node, "loop")
+				}
+			} else if !snakeCaseRegexp.MatchString(loop_var) {
+				l.warn(ls.file, loop_node, lintSnakeCase, "loop_var '%s' is not snake_case", loop_var)
+			}
+			if loop_node == nil {
+				loop_node = node
+			}
+			if slices.Contains(loopVars, loop_var) {
+				l.warn(ls.file, loop_node, lintLoopVar, "loop variable '%s' shadows the one of an enclosing loop: set loop_var", loop_var)
+			}
+			l.defined[loop_var] = true
+			inner = append(slices.Clone(loopVars), loop_var)
+		}
+
+		switch a := act.(type) {
+		case *SetFactAction:
+			l.define(ls, mapValue(node, "set_fact"), a.setFact, true)
+			for _, pair := range a.setFact {
+				l.addRefs(ls, node, pair[1], scoped)
+			}
+		case *SetStatsAction:
+			l.define(ls, mapValue(node, "set_stats"), a.setStats, false)
+			for _, pair := range a.setStats {
+				l.addRefs(ls, node, pair[1], scoped)
+			}
+		case *DebugAction:
+			l.addRefs(ls, node, a.Debug.msg, scoped)
+		case *QueryAction:
+			q := a.Query
+			for _, f := range []*Field{q.query, q.method, q.data, q.status_label, q.auth_mode, q.user, q.passwd, q.token} {
+				l.addRefs(ls, node, f, scoped)
+			}
+			if q.var_name != nil && q.var_name.vartype == field_raw {
+				l.defined[q.VarName] = true
+				if !snakeCaseRegexp.MatchString(q.VarName) {
+					var_node, _ := mapEntry(mapValue(node, "query"), "var_name")
+					if var_node == nil {
+						var_node = node
+					}
+					l.warn(ls.file, var_node, lintSnakeCase, "var_name '%s' is not snake_case", q.VarName)
+				}
+			} else {
+				l.addRefs(ls, node, q.var_name, scoped)
+			}
+		case *PlayScriptAction:
+			l.played[a.PlayScriptActionName] = true
+		case *ActionsAction:
+			if sub := mapValue(node, "actions"); sub != nil {
+				l.checkActions(ls, a.Actions, sub.Content, inner, scoped)
+			}
+		case *MetricsAction:
+			if sub := mapValue(node, "metrics"); sub != nil {
+				l.checkActions(ls, a.Actions, sub.Content, inner, scoped || (a.Scope != "" && a.Scope != "none"))
+			}
+		case *MetricAction:
+			l.checkMetric(ls, node, a.mc, scoped)
+		}
+	}
+}
+
+// checkMetric checks the definition of a metric of a metrics action.
+func (l *linter) checkMetric(ls *lintScript, node *yaml.Node, mc *MetricConfig, scoped bool) {
+	if mc == nil {
+		return
+	}
+	name_node, _ := mapEntry(node, "metric_name")
+	if name_node == nil {
+		name_node = node
+	}
+	if strings.TrimSpace(mc.Help) == "" {
+		l.warn(ls.file, name_node, lintMetricHelp, "metric '%s' has no help", mc.Name)
+	}
+	if mc.name != nil && mc.name.vartype == field_raw && !snakeCaseRegexp.MatchString(mc.Name) {
+		l.warn(ls.file, name_node, lintSnakeCase, "metric name '%s' is not snake_case", mc.Name)
+	}
+	for _, f := range []*Field{mc.name, mc.help, mc.key_labels, mc.metric_type, mc.created, mc.exemplar} {
+		l.addRefs(ls, node, f, scoped)
+	}
+	// labels and values are parsed when the metric is built by the collector
+	for _, values := range []map[string]string{mc.key_labels_map, mc.Values} {
+		for key, value := range values {
+			l.addRefs(ls, node, newLintField(key, l.registry), scoped)
+			l.addRefs(ls, node, newLintField(value, l.registry), scoped)
+		}
+	}
+}
+
+// newLintField returns the field parsed from value; nil if value is invalid: errors are reported by collectors.
+func newLintField(value string, registry *goja_modules.JSRegistry) *Field {
+	f, err := NewField(value, nil, registry)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// checkSymbols reports references to symbols never defined, and facts never referenced.
+func (l *linter) checkSymbols() {
+	used := make(map[string]bool, len(l.refs))
+	reported := make(map[string]bool)
+	for _, ref := range l.refs {
+		used[ref.name] = true
+		// variables of scoped metrics are relative to the scope
+		if ref.scoped || l.defined[ref.name] || strings.HasPrefix(ref.name, "__") {
+			continue
+		}
+		// once per location
+		key := fmt.Sprintf("%s:%d:%s", ref.file, ref.node.Line, ref.name)
+		if reported[key] {
+			continue
+		}
+		reported[key] = true
+		l.warn(ref.file, ref.node, lintUndefinedVar, "variable '%s' is never set", ref.name)
+	}
+	for name, fact := range l.facts {
+		if used[name] || slices.Contains(builtinSymbols, name) {
+			continue
+		}
+		l.warn(fact.file, fact.node, lintUnusedFact, "variable '%s' is set but never used", name)
+	}
+}
+
+// checkScripts reports scripts of profiles that are never played.
+func (l *linter) checkScripts() {
+	for _, ls := range l.scripts {
+		if !ls.profile || slices.Contains(profileScripts, ls.name) || l.played[ls.name] {
+			continue
+		}
+		l.warn(ls.file, ls.node, lintUnreachable, "script '%s' of %s is never played: not a script of profiles (%s) nor played by a play_script action",
+			ls.name, ls.owner, strings.Join(profileScripts, ", "))
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/peekjef72/httpapi_exporter/goja_modules"
+	"github.com/peekjef72/httpapi_exporter/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintConfig(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	registry, _ := goja_modules.InitJSRegistry(logger, template.Js_func_map())
+	dir := t.TempDir()
+	copyDir(t, "contribs/apache/etc/apache", dir)
+	config_file := filepath.Join(dir, "config.yml")
+	environment := ""
+
+	lint := func() []lintDiag {
+		c, err := LoadConfig(config_file, environment, logger, "", registry)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		diags, err := LintConfig(c)
+		assert.NoError(t, err)
+		return diags
+	}
+
+	// contrib config is clean
+	assert.Empty(t, lint())
+
+	coll_file := filepath.Join(dir, "metrics", "apache_lint.collector.yml")
+	content := `collector_name: apache_lint
+metric_prefix: apache_lint
+scripts:
+  get_lint:
+    - name: facts
+      set_fact:
+        unusedFact: 1
+        threshold: 10
+    - name: query
+      query:
+        url: /status/{{ .unknown_path }}
+        var_name: results
+    - name: loop
+      with_items: "{{ .results.items }}"
+      actions:
+        - name: inner loop
+          loop: "js: item.values.filter((v) => v > threshold)"
+          set_fact:
+            last_value: "{{ .item }}"
+        - name: metrics
+          metrics:
+            - metric_name: lastValue
+              type: gauge
+              values:
+                _: $last_value
+          scope: none
+`
+	if err := os.WriteFile(coll_file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	prof_file := filepath.Join(dir, "profiles", "apache_profile.yml")
+	prof_orig, _ := os.ReadFile(prof_file)
+	prof := append([]byte{}, prof_orig...)
+	prof = append(prof, []byte("      orphan:\n        - name: nothing\n          set_stats:\n            orphan: true\n")...)
+	if err := os.WriteFile(prof_file, prof, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range lint() {
+		// locations of yaml nodes
+		switch d.check {
+		case lintLoopVar:
+			assert.Equal(t, []any{coll_file, 17, 11}, []any{d.file, d.line, d.column})
+		case lintUnusedFact:
+			assert.Equal(t, []any{coll_file, 7, 9}, []any{d.file, d.line, d.column})
+		}
+		got = append(got, filepath.Base(d.file)+":"+d.check+":"+d.msg)
+	}
+	assert.ElementsMatch(t, []string{
+		"apache_lint.collector.yml:" + lintSnakeCase + ":variable name 'unusedFact' is not snake_case",
+		"apache_lint.collector.yml:" + lintUnusedFact + ":variable 'unusedFact' is set but never used",
+		"apache_lint.collector.yml:" + lintUndefinedVar + ":variable 'unknown_path' is never set",
+		"apache_lint.collector.yml:" + lintLoopVar + ":loop variable 'item' shadows the one of an enclosing loop: set loop_var",
+		"apache_lint.collector.yml:" + lintMetricHelp + ":metric 'lastValue' has no help",
+		"apache_lint.collector.yml:" + lintSnakeCase + ":metric name 'lastValue' is not snake_case",
+		"apache_profile.yml:" + lintUnreachable + ":script 'orphan' of profile apache is never played: not a script of profiles (init, login, logout, clear, ping) nor played by a play_script action",
+	}, got)
+
+	// collectors and profiles of included files and of the overlay are reported in the files they are defined in
+	os.Remove(coll_file)
+	os.WriteFile(prof_file, prof_orig, 0o600)
+	frag_file := filepath.Join(dir, "conf.d", "collectors.yml")
+	os.Mkdir(filepath.Dir(frag_file), 0o700)
+	content = `collectors:
+  - collector_name: apache_inline
+    scripts:
+      get_inline:
+        - name: facts
+          set_fact:
+            unused_inline: 1
+`
+	if err := os.WriteFile(frag_file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	overlay_file := filepath.Join(dir, "config.prod.yml")
+	content = `profiles:
+  inline:
+    scripts:
+      ping:
+        - name: ping
+          query:
+            url: /
+      orphan_inline:
+        - name: nothing
+          set_stats:
+            orphan: true
+`
+	if err := os.WriteFile(overlay_file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := os.ReadFile(config_file)
+	if err := os.WriteFile(config_file, append([]byte("include: conf.d/*.yml\n"), config...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	environment = "prod"
+	got = nil
+	for _, d := range lint() {
+		if d.check == lintUnusedFact {
+			assert.Equal(t, []any{frag_file, 7, 13}, []any{d.file, d.line, d.column})
+		}
+		got = append(got, filepath.Base(d.file)+":"+d.check+":"+d.msg)
+	}
+	assert.ElementsMatch(t, []string{
+		"collectors.yml:" + lintUnusedFact + ":variable 'unused_inline' is set but never used",
+		"config.prod.yml:" + lintUnreachable + ":script 'orphan_inline' of profile inline is never played: not a script of profiles (init, login, logout, clear, ping) nor played by a play_script action",
+	}, got)
+}
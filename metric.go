@@ -6,13 +6,19 @@ import (
 	"fmt"
 	"log/slog"
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/peekjef72/httpapi_exporter/goja_modules"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/spf13/cast"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // MetricDesc is a descriptor for a family of metrics, sharing the same name, help, labels, type.
@@ -56,7 +62,7 @@ func NewMetricFamily(
 
 	logContext = append(logContext, "metric", mc.Name)
 
-	if mc.valueType != dto.MetricType_HISTOGRAM && len(mc.Values) == 0 {
+	if mc.valueType != dto.MetricType_HISTOGRAM && mc.Info == nil && len(mc.Values) == 0 {
 		logContext = append(logContext, "errmsg", "NewMetricFamily(): no value defined")
 		return nil, fmt.Errorf("%s", logContext...)
 	}
@@ -101,6 +107,14 @@ func NewMetricFamily(
 		i++
 	}
 
+	// labels and values are evaluated with the metric definition as position
+	for _, label := range slices.Concat(labels, valuesLabels) {
+		if label != nil {
+			label.Key.SetPos(mc.pos)
+			label.Value.SetPos(mc.pos)
+		}
+	}
+
 	// Create a copy of original slice to avoid modifying constLabels
 	sortedLabels := append(constLabels[:0:0], constLabels...)
 
@@ -114,6 +128,7 @@ func NewMetricFamily(
 
 	return &MetricFamily{
 		config:       mc,
+		value_type:   metric_type_UNKNOWN,
 		constLabels:  sortedLabels,
 		labels:       labels,
 		valuesLabels: valuesLabels,
@@ -208,6 +223,14 @@ func (mf MetricFamily) Collect(rawdatas any, logger *slog.Logger, ch chan<- Metr
 			if mf.config.prefix != "" {
 				name = fmt.Sprintf("%s_%s", mf.config.prefix, name)
 			}
+			// info metrics are always suffixed by _info
+			if mf.config.Info != nil && !strings.HasSuffix(name, "_info") {
+				name += "_info"
+			}
+			// OpenMetrics requires the unit as suffix of the name (before _total for counters)
+			if mf.config.Unit != "" {
+				name = addUnitSuffix(name, mf.config.Unit)
+			}
 			mf.name = name
 		}
 	}
@@ -336,7 +359,12 @@ func (mf MetricFamily) Collect(rawdatas any, logger *slog.Logger, ch chan<- Metr
 		i++
 	}
 
-	if mf.config.valueType == dto.MetricType_HISTOGRAM {
+	// OpenMetrics created timestamp and exemplar: they are optional so metric is sent even if they are invalid
+	created, exemplar := mf.openMetricsInfos(symtab, root_symtab, logger)
+
+	if mf.config.Info != nil {
+		mf.collectInfo(symtab, root_symtab, labelNames, labelValues, logger, ch)
+	} else if mf.config.valueType == dto.MetricType_HISTOGRAM {
 		switch mf.config.histogram.Type {
 		case HistogramTypeExternal:
 			met, _ := NewHistogramMetric(&mf, labelNames, labelValues, nil)
@@ -348,6 +376,7 @@ func (mf MetricFamily) Collect(rawdatas any, logger *slog.Logger, ch chan<- Metr
 				if err := met.SetValue(h_var); err != nil {
 					ch <- NewInvalidMetric(mf.logContext, err)
 				}
+				met.(*histMetric).created = created
 				ch <- met
 			}
 		case HistogramTypeStatic:
@@ -369,6 +398,7 @@ func (mf MetricFamily) Collect(rawdatas any, logger *slog.Logger, ch chan<- Metr
 				if err := met.SetValue(f_value); err != nil {
 					ch <- NewInvalidMetric(mf.logContext, err)
 				}
+				met.(*histMetric).created = created
 				ch <- met
 			}
 		}
@@ -418,7 +448,7 @@ func (mf MetricFamily) Collect(rawdatas any, logger *slog.Logger, ch chan<- Metr
 				"coll", CollectorId(root_symtab, logger),
 				"script", ScriptName(root_symtab, logger),
 			)
-			ch <- NewMetric(&mf, f_value, labelNames, labelValues)
+			ch <- NewMetricWithExemplar(&mf, f_value, labelNames, labelValues, exemplar, created)
 		}
 	}
 	if set_root {
@@ -431,6 +461,264 @@ func (mf MetricFamily) Collect(rawdatas any, logger *slog.Logger, ch chan<- Metr
 	}
 }
 
+// openMetricsInfos evaluates the created timestamp and the exemplar of the metric if they are defined.
+func (mf *MetricFamily) openMetricsInfos(
+	symtab map[string]any,
+	root_symtab map[string]any,
+	logger *slog.Logger) (created *timestamppb.Timestamp, exemplar *dto.Exemplar) {
+
+	var err error
+	if mf.config.created != nil {
+		var raw_created any
+		if raw_created, err = ValorizeValue(symtab, mf.config.created, logger, mf.name, false); err == nil {
+			created, err = NewTimestamp(raw_created)
+		}
+		if err != nil {
+			logger.Warn(fmt.Sprintf("metric %s: invalid created timestamp: %s", mf.name, err),
+				"coll", CollectorId(root_symtab, logger),
+				"script", ScriptName(root_symtab, logger),
+			)
+		}
+	}
+	if mf.config.exemplar != nil {
+		var raw_exemplar any
+		if raw_exemplar, err = ValorizeValue(symtab, mf.config.exemplar, logger, mf.name, false); err == nil && raw_exemplar != nil {
+			exemplar, err = NewExemplar(raw_exemplar)
+		}
+		if err != nil {
+			logger.Debug(fmt.Sprintf("metric %s: invalid exemplar: %s", mf.name, err),
+				"coll", CollectorId(root_symtab, logger),
+				"script", ScriptName(root_symtab, logger),
+			)
+		}
+	}
+	return
+}
+
+// addUnitSuffix adds the unit to the name if not already present: name_unit or name_unit_total for counter.
+func addUnitSuffix(name string, unit string) string {
+	suffix := "_" + unit
+	if strings.HasSuffix(name, suffix) || strings.HasSuffix(name, suffix+"_total") {
+		return name
+	}
+	if base, found := strings.CutSuffix(name, "_total"); found {
+		return base + suffix + "_total"
+	}
+	return name + suffix
+}
+
+// NewTimestamp converts epoch seconds (number or numeric string) or a RFC3339 date to a protobuf timestamp.
+func NewTimestamp(raw_ts any) (*timestamppb.Timestamp, error) {
+	var epoch float64
+	switch ts := raw_ts.(type) {
+	case nil:
+		return nil, fmt.Errorf("timestamp not set")
+	case time.Time:
+		return timestamppb.New(ts), nil
+	case string:
+		if ts == "" {
+			return nil, fmt.Errorf("timestamp not set")
+		}
+		if f_ts, err := strconv.ParseFloat(ts, 64); err == nil {
+			epoch = f_ts
+		} else if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			return timestamppb.New(t), nil
+		} else {
+			return nil, fmt.Errorf("invalid timestamp %q: must be epoch seconds or RFC3339 date", ts)
+		}
+	default:
+		f_ts, err := cast.ToFloat64E(raw_ts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %v: %s", raw_ts, err)
+		}
+		epoch = f_ts
+	}
+	sec := int64(epoch)
+	return timestamppb.New(time.Unix(sec, int64((epoch-float64(sec))*1e9))), nil
+}
+
+// NewExemplar builds an exemplar from a map with labels (map), value and optional timestamp (epoch seconds),
+// like the ones built by the prometheus parser.
+func NewExemplar(raw_exemplar any) (*dto.Exemplar, error) {
+	t_exemplar := reflect.ValueOf(raw_exemplar)
+	if t_exemplar.Kind() != reflect.Map {
+		return nil, fmt.Errorf("exemplar must be a map, got %s", reflect.TypeOf(raw_exemplar))
+	}
+	exemplar := &dto.Exemplar{}
+	iter := t_exemplar.MapRange()
+	for iter.Next() {
+		raw_value := iter.Value().Interface()
+		switch RawGetValueString(iter.Key().Interface()) {
+		case "labels":
+			t_labels := reflect.ValueOf(raw_value)
+			if t_labels.Kind() != reflect.Map {
+				return nil, fmt.Errorf("exemplar labels must be a map")
+			}
+			l_iter := t_labels.MapRange()
+			for l_iter.Next() {
+				exemplar.Label = append(exemplar.Label, &dto.LabelPair{
+					Name:  proto.String(RawGetValueString(l_iter.Key().Interface())),
+					Value: proto.String(RawGetValueString(l_iter.Value().Interface())),
+				})
+			}
+		case "value":
+			value, err := cast.ToFloat64E(raw_value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exemplar value: %s", err)
+			}
+			exemplar.Value = proto.Float64(value)
+		case "timestamp":
+			ts, err := NewTimestamp(raw_value)
+			if err != nil {
+				return nil, err
+			}
+			exemplar.Timestamp = ts
+		}
+	}
+	if exemplar.Value == nil {
+		return nil, fmt.Errorf("exemplar value not set")
+	}
+	sort.Sort(labelPairSorter(exemplar.Label))
+	return exemplar, nil
+}
+
+// collectInfo sends an info metric (value 1) labeled with the scalar attributes of the info var.
+//
+// key labels have precedence over attributes with the same name.
+func (mf *MetricFamily) collectInfo(
+	symtab map[string]any,
+	root_symtab map[string]any,
+	labelNames []string,
+	labelValues []string,
+	logger *slog.Logger,
+	ch chan<- Metric) {
+
+	info := mf.config.Info
+	attrs_raw, err := ValorizeValue(symtab, info.info_var, logger, mf.name, false)
+	if err != nil {
+		ch <- NewInvalidMetric(mf.logContext, err)
+		return
+	}
+	t_attrs := reflect.ValueOf(attrs_raw)
+	if t_attrs.Kind() != reflect.Map {
+		err := fmt.Errorf("invalid type for info var of metric %s: need a map, got %s", mf.name, reflect.TypeOf(attrs_raw))
+		logger.Warn(err.Error(),
+			"coll", CollectorId(root_symtab, logger),
+			"script", ScriptName(root_symtab, logger),
+		)
+		ch <- NewInvalidMetric(mf.logContext, err)
+		return
+	}
+
+	// names already used by key labels and const labels
+	used := make(map[string]bool, len(labelNames)+len(mf.constLabels))
+	for _, name := range labelNames {
+		used[name] = true
+	}
+	for _, label := range mf.constLabels {
+		used[label.GetName()] = true
+	}
+
+	// sort attributes names so that labels are always built in the same order
+	attrs := make(map[string]any, t_attrs.Len())
+	keys := make([]string, 0, t_attrs.Len())
+	iter := t_attrs.MapRange()
+	for iter.Next() {
+		key := RawGetValueString(iter.Key().Interface())
+		attrs[key] = iter.Value().Interface()
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	names := append(labelNames[:0:0], labelNames...)
+	values := append(labelValues[:0:0], labelValues...)
+	for _, key := range keys {
+		if info.include != nil && !info.include.match(key) {
+			continue
+		}
+		if info.exclude.match(key) {
+			continue
+		}
+		value, ok := infoLabelValue(attrs[key])
+		if !ok {
+			continue
+		}
+		name := sanitizeLabelName(key)
+		if name == "" {
+			continue
+		}
+		if name == "job" || name == "instance" {
+			name = "exported_" + name
+		}
+		if used[name] {
+			logger.Debug(fmt.Sprintf("metric %s: info attribute %s skipped: label %s already defined", mf.name, key, name),
+				"coll", CollectorId(root_symtab, logger),
+				"script", ScriptName(root_symtab, logger),
+			)
+			continue
+		}
+		used[name] = true
+		names = append(names, name)
+		values = append(values, truncateLabelValue(value, info.MaxValueLength))
+	}
+	ch <- NewMetric(mf, 1, names, values)
+}
+
+// infoLabelValue returns the string value of a scalar attribute; maps, slices and nil values are not scalar.
+func infoLabelValue(raw_value any) (string, bool) {
+	if raw_value == nil {
+		return "", false
+	}
+	switch reflect.ValueOf(raw_value).Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return RawGetValueString(raw_value), true
+	}
+	return "", false
+}
+
+// sanitizeLabelName converts an attribute name to a valid snake_case prometheus label name:
+// "serialNumber" => "serial_number", "fw-version" => "fw_version", "2ndIP" => "_2nd_ip"
+func sanitizeLabelName(name string) string {
+	var b strings.Builder
+	prev_lower := false
+	for _, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			if prev_lower {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r + 'a' - 'A')
+			prev_lower = false
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			prev_lower = true
+		default:
+			b.WriteByte('_')
+			prev_lower = false
+		}
+	}
+	// collapse repeated underscores and remove leading/trailing ones
+	res := strings.Trim(b.String(), "_")
+	for strings.Contains(res, "__") {
+		res = strings.ReplaceAll(res, "__", "_")
+	}
+	if res != "" && res[0] >= '0' && res[0] <= '9' {
+		res = "_" + res
+	}
+	return res
+}
+
+// truncateLabelValue cuts value to max_len characters (not bytes) if it is longer.
+func truncateLabelValue(value string, max_len int) string {
+	if max_len <= 0 || utf8.RuneCountInString(value) <= max_len {
+		return value
+	}
+	return string([]rune(value)[:max_len])
+}
+
 // Name implements MetricDesc.
 func (mf MetricFamily) Name() string {
 	name := mf.name
@@ -544,6 +832,19 @@ type Metric interface {
 //
 // NewMetric panics if the length of labelValues is not consistent with desc.labels().
 func NewMetric(desc MetricDesc, value float64, labelNames []string, labelValues []string) Metric {
+	return NewMetricWithExemplar(desc, value, labelNames, labelValues, nil, nil)
+}
+
+// NewMetricWithExemplar is like NewMetric with the OpenMetrics exemplar and created timestamp;
+// they are only exposed for counters.
+func NewMetricWithExemplar(
+	desc MetricDesc,
+	value float64,
+	labelNames []string,
+	labelValues []string,
+	exemplar *dto.Exemplar,
+	created *timestamppb.Timestamp) Metric {
+
 	if len(labelNames) != len(labelValues) {
 		panic(fmt.Sprintf("[%s] expected %d labels, got %d", desc.LogContext(), len(labelNames), len(labelValues)))
 	}
@@ -551,6 +852,8 @@ func NewMetric(desc MetricDesc, value float64, labelNames []string, labelValues
 		desc:       desc,
 		val:        value,
 		labelPairs: makeLabelPairs(desc, labelNames, labelValues),
+		exemplar:   exemplar,
+		created:    created,
 	}
 }
 
@@ -559,6 +862,8 @@ type constMetric struct {
 	desc       MetricDesc
 	val        float64
 	labelPairs []*dto.LabelPair
+	exemplar   *dto.Exemplar
+	created    *timestamppb.Timestamp
 }
 
 // Desc implements Metric.
@@ -571,7 +876,11 @@ func (m *constMetric) Write(out *dto.Metric) error {
 	out.Label = m.labelPairs
 	switch t := m.desc.ValueType(); t {
 	case dto.MetricType_COUNTER:
-		out.Counter = &dto.Counter{Value: proto.Float64(m.val)}
+		out.Counter = &dto.Counter{
+			Value:            proto.Float64(m.val),
+			Exemplar:         m.exemplar,
+			CreatedTimestamp: m.created,
+		}
 	case dto.MetricType_GAUGE:
 		out.Gauge = &dto.Gauge{Value: proto.Float64(m.val)}
 	default:
@@ -680,6 +989,7 @@ type histMetric struct {
 	desc      MetricDesc
 	metric    dto.Metric
 	histogram *prometheus.HistogramVec
+	created   *timestamppb.Timestamp
 }
 
 // Desc implements Metric.
@@ -691,6 +1001,9 @@ func (m *histMetric) Desc() MetricDesc {
 func (m *histMetric) Write(out *dto.Metric) error {
 	out.Label = m.metric.GetLabel()
 	out.Histogram = m.metric.GetHistogram()
+	if out.Histogram != nil && m.created != nil {
+		out.Histogram.CreatedTimestamp = m.created
+	}
 	return nil
 }
 
@@ -707,12 +1020,25 @@ func NewHistogramMetric(
 	config := desc.Config()
 	if config.histogram.Type == HistogramTypeStatic && histogram == nil {
 		// check if the metric config has already the histogram definition
-		histogram = prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:    "set_later",
-				Help:    "set_later",
-				Buckets: *config.histogram.Buckets,
-			}, labelNames)
+		opts := prometheus.HistogramOpts{
+			Name: "set_later",
+			Help: "set_later",
+		}
+		if config.histogram.Buckets != nil {
+			opts.Buckets = *config.histogram.Buckets
+		}
+		if native := config.histogram.Native; native != nil {
+			opts.NativeHistogramBucketFactor = native.BucketFactor()
+			opts.NativeHistogramMaxBucketNumber = native.MaxBuckets
+			if native.ZeroThreshold != nil {
+				if *native.ZeroThreshold == 0 {
+					opts.NativeHistogramZeroThreshold = prometheus.NativeHistogramZeroThresholdZero
+				} else {
+					opts.NativeHistogramZeroThreshold = *native.ZeroThreshold
+				}
+			}
+		}
+		histogram = prometheus.NewHistogramVec(opts, labelNames)
 	}
 	return &histMetric{
 		desc: desc,
@@ -752,8 +1078,9 @@ func (m *histMetric) SetValue(hist_var_raw any) error {
 					if t_raw_bucket.Kind() == reflect.Map {
 						iter := t_raw_bucket.MapRange()
 						var (
-							count uint64
-							bound float64
+							count    uint64
+							bound    float64
+							exemplar *dto.Exemplar
 						)
 						for iter.Next() {
 							raw_key := iter.Key()
@@ -764,18 +1091,27 @@ func (m *histMetric) SetValue(hist_var_raw any) error {
 									bound = cast.ToFloat64(iter.Value().Interface())
 								case "value":
 									count = cast.ToUint64(iter.Value().Interface())
+								case "exemplar":
+									// exemplar is optional: ignore it if invalid
+									exemplar, _ = NewExemplar(iter.Value().Interface())
 								}
 							}
 						}
 						new_bucket := &dto.Bucket{
 							CumulativeCount: &count,
 							UpperBound:      &bound,
+							Exemplar:        exemplar,
 						}
 						hist.Bucket = append(hist.Bucket, new_bucket)
 					}
 				}
 			}
 		}
+		if _, ok := h_var["schema"]; ok {
+			if err := setNativeHistogram(hist, h_var); err != nil {
+				return err
+			}
+		}
 	case HistogramTypeStatic:
 		if f_value, ok := hist_var_raw.(float64); ok {
 			labels := m.metric.GetLabel()
@@ -797,3 +1133,69 @@ func (m *histMetric) SetValue(hist_var_raw any) error {
 	}
 	return nil
 }
+
+// setNativeHistogram sets the native (sparse) buckets of an external histogram, as built by the prometheus parser
+// from a protobuf exposition.
+func setNativeHistogram(hist *dto.Histogram, h_var map[string]any) error {
+	schema, err := cast.ToInt32E(h_var["schema"])
+	if err != nil {
+		return fmt.Errorf("invalid native histogram schema: %s", err)
+	}
+	hist.Schema = proto.Int32(schema)
+	if raw_threshold, ok := h_var["zero_threshold"]; ok {
+		hist.ZeroThreshold = proto.Float64(cast.ToFloat64(raw_threshold))
+	}
+	if raw_count, ok := h_var["zero_count"]; ok {
+		hist.ZeroCount = proto.Uint64(cast.ToUint64(raw_count))
+	}
+	if raw_count, ok := h_var["zero_count_float"]; ok {
+		hist.ZeroCountFloat = proto.Float64(cast.ToFloat64(raw_count))
+	}
+	if hist.PositiveSpan, err = nativeHistogramSpans(h_var["positive_spans"]); err != nil {
+		return err
+	}
+	if hist.NegativeSpan, err = nativeHistogramSpans(h_var["negative_spans"]); err != nil {
+		return err
+	}
+	if raw_deltas, ok := h_var["positive_deltas"]; ok {
+		hist.PositiveDelta = cast.ToInt64Slice(raw_deltas)
+	}
+	if raw_deltas, ok := h_var["negative_deltas"]; ok {
+		hist.NegativeDelta = cast.ToInt64Slice(raw_deltas)
+	}
+	if raw_counts, ok := h_var["positive_counts"]; ok {
+		hist.PositiveCount = cast.ToFloat64Slice(raw_counts)
+	}
+	if raw_counts, ok := h_var["negative_counts"]; ok {
+		hist.NegativeCount = cast.ToFloat64Slice(raw_counts)
+	}
+	return nil
+}
+
+// nativeHistogramSpans converts a list of {offset, length} maps to native histogram spans.
+func nativeHistogramSpans(raw_spans any) ([]*dto.BucketSpan, error) {
+	if raw_spans == nil {
+		return nil, nil
+	}
+	t_spans := reflect.ValueOf(raw_spans)
+	if t_spans.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("invalid native histogram spans: must be a list")
+	}
+	spans := make([]*dto.BucketSpan, 0, t_spans.Len())
+	for ind := range t_spans.Len() {
+		raw_span := t_spans.Index(ind).Interface()
+		offset, err := cast.ToInt32E(getMapKey(raw_span, "offset"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid native histogram span offset: %s", err)
+		}
+		length, err := cast.ToUint32E(getMapKey(raw_span, "length"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid native histogram span length: %s", err)
+		}
+		spans = append(spans, &dto.BucketSpan{
+			Offset: proto.Int32(offset),
+			Length: proto.Uint32(length),
+		})
+	}
+	return spans, nil
+}
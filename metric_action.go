@@ -6,7 +6,9 @@ import (
 	//"bytes"
 	"fmt"
 	"log/slog"
+	"math"
 	"reflect"
+	"regexp"
 	"strings"
 
 	"github.com/peekjef72/httpapi_exporter/goja_modules"
@@ -21,14 +23,170 @@ const (
 	HistogramTypeExternal
 
 	metric_type_UNKNOWN = 255
+
+	// default max length of a label value built by an info metric
+	info_max_value_length_default = 256
 )
 
+// valid OpenMetrics unit
+var unitRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 type EHistogram struct {
 	Type            int
 	Histogram_var   *Field
 	Histogram       []*prometheus.HistogramVec
 	Buckets         *[]float64
 	Histogram_value *Field
+	Native          *NativeHistogram
+}
+
+// NativeHistogram defines the native (sparse) buckets of a static histogram.
+type NativeHistogram struct {
+	Schema        int32    // resolution of the buckets: from -4 (factor 16) to 8 (factor 1.0027)
+	ZeroThreshold *float64 // width of the zero bucket; prometheus default if not set
+	MaxBuckets    uint32   // max number of buckets; resolution is reduced when reached; 0 means no limit
+}
+
+const (
+	native_histogram_schema_min     = -4
+	native_histogram_schema_max     = 8
+	native_histogram_schema_default = 3
+)
+
+// BucketFactor returns the growth factor between buckets that corresponds to the schema: 2^(2^-schema).
+func (n *NativeHistogram) BucketFactor() float64 {
+	// increase the factor slightly so that prometheus client picks exactly the schema and not the next one
+	// because of rounding errors.
+	return math.Pow(2, math.Pow(2, -float64(n.Schema))*(1+1e-9))
+}
+
+// newNativeHistogram builds a native histogram definition from a map with keys schema, zero_threshold and max_buckets.
+func newNativeHistogram(raw_native any, name string) (*NativeHistogram, error) {
+	native := &NativeHistogram{
+		Schema: native_histogram_schema_default,
+	}
+	switch t_native := raw_native.(type) {
+	case nil:
+		// native: with no definition use defaults
+	case bool:
+		if !t_native {
+			return nil, nil
+		}
+	case map[string]any:
+		for key, value := range t_native {
+			switch key {
+			case "schema":
+				schema, err := cast.ToInt32E(value)
+				if err != nil || schema < native_histogram_schema_min || schema > native_histogram_schema_max {
+					return nil, fmt.Errorf("invalid schema for native histogram metric %q: must be an integer between %d and %d", name, native_histogram_schema_min, native_histogram_schema_max)
+				}
+				native.Schema = schema
+			case "zero_threshold":
+				threshold, err := cast.ToFloat64E(value)
+				if err != nil || threshold < 0 {
+					return nil, fmt.Errorf("invalid zero_threshold for native histogram metric %q: must be a positive number", name)
+				}
+				native.ZeroThreshold = &threshold
+			case "max_buckets":
+				max_buckets, err := cast.ToUint32E(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid max_buckets for native histogram metric %q: must be a positive integer", name)
+				}
+				native.MaxBuckets = max_buckets
+			default:
+				return nil, fmt.Errorf("unknown attribute %q for native histogram metric %q", key, name)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid format for native definition for histogram metric %q: must be a map", name)
+	}
+	return native, nil
+}
+
+// InfoConfig defines an info metric: the var containing the map or object whose scalar attributes are exposed as labels.
+//
+// It may be set to a simple string (the var) or to a map with var, include, exclude and max_value_length.
+type InfoConfig struct {
+	Var            string   `yaml:"var" json:"var"`                                               // var (or template) that contains the map of attributes
+	Include        []string `yaml:"include,omitempty" json:"include,omitempty"`                   // attributes names to keep; may be a pattern if prefixed by ~
+	Exclude        []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`                   // attributes names to drop; may be a pattern if prefixed by ~
+	MaxValueLength int      `yaml:"max_value_length,omitempty" json:"max_value_length,omitempty"` // label values are truncated to this length
+
+	info_var *Field
+	include  *attrFilter
+	exclude  *attrFilter
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for InfoConfig.
+func (ic *InfoConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var info_var string
+	if err := unmarshal(&info_var); err == nil {
+		ic.Var = info_var
+	} else {
+		type plain InfoConfig
+		if err := unmarshal((*plain)(ic)); err != nil {
+			return err
+		}
+	}
+	if ic.Var == "" {
+		return fmt.Errorf("var must be set for info metric")
+	}
+	if ic.MaxValueLength <= 0 {
+		ic.MaxValueLength = info_max_value_length_default
+	}
+	if filter, err := newAttrFilter(ic.Include); err != nil {
+		return fmt.Errorf("invalid include list: %s", err)
+	} else {
+		ic.include = filter
+	}
+	if filter, err := newAttrFilter(ic.Exclude); err != nil {
+		return fmt.Errorf("invalid exclude list: %s", err)
+	} else {
+		ic.exclude = filter
+	}
+	return nil
+}
+
+// attrFilter matches attribute names against a list of names or patterns (prefixed by ~)
+type attrFilter struct {
+	names map[string]bool
+	pats  []*regexp.Regexp
+}
+
+func newAttrFilter(list []string) (*attrFilter, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	filter := &attrFilter{
+		names: make(map[string]bool, len(list)),
+	}
+	for _, elmt := range list {
+		if strings.HasPrefix(elmt, "~") {
+			pat, err := regexp.Compile(strings.TrimSpace(elmt[1:]))
+			if err != nil {
+				return nil, err
+			}
+			filter.pats = append(filter.pats, pat)
+		} else {
+			filter.names[elmt] = true
+		}
+	}
+	return filter, nil
+}
+
+func (f *attrFilter) match(name string) bool {
+	if f == nil {
+		return false
+	}
+	if f.names[name] {
+		return true
+	}
+	for _, pat := range f.pats {
+		if pat.MatchString(name) {
+			return true
+		}
+	}
+	return false
 }
 
 // MetricConfig defines a Prometheus metric, the SQL query to populate it and the mapping of columns to metric
@@ -43,7 +201,12 @@ type MetricConfig struct {
 	Values       map[string]string `yaml:"values" json:"values"`                                   // expose each of these columns as a value, keyed by column name
 	Scope        string            `yaml:"scope,omitempty" json:"scope,omitempty"`                 // var path where to collect data: shortcut for {{ .scope.path.var }}
 
-	HistogramInfos any `yaml:"histogram,omitempty" json:"histogram,omitempty"`
+	HistogramInfos any         `yaml:"histogram,omitempty" json:"histogram,omitempty"`
+	Info           *InfoConfig `yaml:"info,omitempty" json:"info,omitempty"` // for info metric: var containing the attributes to expose as labels
+
+	Unit     string `yaml:"unit,omitempty" json:"unit,omitempty"`         // the OpenMetrics unit of the metric; the metric name is suffixed by the unit
+	Created  string `yaml:"created,omitempty" json:"created,omitempty"`   // the creation time of counter or histogram: epoch seconds or RFC3339 date
+	Exemplar string `yaml:"exemplar,omitempty" json:"exemplar,omitempty"` // var containing the exemplar of a counter: map with labels, value and optional timestamp
 
 	// valueType_old prometheus.ValueType // TypeString converted to prometheus.ValueType
 	valueType dto.MetricType
@@ -55,6 +218,10 @@ type MetricConfig struct {
 	key_labels     *Field
 	prefix         string
 	metric_type    *Field
+	created        *Field
+	exemplar       *Field
+	// where the metric is defined
+	pos SourcePos
 
 	histogram *EHistogram
 }
@@ -74,6 +241,9 @@ func (m *MetricConfig) ValueType(symtab map[string]any, logger *slog.Logger) (dt
 		m.valueType = dto.MetricType_GAUGE
 	case "histogram":
 		m.valueType = dto.MetricType_HISTOGRAM
+	case "info":
+		// info metrics are exposed as a gauge with a constant value 1 (OpenMetrics convention)
+		m.valueType = dto.MetricType_GAUGE
 	case "summary":
 		m.valueType = dto.MetricType_SUMMARY
 	default:
@@ -111,6 +281,32 @@ func (m *MetricConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 	}
 
+	if m.Unit != "" {
+		if !unitRE.MatchString(m.Unit) {
+			return fmt.Errorf("invalid unit %q for metric %q", m.Unit, m.Name)
+		}
+	}
+	if m.Created != "" {
+		if created, err := NewField(m.Created, nil, m.registry); err == nil {
+			m.created = created
+		} else {
+			return err
+		}
+	}
+	if m.Exemplar != "" {
+		if exemplar, err := NewField(m.Exemplar, nil, m.registry); err == nil {
+			m.exemplar = exemplar
+		} else {
+			return err
+		}
+	}
+
+	if m.Info != nil {
+		m.TypeString = "info"
+	} else if strings.ToLower(m.TypeString) == "info" {
+		return fmt.Errorf("info must be set for info metric %q", m.Name)
+	}
+
 	if m.TypeString != "" {
 		if type_str, err := NewField(m.TypeString, nil, m.registry); err == nil {
 			m.metric_type = type_str
@@ -159,7 +355,17 @@ func (m *MetricConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 	}
 
-	if m.HistogramInfos != nil {
+	if m.Info != nil {
+		if m.HistogramInfos != nil || len(m.Values) > 0 {
+			return fmt.Errorf("info metric %q can't have histogram or values defined", m.Name)
+		}
+		m.valueType = dto.MetricType_GAUGE
+		if val, err := NewField(m.Info.Var, nil, m.registry); err == nil {
+			m.Info.info_var = val
+		} else {
+			return err
+		}
+	} else if m.HistogramInfos != nil {
 		m.valueType = dto.MetricType_HISTOGRAM
 
 		m.histogram = &EHistogram{}
@@ -229,12 +435,22 @@ func (m *MetricConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 						if invalid_format {
 							return fmt.Errorf("invalid format for value definition for histogram metric %q: must be string", m.Name)
 						}
+
+					case "native":
+						native, err := newNativeHistogram(iter.Value().Interface(), m.Name)
+						if err != nil {
+							return err
+						}
+						m.histogram.Native = native
 					}
 				}
 			}
-			// need to check if both buckets and value are defined
-			if m.histogram.Buckets == nil || len(*m.histogram.Buckets) == 0 || m.histogram.Histogram_value == nil {
-				return fmt.Errorf("invalid definition for histogram metric %q: buckets and value must be set", m.Name)
+			// need to check if both buckets (or native) and value are defined
+			if m.histogram.Native == nil && (m.histogram.Buckets == nil || len(*m.histogram.Buckets) == 0) {
+				return fmt.Errorf("invalid definition for histogram metric %q: buckets or native must be set", m.Name)
+			}
+			if m.histogram.Histogram_value == nil {
+				return fmt.Errorf("invalid definition for histogram metric %q: value must be set", m.Name)
 				// } else {
 				// 	toto := prometheus.NewHistogramVec(
 				// 		prometheus.HistogramOpts {
@@ -289,6 +505,7 @@ type MetricAction struct {
 	mc           *MetricConfig
 	metricFamily *MetricFamily
 	vars         [][]any
+	pos          SourcePos
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline" json:"-"`
@@ -356,6 +573,13 @@ func (a *MetricAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *MetricAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *MetricAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 func (a *MetricAction) setBasicElement(
 	registry *goja_modules.JSRegistry,
 	nameField *Field,
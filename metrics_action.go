@@ -34,6 +34,7 @@ type MetricsAction struct {
 	XXX map[string]interface{} `yaml:",inline" json:"-"`
 
 	vars [][]any
+	pos  SourcePos
 }
 
 func (a *MetricsAction) Type() int {
@@ -99,6 +100,13 @@ func (a *MetricsAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *MetricsAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *MetricsAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 func (a *MetricsAction) setBasicElement(
 	registry *goja_modules.JSRegistry,
 	nameField *Field,
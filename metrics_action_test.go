@@ -12,6 +12,7 @@ import (
 
 	"github.com/peekjef72/httpapi_exporter/goja_modules"
 	"github.com/peekjef72/httpapi_exporter/template"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 )
@@ -744,3 +745,344 @@ func TestMetricsJSTempo(t *testing.T) {
 	logger.Debug(fmt.Sprintf("metric channel length: %d", len(metricChan)))
 
 }
+
+func TestMetricsInfo(t *testing.T) {
+
+	// init pre-requirements for yamlscript to work
+	initTest()
+
+	logHandlerOpts := &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}
+	logger = slog.New(slog.NewJSONHandler(os.Stderr, logHandlerOpts))
+
+	registry, _ := goja_modules.InitJSRegistry(logger, nil)
+
+	// the script part to execute.
+	code := `
+    - name: collect system infos
+      scope: none
+      metrics:
+        - metric_name: product
+          help: "product informations"
+          info:
+            var: $results
+            exclude:
+              - ~^internal
+            max_value_length: 10
+          key_labels:
+            hostname: $results.hostname
+`
+
+	script := &YAMLScript{
+		name:     "test",
+		registry: registry,
+	}
+	// parse the code and build AST to execute.
+	err := yaml.Unmarshal([]byte(code), &script)
+	if err != nil {
+		assert.Nil(t, err, fmt.Sprintf(`TestMetricsInfo("%s") error: %s`, script.name, err.Error()))
+		return
+	}
+
+	// set metric associated with found code.
+	var logContext []any
+	for _, ma := range script.metricsActions {
+		for _, act := range ma.Actions {
+			if act.Type() == metric_action {
+				mc := act.GetMetric()
+				if mc == nil {
+					assert.NotNil(t, mc, "MetricAction nil received")
+					return
+				}
+				mf, err := NewMetricFamily(logContext, mc, nil, nil)
+				if err != nil {
+					assert.Nil(t, err, "NewMetricFamily() error")
+					return
+				}
+				act.SetMetricFamily(mf)
+			}
+		}
+	}
+	// add constants to symbols table so that script can work
+	symtab["__collector_id"] = "metrics_action_test.go"
+	symtab["__name__"] = "TestMetricsInfo"
+	symtab["query_status"] = true
+
+	// set the data to build metrics content
+	results_str := `{
+		"hostname": "switch01",
+		"productName": "Aruba 6300M",
+		"serialNumber": "SG0123456789ABCDEF",
+		"firmware-version": "10.13.1000",
+		"job": "switch",
+		"internalId": "xyz",
+		"ports": 48,
+		"modules": ["1/1", "1/2"]
+	}`
+
+	var data any
+	if err := json.Unmarshal([]byte(results_str), &data); err != nil {
+		t.Errorf(`TestMetricsInfo() parsing test results error: %s`, err.Error())
+		return
+	}
+	symtab["results"] = data
+
+	// set the channel to send results so that we can receive & analyze them
+	metricChan := make(chan Metric, capMetricChan)
+	symtab["__metric_channel"] = (chan<- Metric)(metricChan)
+
+	// play the script
+	err = script.Play(symtab, false, logger)
+	if err != nil {
+		assert.Nil(t, err, `TestMetricsInfo("%s") error: %s`, script.name, err.Error())
+		return
+	}
+
+	if !assert.Equal(t, 1, len(metricChan), "one info metric expected") {
+		return
+	}
+	metric, ok := (<-metricChan).(*constMetric)
+	if !assert.True(t, ok, "constMetric expected") {
+		return
+	}
+	assert.Equal(t, "product_info", metric.Desc().Name())
+	assert.Equal(t, dto.MetricType_GAUGE, metric.Desc().ValueType())
+	assert.Equal(t, 1.0, metric.val)
+
+	labels := make(map[string]string)
+	for _, label := range metric.labelPairs {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, map[string]string{
+		"exported_job":     "switch",
+		"firmware_version": "10.13.1000",
+		"hostname":         "switch01",
+		"ports":            "48",
+		"product_name":     "Aruba 6300",
+		"serial_number":    "SG01234567",
+	}, labels)
+}
+
+func TestMetricsExemplar(t *testing.T) {
+
+	// init pre-requirements for yamlscript to work
+	initTest()
+
+	logHandlerOpts := &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}
+	logger = slog.New(slog.NewJSONHandler(os.Stderr, logHandlerOpts))
+
+	registry, _ := goja_modules.InitJSRegistry(logger, nil)
+
+	// the script part to execute: re-export a counter with its exemplar
+	code := `
+    - name: collect requests
+      scope: none
+      metrics:
+        - metric_name: http_requests_total
+          help: "Total number of HTTP requests."
+          type: counter
+          unit: requests
+          created: "2026-10-01T00:00:00Z"
+          exemplar: $metric.exemplar
+          key_labels: $metric.labels
+          values:
+            _: $metric.value
+          loop: $results.http_requests_total.metrics
+          loop_var: metric
+`
+
+	script := &YAMLScript{
+		name:     "test",
+		registry: registry,
+	}
+	// parse the code and build AST to execute.
+	err := yaml.Unmarshal([]byte(code), &script)
+	if err != nil {
+		assert.Nil(t, err, fmt.Sprintf(`TestMetricsExemplar("%s") error: %s`, script.name, err.Error()))
+		return
+	}
+
+	// set metric associated with found code.
+	var logContext []any
+	for _, ma := range script.metricsActions {
+		for _, act := range ma.Actions {
+			if act.Type() == metric_action {
+				mc := act.GetMetric()
+				if mc == nil {
+					assert.NotNil(t, mc, "MetricAction nil received")
+					return
+				}
+				mf, err := NewMetricFamily(logContext, mc, nil, nil)
+				if err != nil {
+					assert.Nil(t, err, "NewMetricFamily() error")
+					return
+				}
+				act.SetMetricFamily(mf)
+			}
+		}
+	}
+	// add constants to symbols table so that script can work
+	symtab["__collector_id"] = "metrics_action_test.go"
+	symtab["__name__"] = "TestMetricsExemplar"
+	symtab["query_status"] = true
+
+	// set the data to build metrics content
+	file_content, err := os.ReadFile("fixtures/response_openmetrics.prom")
+	if err != nil {
+		t.Errorf(`TestMetricsExemplar() load test results error: %s`, err.Error())
+		return
+	}
+	data, err := ParsePrometheusResponse(file_content)
+	if err != nil {
+		t.Errorf(`TestMetricsExemplar() parsing test results error: %s`, err.Error())
+		return
+	}
+	symtab["results"] = data
+
+	// set the channel to send results so that we can receive & analyze them
+	metricChan := make(chan Metric, capMetricChan)
+	symtab["__metric_channel"] = (chan<- Metric)(metricChan)
+
+	// play the script
+	err = script.Play(symtab, false, logger)
+	if err != nil {
+		assert.Nil(t, err, `TestMetricsExemplar("%s") error: %s`, script.name, err.Error())
+		return
+	}
+
+	if !assert.Equal(t, 2, len(metricChan), "two metrics expected") {
+		return
+	}
+	for range 2 {
+		metric := <-metricChan
+		if invalid, ok := metric.(invalidMetric); ok {
+			assert.Nil(t, invalid.err)
+			return
+		}
+		assert.Equal(t, "http_requests_total", metric.Desc().Name())
+
+		out := &dto.Metric{}
+		if err := metric.Write(out); !assert.Nil(t, err) {
+			return
+		}
+		if !assert.NotNil(t, out.Counter, "counter expected") {
+			return
+		}
+		assert.Equal(t, int64(1790812800), out.Counter.GetCreatedTimestamp().GetSeconds())
+
+		labels := make(map[string]string)
+		for _, label := range out.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["method"] == "GET" {
+			if assert.NotNil(t, out.Counter.Exemplar, "exemplar expected") {
+				assert.Equal(t, 1.0, out.Counter.Exemplar.GetValue())
+				assert.Equal(t, "trace_id", out.Counter.Exemplar.Label[0].GetName())
+				assert.Equal(t, int64(1700000000), out.Counter.Exemplar.GetTimestamp().GetSeconds())
+			}
+		} else {
+			assert.Nil(t, out.Counter.Exemplar, "no exemplar expected")
+		}
+	}
+}
+
+func TestMetricsNativeHistogram(t *testing.T) {
+
+	// init pre-requirements for yamlscript to work
+	initTest()
+
+	logHandlerOpts := &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}
+	logger = slog.New(slog.NewJSONHandler(os.Stderr, logHandlerOpts))
+
+	registry, _ := goja_modules.InitJSRegistry(logger, nil)
+
+	// the script part to execute: a static histogram with native buckets only
+	code := `
+    - name: collect response time
+      scope: none
+      metrics:
+        - metric_name: query_total_seconds
+          help: total response time repartition for query
+          type: histogram
+          key_labels:
+            page: /metrics
+          histogram:
+            native:
+              schema: 2
+              zero_threshold: 0.001
+            value: $trace_infos.total_time
+`
+
+	script := &YAMLScript{
+		name:     "test",
+		registry: registry,
+	}
+	// parse the code and build AST to execute.
+	err := yaml.Unmarshal([]byte(code), &script)
+	if err != nil {
+		assert.Nil(t, err, fmt.Sprintf(`TestMetricsNativeHistogram("%s") error: %s`, script.name, err.Error()))
+		return
+	}
+
+	// set metric associated with found code.
+	var logContext []any
+	for _, ma := range script.metricsActions {
+		for _, act := range ma.Actions {
+			if act.Type() == metric_action {
+				mc := act.GetMetric()
+				if mc == nil {
+					assert.NotNil(t, mc, "MetricAction nil received")
+					return
+				}
+				mf, err := NewMetricFamily(logContext, mc, nil, nil)
+				if err != nil {
+					assert.Nil(t, err, "NewMetricFamily() error")
+					return
+				}
+				act.SetMetricFamily(mf)
+			}
+		}
+	}
+	// add constants to symbols table so that script can work
+	symtab["__collector_id"] = "metrics_action_test.go"
+	symtab["__name__"] = "TestMetricsNativeHistogram"
+	symtab["query_status"] = true
+	symtab["trace_infos"] = map[string]any{"total_time": 0.25}
+
+	// set the channel to send results so that we can receive & analyze them
+	metricChan := make(chan Metric, capMetricChan)
+	symtab["__metric_channel"] = (chan<- Metric)(metricChan)
+
+	// play the script
+	err = script.Play(symtab, false, logger)
+	if err != nil {
+		assert.Nil(t, err, `TestMetricsNativeHistogram("%s") error: %s`, script.name, err.Error())
+		return
+	}
+
+	if !assert.Equal(t, 1, len(metricChan), "one histogram expected") {
+		return
+	}
+	metric := <-metricChan
+	if invalid, ok := metric.(invalidMetric); ok {
+		assert.Nil(t, invalid.err)
+		return
+	}
+	out := &dto.Metric{}
+	if err := metric.Write(out); !assert.Nil(t, err) {
+		return
+	}
+	if !assert.NotNil(t, out.Histogram, "histogram expected") {
+		return
+	}
+	assert.Equal(t, int32(2), out.Histogram.GetSchema())
+	assert.Equal(t, 0.001, out.Histogram.GetZeroThreshold())
+	assert.Equal(t, uint64(1), out.Histogram.GetSampleCount())
+	assert.Equal(t, 0, len(out.Histogram.Bucket), "no classic bucket expected")
+	assert.Equal(t, 1, len(out.Histogram.PositiveSpan))
+}
@@ -25,6 +25,7 @@ type PlayScriptAction struct {
 
 	playScriptAction *YAMLScript
 	vars             [][]any
+	pos              SourcePos
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline" json:"-"`
@@ -93,6 +94,13 @@ func (a *PlayScriptAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *PlayScriptAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *PlayScriptAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 func (a *PlayScriptAction) setBasicElement(
 	registry *goja_modules.JSRegistry,
 	nameField *Field,
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// label of the target name added to the metrics collected by /probe_many
+	probeManyTargetLabel = "target"
+	// label of the groups of targets selected by /probe_many group parameter
+	probeManyGroupLabel = "group"
+
+	defaultProbeManyConcurrency = 10
+)
+
+// ProbeManyHandlerFunc is the HTTP handler for the `/probe_many` page. It collects in parallel the static targets set
+// by the target parameters or whose group label is one of the group parameters, and exposes their metrics merged,
+// with a target label set to the name of the target.
+func ProbeManyHandlerFunc(metricsPath string, exporter Exporter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		if len(params["target"]) == 0 && len(params["group"]) == 0 {
+			err := errors.New("target or group parameter is missing")
+			HandleError(http.StatusBadRequest, err, metricsPath, exporter, w, req)
+			return
+		}
+		targets, err := probeManyTargets(exporter, params["target"], params["group"])
+		if err != nil {
+			HandleError(http.StatusNotFound, err, metricsPath, exporter, w, req)
+			return
+		}
+		if len(targets) == 0 {
+			err := fmt.Errorf("no target found in group '%s'", strings.Join(params["group"], ","))
+			HandleError(http.StatusNotFound, err, metricsPath, exporter, w, req)
+			return
+		}
+
+		auth_key, err := authKeyFor(req, exporter.Config().Globals)
+		if err != nil {
+			HandleError(http.StatusBadRequest, err, metricsPath, exporter, w, req)
+			return
+		}
+		for _, target := range targets {
+			// set a specific collector_name for target
+			if err := setSpecificCollectors(exporter, target, params["collector"]); err != nil {
+				HandleError(http.StatusNotFound, err, metricsPath, exporter, w, req)
+				return
+			}
+			if auth_key != "" {
+				target.SetSymbol("auth_key", auth_key)
+			}
+		}
+		health_only := strings.ToLower(params.Get("health")) == "true"
+
+		concurrency := exporter.Config().Globals.ProbeManyConcurrency
+		if concurrency <= 0 {
+			concurrency = defaultProbeManyConcurrency
+		}
+		gatherers := probeManyGather(req, exporter, targets, health_only, concurrency)
+
+		// Go through prometheus.Gatherers to merge, sanitize and sort metrics.
+		mfs, err := prometheus.Gatherers(gatherers).Gather()
+		for _, g := range gatherers {
+			g.(*unitsGatherer).restore(mfs)
+		}
+		if err != nil {
+			exporter.Logger().Error(
+				fmt.Sprintf("Error gathering metrics of probe_many: %s", err))
+			if len(mfs) == 0 {
+				http.Error(w, "No metrics gathered, "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		writeMetricFamilies(w, req, exporter, mfs)
+	}
+}
+
+// probeManyTargets returns the targets named in names, then the static targets whose group label is one of groups.
+// Each target is returned once; an unknown name is an error.
+func probeManyTargets(exporter Exporter, names, groups []string) ([]Target, error) {
+	targets := make([]Target, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "template" {
+			continue
+		}
+		target, err := exporter.FindTarget(name)
+		if err != nil {
+			return nil, fmt.Errorf("target '%s': %s", name, err)
+		}
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	if len(groups) == 0 {
+		return targets, nil
+	}
+	for _, target := range exporter.Targets() {
+		t := target.Config()
+		// models, dynamic targets and targets_files pseudo targets are not in groups.
+		if t.targetType != TargetTypeStatic || len(t.TargetsFiles) > 0 {
+			continue
+		}
+		if group, ok := t.Labels[probeManyGroupLabel]; !ok || !slices.Contains(groups, group) {
+			continue
+		}
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// probeManyGather collects the targets, at most concurrency at a time, and returns a gatherer of the metrics of each
+// target, labeled with its name.
+func probeManyGather(req *http.Request, exporter Exporter, targets []Target, health_only bool, concurrency int) []prometheus.Gatherer {
+	gatherers := make([]prometheus.Gatherer, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for idx, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ctx, cancel := contextFor(req, exporter, target)
+			defer cancel()
+			mfs, err := exporter.WithContext(ctx, target, health_only).Gather()
+			if err != nil {
+				err = fmt.Errorf("target '%s': %w", target.Name(), err)
+			}
+			setTargetLabel(mfs, target.Name())
+			gatherers[idx] = &unitsGatherer{gatherer: prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return mfs, err
+			})}
+		}()
+	}
+	wg.Wait()
+	return gatherers
+}
+
+// setTargetLabel sets the target label of the metrics to name. A target label already set by the target is kept as
+// exported_target, as prometheus does when labels conflict.
+func setTargetLabel(mfs []*dto.MetricFamily, name string) {
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			idx := slices.IndexFunc(m.Label, func(lp *dto.LabelPair) bool {
+				return lp.GetName() == probeManyTargetLabel
+			})
+			if idx >= 0 {
+				m.Label[idx].Name = proto.String("exported_" + probeManyTargetLabel)
+			}
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String(probeManyTargetLabel),
+				Value: proto.String(name),
+			})
+		}
+	}
+}
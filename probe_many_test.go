@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProbeManyTargets(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	dir := t.TempDir()
+	copyDir(t, "contribs/apache/etc/apache", dir)
+	target_file := func(name, group string) {
+		content := "name: " + name + "\nhost: " + name + ".local\nprofile: apache\ncollectors:\n  - ~ apache_.*\nlabels:\n  group: " + group + "\n"
+		if err := os.WriteFile(filepath.Join(dir, "targets", name+".yml"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target_file("web1", "front")
+	target_file("web2", "front")
+	target_file("db1", "back")
+
+	e, err := NewExporter(filepath.Join(dir, "config.yml"), "", logger, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, defaultProbeManyConcurrency, e.Config().Globals.ProbeManyConcurrency)
+
+	names := func(targets []Target) []string {
+		res := make([]string, 0, len(targets))
+		for _, target := range targets {
+			res = append(res, target.Name())
+		}
+		return res
+	}
+	targets, err := probeManyTargets(e, []string{"db1", "web1"}, []string{"front"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"db1", "web1", "web2"}, names(targets))
+	}
+	// models are not in groups
+	targets, err = probeManyTargets(e, nil, []string{"front", "back", ""})
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"web1", "web2", "db1"}, names(targets))
+	}
+	_, err = probeManyTargets(e, []string{"web1", "unknown"}, nil)
+	assert.ErrorContains(t, err, "unknown")
+
+	handler := ProbeManyHandlerFunc("/metrics", e)
+	for url, status := range map[string]int{
+		"/probe_many":                                  http.StatusBadRequest,
+		"/probe_many?target=unknown":                   http.StatusNotFound,
+		"/probe_many?group=unknown":                    http.StatusNotFound,
+		"/probe_many?group=front&collector=unknown_co": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, status, w.Code, url)
+	}
+}
+
+func TestSetTargetLabel(t *testing.T) {
+	mfs := []*dto.MetricFamily{{
+		Name: proto.String("up"),
+		Metric: []*dto.Metric{
+			{Label: []*dto.LabelPair{{Name: proto.String("host"), Value: proto.String("h1")}}},
+			{Label: []*dto.LabelPair{{Name: proto.String("target"), Value: proto.String("backend")}}},
+		},
+	}}
+	setTargetLabel(mfs, "web1")
+
+	labels := func(m *dto.Metric) map[string]string {
+		res := make(map[string]string, len(m.Label))
+		for _, lp := range m.Label {
+			res[lp.GetName()] = lp.GetValue()
+		}
+		return res
+	}
+	assert.Equal(t, map[string]string{"host": "h1", "target": "web1"}, labels(mfs[0].Metric[0]))
+	assert.Equal(t, map[string]string{"exported_target": "backend", "target": "web1"}, labels(mfs[0].Metric[1]))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/peekjef72/httpapi_exporter/goja_modules"
+	"gopkg.in/yaml.v3"
+)
+
+// keys of a script of a profile with extends, to add actions to the script of the extended profile instead of
+// replacing it.
+const (
+	scriptPrepend = "prepend"
+	scriptAppend  = "append"
+)
+
+// buildProfiles resolves the extends of profiles definitions and builds the profiles. It returns the concrete
+// profiles and the names of the abstract ones.
+func buildProfiles(registry *goja_modules.JSRegistry, parsers map[string]*profileParser) (map[string]*Profile, map[string]bool, error) {
+	resolved := make(map[string]*profileParser, len(parsers))
+	for name := range parsers {
+		if _, err := resolveProfile(name, parsers, resolved, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	profiles := make(map[string]*Profile, len(resolved))
+	abstract := make(map[string]bool)
+	for name, parser := range resolved {
+		for module_name, module_path := range parser.Modules {
+			if _, ok := registry.Modules[module_name]; !ok {
+				registry.Modules[module_name] = module_path
+			}
+		}
+		if parser.Abstract {
+			abstract[name] = true
+			continue
+		}
+		profile := &Profile{
+			MetricPrefix: parser.MetricPrefix,
+			registry:     registry,
+		}
+		if len(parser.ScriptsNodes) > 0 {
+			var err error
+			profile.Scripts, err = build_YAMLScript(registry, parser.file, parser.nodeFiles, parser.ScriptsNodes)
+			if err != nil {
+				if parser.file != "" {
+					return nil, nil, fmt.Errorf("reading %s: in profile '%s' %s", parser.file, name, err)
+				}
+				return nil, nil, fmt.Errorf("in profile '%s' %s", name, err)
+			}
+		}
+		profiles[name] = profile
+	}
+	return profiles, abstract, nil
+}
+
+// resolveProfile returns the definition of profile name merged with the ones of the profiles it extends.
+// stack is the list of the profiles extending name, to detect loops.
+func resolveProfile(name string, parsers, resolved map[string]*profileParser, stack []string) (*profileParser, error) {
+	if parser, ok := resolved[name]; ok {
+		return parser, nil
+	}
+	parser := parsers[name]
+	for idx, n := range stack {
+		if n == name {
+			return nil, fmt.Errorf("profile '%s': extends loop: %s -> %s", name, strings.Join(stack[idx:], " -> "), name)
+		}
+	}
+	if parser.Extends == "" {
+		resolved[name] = parser
+		return parser, nil
+	}
+	if _, ok := parsers[parser.Extends]; !ok {
+		return nil, fmt.Errorf("profile '%s' extends unknown profile '%s'", name, parser.Extends)
+	}
+	base, err := resolveProfile(parser.Extends, parsers, resolved, append(stack, name))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &profileParser{
+		MetricPrefix: base.MetricPrefix,
+		Modules:      make(map[string]string, len(base.Modules)+len(parser.Modules)),
+		Extends:      parser.Extends,
+		// abstract is not inherited: a profile extending an abstract one is usable by targets if not set.
+		Abstract:     parser.Abstract,
+		ScriptsNodes: make(map[string]yaml.Node, len(base.ScriptsNodes)+len(parser.ScriptsNodes)),
+		file:         parser.file,
+		nodeFiles:    make(map[*yaml.Node]string),
+	}
+	// actions of the extended profile keep the file they are defined in
+	maps.Copy(res.nodeFiles, base.nodeFiles)
+	for _, node := range base.ScriptsNodes {
+		for _, act := range node.Content {
+			if _, ok := res.nodeFiles[act]; !ok {
+				res.nodeFiles[act] = base.file
+			}
+		}
+	}
+	if parser.MetricPrefix != "" {
+		res.MetricPrefix = parser.MetricPrefix
+	}
+	maps.Copy(res.Modules, base.Modules)
+	maps.Copy(res.Modules, parser.Modules)
+	maps.Copy(res.ScriptsNodes, base.ScriptsNodes)
+	for script_name, node := range parser.ScriptsNodes {
+		merged, err := mergeScriptNode(base.ScriptsNodes[script_name], node)
+		if err != nil {
+			return nil, fmt.Errorf("profile '%s' script '%s': %s", name, script_name, err)
+		}
+		res.ScriptsNodes[script_name] = merged
+	}
+	resolved[name] = res
+	return res, nil
+}
+
+// mergeScriptNode returns the script of a profile that overrides the base script. A list of actions (or null)
+// replaces the base script; a map with "prepend" and/or "append" lists of actions adds them before and/or after the
+// actions of the base script.
+func mergeScriptNode(base, node yaml.Node) (yaml.Node, error) {
+	if node.Kind != yaml.MappingNode {
+		return node, nil
+	}
+	var prepend, append_ []*yaml.Node
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		key, value := node.Content[idx], node.Content[idx+1]
+		if value.Kind != yaml.SequenceNode && value.Tag != "!!null" {
+			return node, fmt.Errorf("%s must be a list of actions", key.Value)
+		}
+		switch key.Value {
+		case scriptPrepend:
+			prepend = value.Content
+		case scriptAppend:
+			append_ = value.Content
+		default:
+			return node, fmt.Errorf("invalid key '%s': a script must be a list of actions, or a map with '%s' and '%s' lists of actions", key.Value, scriptPrepend, scriptAppend)
+		}
+	}
+
+	res := yaml.Node{
+		Kind:   yaml.SequenceNode,
+		Tag:    "!!seq",
+		Line:   node.Line,
+		Column: node.Column,
+	}
+	res.Content = append(res.Content, prepend...)
+	if base.Kind == yaml.SequenceNode {
+		res.Content = append(res.Content, base.Content...)
+	}
+	res.Content = append(res.Content, append_...)
+	return res, nil
+}
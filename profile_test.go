@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/peekjef72/httpapi_exporter/goja_modules"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestProfileExtends(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	registry, _ := goja_modules.InitJSRegistry(logger, nil)
+
+	config := `
+base:
+  abstract: true
+  metric_prefix: base
+  scripts:
+    init:
+      - name: init headers
+        set_fact:
+          headers: {}
+    login: ~
+    ping:
+      - name: ping
+        set_fact:
+          logged: true
+device:
+  extends: base
+  metric_prefix: device
+  scripts:
+    init:
+      prepend:
+        - name: first
+          set_fact:
+            first: true
+      append:
+        - name: last
+          set_fact:
+            last: true
+    login:
+      - name: login
+        set_fact:
+          login: true
+other:
+  extends: device
+  scripts:
+    ping: ~
+`
+	var p profiles = profiles{profiles: make(map[string]*profileParser)}
+	if err := yaml.Unmarshal([]byte(config), &p); err != nil {
+		t.Fatal(err)
+	}
+	p.profiles["base"].file = "base_profile.yml"
+	p.profiles["device"].file = "device_profile.yml"
+	built, abstract, err := buildProfiles(registry, p.profiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]bool{"base": true}, abstract)
+	assert.NotContains(t, built, "base")
+
+	device := built["device"]
+	if assert.NotNil(t, device) {
+		assert.Equal(t, "device", device.MetricPrefix)
+		names := []string{}
+		for _, a := range device.Scripts["init"].Actions {
+			names = append(names, a.GetName(nil, logger))
+		}
+		assert.Equal(t, []string{"first", "init headers", "last"}, names)
+		assert.NotNil(t, device.Scripts["login"])
+		assert.NotNil(t, device.Scripts["ping"])
+	}
+	other := built["other"]
+	if assert.NotNil(t, other) {
+		assert.Equal(t, "device", other.MetricPrefix)
+		assert.Equal(t, 3, len(other.Scripts["init"].Actions))
+		assert.Nil(t, other.Scripts["ping"])
+		// inherited actions are located in the file of the profile defining them
+		files := []string{}
+		for _, a := range other.Scripts["init"].Actions {
+			files = append(files, a.GetPos().File)
+		}
+		assert.Equal(t, []string{"device_profile.yml", "base_profile.yml", "device_profile.yml"}, files)
+		assert.Equal(t, SourcePos{File: "base_profile.yml", Line: 7, Column: 9}, other.Scripts["init"].Actions[1].GetPos())
+	}
+
+	// loops and unknown profiles
+	p.profiles["base"].Extends = "other"
+	_, _, err = buildProfiles(registry, p.profiles)
+	assert.ErrorContains(t, err, "extends loop")
+	p.profiles["base"].Extends = "unknown"
+	_, _, err = buildProfiles(registry, p.profiles)
+	assert.ErrorContains(t, err, "unknown profile")
+}
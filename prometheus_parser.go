@@ -3,9 +3,12 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 
 	// cp "github.com/mitchellh/copystructure"
@@ -13,14 +16,33 @@ import (
 	"github.com/prometheus/common/expfmt"
 )
 
+// ParsePrometheusResponse parses a prometheus text or OpenMetrics text exposition.
+//
+// OpenMetrics exemplars, units and created timestamps are not supported by the text decoder: they are extracted
+// before decoding and added to the results: "unit" for metric families, "exemplar" for metrics and histogram
+// buckets, "created" for metrics. OpenMetrics counter families are named with the `_total` suffix of their samples,
+// as in the text format.
 func ParsePrometheusResponse(data []byte) (any, error) {
 
-	buf := bufio.NewReader(strings.NewReader(string(data)))
-	// buf := bytes.NewReader(data)
-	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	content, infos := extractOpenMetricsInfos(string(data))
+
+	buf := bufio.NewReader(strings.NewReader(content))
+	return decodePrometheusResponse(buf, expfmt.NewFormat(expfmt.TypeTextPlain), infos)
+}
+
+// ParsePrometheusProtobufResponse parses a prometheus delimited protobuf exposition.
+//
+// It is the only format that transmits native histograms; their sparse buckets are added to the histogram results.
+func ParsePrometheusProtobufResponse(data []byte) (any, error) {
+	return decodePrometheusResponse(bytes.NewReader(data), expfmt.NewFormat(expfmt.TypeProtoDelim), nil)
+}
+
+func decodePrometheusResponse(buf io.Reader, format expfmt.Format, infos *openMetricsInfos) (any, error) {
+	if infos == nil {
+		infos = newOpenMetricsInfos()
+	}
+	units, exemplars := infos.units, infos.exemplars
 	decoder := expfmt.NewDecoder(buf, format)
-	// var metrics []*dto.MetricFamily
-	// results := make([]map[string]any, 0, 10)
 	results := make(map[string]any)
 	for {
 		var (
@@ -36,8 +58,13 @@ func ParsePrometheusResponse(data []byte) (any, error) {
 		res := make(map[string]any)
 		name = *mf.Name
 		res["name"] = name
-		res["help"] = *mf.Help
+		res["help"] = mf.GetHelp()
 		res["type"] = strings.ToLower(mf.Type.String())
+		if unit, ok := units[name]; ok {
+			res["unit"] = unit
+		} else if mf.Unit != nil {
+			res["unit"] = mf.GetUnit()
+		}
 		res_metrics := make([]map[string]any, 0, 10)
 		for _, metric := range mf.Metric {
 			res_metric := make(map[string]any)
@@ -48,30 +75,64 @@ func ParsePrometheusResponse(data []byte) (any, error) {
 				}
 			}
 			res_metric["labels"] = labels
+			if created, ok := infos.created[sampleKey(name, labels)]; ok {
+				res_metric["created"] = created
+			}
 
 			switch *mf.Type {
 			case dto.MetricType_GAUGE:
 				if metric.Gauge != nil {
 					res_metric["value"] = metric.Gauge.GetValue()
 				}
+				if exemplar, ok := exemplars[sampleKey(name, labels)]; ok {
+					res_metric["exemplar"] = exemplar
+				}
 			case dto.MetricType_COUNTER:
 				if metric.Counter != nil {
 					res_metric["value"] = metric.Counter.GetValue()
+					if metric.Counter.Exemplar != nil {
+						res_metric["exemplar"] = exemplarToMap(metric.Counter.Exemplar)
+					}
+				}
+				if exemplar, ok := exemplars[sampleKey(name, labels)]; ok {
+					res_metric["exemplar"] = exemplar
+				}
+			case dto.MetricType_UNTYPED:
+				if metric.Untyped != nil {
+					res_metric["value"] = metric.Untyped.GetValue()
+				}
+				if exemplar, ok := exemplars[sampleKey(name, labels)]; ok {
+					res_metric["exemplar"] = exemplar
 				}
 			case dto.MetricType_HISTOGRAM:
 				if metric.Histogram != nil {
 					histogram := make(map[string]any)
-					histogram["sample_count"] = *metric.Histogram.SampleCount
-					histogram["sample_sum"] = *metric.Histogram.SampleSum
+					histogram["sample_count"] = metric.Histogram.GetSampleCount()
+					histogram["sample_sum"] = metric.Histogram.GetSampleSum()
 					res_buckets := make([]any, 0)
 
 					for _, bucket := range metric.Histogram.Bucket {
 						res_bucket := make(map[string]any)
 						res_bucket["le"] = fmt.Sprintf("%g", *bucket.UpperBound)
-						res_bucket["value"] = *bucket.CumulativeCount
+						res_bucket["value"] = bucket.GetCumulativeCount()
+						if bucket.Exemplar != nil {
+							res_bucket["exemplar"] = exemplarToMap(bucket.Exemplar)
+						} else if len(exemplars) > 0 {
+							bucket_labels := make(map[string]string, len(labels)+1)
+							for key, val := range labels {
+								bucket_labels[key] = val
+							}
+							bucket_labels["le"] = res_bucket["le"].(string)
+							if exemplar, ok := exemplars[sampleKey(name+"_bucket", bucket_labels)]; ok {
+								res_bucket["exemplar"] = exemplar
+							}
+						}
 						res_buckets = append(res_buckets, res_bucket)
 					}
 					histogram["buckets"] = res_buckets
+					if metric.Histogram.Schema != nil {
+						addNativeHistogram(histogram, metric.Histogram)
+					}
 					res_metric["histogram"] = histogram
 				}
 
@@ -99,3 +160,274 @@ func ParsePrometheusResponse(data []byte) (any, error) {
 	}
 	return results, nil
 }
+
+// addNativeHistogram adds the native (sparse) buckets of a histogram to its results.
+func addNativeHistogram(histogram map[string]any, hist *dto.Histogram) {
+	histogram["schema"] = hist.GetSchema()
+	histogram["zero_threshold"] = hist.GetZeroThreshold()
+	if hist.ZeroCountFloat != nil {
+		histogram["zero_count_float"] = hist.GetZeroCountFloat()
+	} else {
+		histogram["zero_count"] = hist.GetZeroCount()
+	}
+	histogram["positive_spans"] = spansToList(hist.PositiveSpan)
+	histogram["negative_spans"] = spansToList(hist.NegativeSpan)
+	if len(hist.PositiveCount) > 0 || len(hist.NegativeCount) > 0 {
+		// float histogram
+		histogram["positive_counts"] = hist.PositiveCount
+		histogram["negative_counts"] = hist.NegativeCount
+	} else {
+		histogram["positive_deltas"] = hist.PositiveDelta
+		histogram["negative_deltas"] = hist.NegativeDelta
+	}
+}
+
+func spansToList(spans []*dto.BucketSpan) []any {
+	res_spans := make([]any, 0, len(spans))
+	for _, span := range spans {
+		res_spans = append(res_spans, map[string]any{
+			"offset": span.GetOffset(),
+			"length": span.GetLength(),
+		})
+	}
+	return res_spans
+}
+
+// exemplarToMap converts a decoded exemplar to the format used for OpenMetrics text exemplars.
+func exemplarToMap(exemplar *dto.Exemplar) map[string]any {
+	labels := make(map[string]string, len(exemplar.Label))
+	for _, label := range exemplar.Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	res := map[string]any{
+		"labels": labels,
+		"value":  exemplar.GetValue(),
+	}
+	if exemplar.Timestamp != nil {
+		res["timestamp"] = float64(exemplar.Timestamp.AsTime().UnixNano()) / 1e9
+	}
+	return res
+}
+
+// openMetricsInfos are the elements of an OpenMetrics exposition not supported by the text decoder.
+type openMetricsInfos struct {
+	// units by metric family name
+	units map[string]string
+	// exemplars by sample key
+	exemplars map[string]map[string]any
+	// created timestamps by sample key of the metric
+	created map[string]float64
+}
+
+func newOpenMetricsInfos() *openMetricsInfos {
+	return &openMetricsInfos{
+		units:     make(map[string]string),
+		exemplars: make(map[string]map[string]any),
+		created:   make(map[string]float64),
+	}
+}
+
+// extractOpenMetricsInfos removes the OpenMetrics elements not supported by the text decoder, so that the content
+// can be decoded by it: exemplars of samples lines and `_created` samples are removed, and counter families are
+// renamed with the `_total` suffix of their samples.
+//
+// It returns the cleaned content and the infos removed.
+func extractOpenMetricsInfos(data string) (string, *openMetricsInfos) {
+	infos := newOpenMetricsInfos()
+
+	if !strings.Contains(data, "# UNIT ") && !strings.Contains(data, " # {") && !strings.Contains(data, "# EOF") {
+		return data, infos
+	}
+
+	// families that may have a created timestamp, with the name of their results.
+	families := make(map[string]string)
+	for line := range strings.Lines(data) {
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "#" || fields[1] != "TYPE" {
+			continue
+		}
+		switch name := fields[2]; fields[3] {
+		case "counter":
+			if !strings.HasSuffix(name, "_total") {
+				families[name] = name + "_total"
+			} else {
+				families[strings.TrimSuffix(name, "_total")] = name
+			}
+		case "histogram", "summary":
+			families[name] = name
+		}
+	}
+
+	var content strings.Builder
+	for line := range strings.Lines(data) {
+		if strings.HasPrefix(line, "#") {
+			if fields := strings.Fields(line); len(fields) >= 3 && (fields[1] == "HELP" || fields[1] == "TYPE" || fields[1] == "UNIT") {
+				// counter family named as its samples
+				if name, ok := families[fields[2]]; ok && name != fields[2] {
+					line = strings.Replace(line, fields[2], name, 1)
+					fields[2] = name
+				}
+				if len(fields) == 4 && fields[1] == "UNIT" {
+					infos.units[fields[2]] = fields[3]
+				}
+			}
+			content.WriteString(line)
+			continue
+		}
+		sample, exemplar_str := splitExemplar(line)
+		if name, labels, err := parseSampleId(sample); err == nil && strings.HasSuffix(name, "_created") {
+			if family, ok := families[strings.TrimSuffix(name, "_created")]; ok {
+				if created, err := parseSampleValue(sample); err == nil {
+					infos.created[sampleKey(family, labels)] = created
+				}
+				continue
+			}
+		}
+		if exemplar_str == "" {
+			content.WriteString(line)
+			continue
+		}
+		content.WriteString(sample)
+		content.WriteByte('\n')
+
+		exemplar, err := parseExemplar(exemplar_str)
+		if err != nil {
+			// exemplar is optional: ignore it if invalid
+			continue
+		}
+		name, labels, err := parseSampleId(sample)
+		if err != nil {
+			continue
+		}
+		infos.exemplars[sampleKey(name, labels)] = exemplar
+	}
+	return content.String(), infos
+}
+
+// splitExemplar returns the sample part and the exemplar part of a line (`sample # {labels} value [timestamp]`).
+// The exemplar part is empty if there is no exemplar.
+func splitExemplar(line string) (string, string) {
+	in_quotes := false
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && in_quotes:
+			escaped = true
+		case c == '"':
+			in_quotes = !in_quotes
+		case c == '#' && !in_quotes && i > 0 && line[i-1] == ' ':
+			return strings.TrimRight(line[:i], " "), strings.TrimSpace(line[i+1:])
+		}
+	}
+	return line, ""
+}
+
+// parseLabelSet parses a label set `{name="value",...}` at the beginning of str;
+// it returns the labels and the remaining string.
+func parseLabelSet(str string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+	if !strings.HasPrefix(str, "{") {
+		return labels, str, fmt.Errorf("label set must begin with '{'")
+	}
+	str = str[1:]
+	for {
+		str = strings.TrimLeft(str, " ,")
+		if strings.HasPrefix(str, "}") {
+			return labels, str[1:], nil
+		}
+		pos := strings.Index(str, "=")
+		if pos <= 0 {
+			return labels, str, fmt.Errorf("invalid label set: label name not found")
+		}
+		name := strings.TrimSpace(str[:pos])
+		str = strings.TrimLeft(str[pos+1:], " ")
+		if !strings.HasPrefix(str, `"`) {
+			return labels, str, fmt.Errorf("invalid label set: label value for %s must be quoted", name)
+		}
+		value, err := strconv.QuotedPrefix(str)
+		if err != nil {
+			return labels, str, fmt.Errorf("invalid label set: label value for %s: %s", name, err)
+		}
+		str = str[len(value):]
+		if labels[name], err = strconv.Unquote(value); err != nil {
+			return labels, str, fmt.Errorf("invalid label set: label value for %s: %s", name, err)
+		}
+	}
+}
+
+// parseExemplar parses the exemplar part of a sample line: `{labels} value [timestamp]`
+func parseExemplar(str string) (map[string]any, error) {
+	labels, rest, err := parseLabelSet(str)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid exemplar: %s", str)
+	}
+	exemplar := make(map[string]any)
+	exemplar["labels"] = labels
+	if exemplar["value"], err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return nil, fmt.Errorf("invalid exemplar value: %s", err)
+	}
+	if len(fields) == 2 {
+		if exemplar["timestamp"], err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid exemplar timestamp: %s", err)
+		}
+	}
+	return exemplar, nil
+}
+
+// parseSampleId returns the name and the labels of a sample line.
+func parseSampleId(sample string) (string, map[string]string, error) {
+	pos := strings.IndexAny(sample, "{ ")
+	if pos <= 0 {
+		return "", nil, fmt.Errorf("invalid sample: %s", sample)
+	}
+	name := sample[:pos]
+	if sample[pos] != '{' {
+		return name, map[string]string{}, nil
+	}
+	labels, _, err := parseLabelSet(sample[pos:])
+	return name, labels, err
+}
+
+// parseSampleValue returns the value of a sample line.
+func parseSampleValue(sample string) (float64, error) {
+	rest := sample
+	if pos := strings.LastIndex(sample, "}"); pos >= 0 {
+		rest = sample[pos+1:]
+	} else if pos := strings.Index(sample, " "); pos >= 0 {
+		rest = sample[pos:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid sample: %s", sample)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// sampleKey builds a unique key for a sample from its name and its labels;
+// "le" and "quantile" values are normalized so that they match the values built from decoded floats.
+func sampleKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var res strings.Builder
+	res.WriteString(name)
+	for _, key := range keys {
+		value := labels[key]
+		if key == "le" || key == "quantile" {
+			if f_value, err := strconv.ParseFloat(value, 64); err == nil {
+				value = fmt.Sprintf("%g", f_value)
+			}
+		}
+		res.WriteString(fmt.Sprintf("\xff%s=%s", key, value))
+	}
+	return res.String()
+}
@@ -3,10 +3,14 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"testing"
 
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestFuncParsePrometheusResponse(t *testing.T) {
@@ -70,3 +74,150 @@ func TestFuncParsePrometheusResponse(t *testing.T) {
 
 	}
 }
+
+func TestFuncParsePrometheusResponseExemplars(t *testing.T) {
+	file_content, err := os.ReadFile("fixtures/response_openmetrics.prom")
+	if err != nil {
+		t.Errorf(`ParsePrometheusResponse() load test results error: %s`, err.Error())
+		return
+	}
+	raw_data, err := ParsePrometheusResponse(file_content)
+	if err != nil {
+		t.Errorf(`ParsePrometheusResponse() parsing test results error: %s`, err.Error())
+		return
+	}
+	data, ok := raw_data.(map[string]any)
+	if !ok {
+		t.Errorf("ParsePrometheusResponse(): invalid content received")
+		return
+	}
+	assert.Equal(t, 3, len(data), "result doesn't contain 3 metrics")
+
+	// counter: family named as its samples, exemplar only on first metric, created timestamps of _created samples
+	counter := data["http_requests_total"].(map[string]any)
+	assert.Equal(t, "counter", counter["type"])
+	assert.Equal(t, "Total number of HTTP requests.", counter["help"])
+	metrics := counter["metrics"].([]map[string]any)
+	if assert.Equal(t, 2, len(metrics)) {
+		assert.Equal(t, 1699990000.5, metrics[0]["created"])
+		assert.Equal(t, 1699990000.5, metrics[1]["created"])
+		exemplar, ok := metrics[0]["exemplar"].(map[string]any)
+		if assert.True(t, ok, "exemplar not found for first counter") {
+			assert.Equal(t, map[string]string{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"}, exemplar["labels"])
+			assert.Equal(t, 1.0, exemplar["value"])
+			assert.Equal(t, 1700000000.123, exemplar["timestamp"])
+		}
+		_, ok = metrics[1]["exemplar"]
+		assert.False(t, ok, "unexpected exemplar for second counter")
+	}
+
+	// histogram: unit and exemplars on buckets
+	histogram := data["request_duration_seconds"].(map[string]any)
+	assert.Equal(t, "seconds", histogram["unit"])
+	metrics = histogram["metrics"].([]map[string]any)
+	if assert.Equal(t, 1, len(metrics)) {
+		assert.Equal(t, 1699990000.0, metrics[0]["created"])
+		buckets := metrics[0]["histogram"].(map[string]any)["buckets"].([]any)
+		if assert.Equal(t, 3, len(buckets)) {
+			exemplar, ok := buckets[1].(map[string]any)["exemplar"].(map[string]any)
+			if assert.True(t, ok, "exemplar not found for bucket 0.5") {
+				assert.Equal(t, map[string]string{"trace_id": "d4e5f6", "span_id": "0102"}, exemplar["labels"])
+				assert.Equal(t, 0.32, exemplar["value"])
+			}
+			_, ok = buckets[2].(map[string]any)["exemplar"]
+			assert.False(t, ok, "unexpected exemplar for bucket +Inf")
+		}
+	}
+
+	// '#' in a label value is not an exemplar
+	gauge := data["app_label_with_hash"].(map[string]any)
+	metrics = gauge["metrics"].([]map[string]any)
+	if assert.Equal(t, 1, len(metrics)) {
+		assert.Equal(t, map[string]string{"name": "a # {b}"}, metrics[0]["labels"])
+	}
+}
+
+func TestFuncParsePrometheusProtobufNativeHistogram(t *testing.T) {
+	// build a protobuf exposition with a native histogram
+	mf := &dto.MetricFamily{
+		Name: proto.String("request_duration_seconds"),
+		Help: proto.String("Duration of HTTP requests."),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{{Name: proto.String("method"), Value: proto.String("GET")}},
+			Histogram: &dto.Histogram{
+				SampleCount:   proto.Uint64(6),
+				SampleSum:     proto.Float64(1.5),
+				Schema:        proto.Int32(3),
+				ZeroThreshold: proto.Float64(1e-128),
+				ZeroCount:     proto.Uint64(1),
+				PositiveSpan: []*dto.BucketSpan{
+					{Offset: proto.Int32(-2), Length: proto.Uint32(2)},
+					{Offset: proto.Int32(3), Length: proto.Uint32(1)},
+				},
+				PositiveDelta: []int64{1, 1, -1},
+			},
+		}},
+	}
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	if err := enc.Encode(mf); err != nil {
+		t.Errorf(`ParsePrometheusProtobufResponse() encoding test data error: %s`, err.Error())
+		return
+	}
+
+	raw_data, err := ParsePrometheusProtobufResponse(buf.Bytes())
+	if err != nil {
+		t.Errorf(`ParsePrometheusProtobufResponse() parsing test results error: %s`, err.Error())
+		return
+	}
+	data, ok := raw_data.(map[string]any)
+	if !ok {
+		t.Errorf("ParsePrometheusProtobufResponse(): invalid content received")
+		return
+	}
+	family, ok := data["request_duration_seconds"].(map[string]any)
+	if !assert.True(t, ok, `metric "request_duration_seconds" not found`) {
+		return
+	}
+	metrics := family["metrics"].([]map[string]any)
+	if !assert.Equal(t, 1, len(metrics)) {
+		return
+	}
+	histogram := metrics[0]["histogram"].(map[string]any)
+	assert.Equal(t, int32(3), histogram["schema"])
+	assert.Equal(t, uint64(1), histogram["zero_count"])
+	assert.Equal(t, []int64{1, 1, -1}, histogram["positive_deltas"])
+	assert.Equal(t, 2, len(histogram["positive_spans"].([]any)))
+
+	// re-export the native histogram
+	mc := &MetricConfig{
+		Name:      "request_duration_seconds",
+		valueType: dto.MetricType_HISTOGRAM,
+		histogram: &EHistogram{Type: HistogramTypeExternal},
+	}
+	mf_desc, err := NewMetricFamily(nil, mc, nil, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	met, _ := NewHistogramMetric(mf_desc, []string{"method"}, []string{"GET"}, nil)
+	if err := met.SetValue(histogram); !assert.Nil(t, err) {
+		return
+	}
+	out := &dto.Metric{}
+	if err := met.Write(out); !assert.Nil(t, err) {
+		return
+	}
+	orig := mf.Metric[0].Histogram
+	assert.Equal(t, orig.GetSchema(), out.Histogram.GetSchema())
+	assert.Equal(t, orig.GetZeroThreshold(), out.Histogram.GetZeroThreshold())
+	assert.Equal(t, orig.GetZeroCount(), out.Histogram.GetZeroCount())
+	assert.Equal(t, orig.GetSampleCount(), out.Histogram.GetSampleCount())
+	assert.Equal(t, orig.PositiveDelta, out.Histogram.PositiveDelta)
+	if assert.Equal(t, len(orig.PositiveSpan), len(out.Histogram.PositiveSpan)) {
+		for i, span := range orig.PositiveSpan {
+			assert.Equal(t, span.GetOffset(), out.Histogram.PositiveSpan[i].GetOffset())
+			assert.Equal(t, span.GetLength(), out.Histogram.PositiveSpan[i].GetLength())
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
 
@@ -23,6 +24,7 @@ const (
 	contentEncodingHeader = "Content-Encoding"
 	acceptEncodingHeader  = "Accept-Encoding"
 	acceptHeader          = "Accept"
+	authKeyHeader         = "X-Auth-Key"
 	applicationJSON       = "application/json"
 	textHTML              = "text/html"
 	textPLAIN             = "text/plain"
@@ -76,28 +78,9 @@ func ExporterHandlerFor(exporter Exporter) http.Handler {
 		}
 
 		// set a specific collector_name for target
-		if len(params["collector"]) > 0 {
-			// to store anc check name uniqueness
-			collectors := make(map[string]*CollectorConfig, len(params["collector"]))
-			for _, collector_name := range params["collector"] {
-				if _, ok := collectors[collector_name]; !ok {
-					coll := exporter.Config().FindCollector(collector_name)
-					if coll != nil {
-						exporter.Config().logger.Debug(fmt.Sprintf("adding specific collector %s", collector_name),
-							"target", target.Name())
-						// target.SetSymbol("collector_name", collector_name)
-					} else {
-						err := fmt.Errorf("collector name '%s' not found", collector_name)
-						HandleError(http.StatusNotFound, err, *metricsPath, exporter, w, req)
-						return
-					}
-					collectors[collector_name] = coll
-				}
-			}
-			if err := target.SetSpecificCollectorConfig(collectors); err != nil {
-				HandleError(http.StatusNotFound, err, *metricsPath, exporter, w, req)
-				return
-			}
+		if err := setSpecificCollectors(exporter, target, params["collector"]); err != nil {
+			HandleError(http.StatusNotFound, err, *metricsPath, exporter, w, req)
+			return
 		}
 
 		// set authentication for target if one is specified and it differs from target internal
@@ -107,14 +90,26 @@ func ExporterHandlerFor(exporter Exporter) http.Handler {
 			if auth != nil {
 				target.Config().AuthConfig = *auth
 				target.SetSymbol("auth_mode", auth.Mode)
-				target.SetSymbol("user", auth.Username)
-				target.SetSymbol("password", string(auth.Password))
-				target.SetSymbol("auth_token", string(auth.Token))
+				user, password, token, err := auth.Values()
+				if err != nil {
+					exporter.Logger().Warn(
+						fmt.Sprintf("can't obtain credentials of auth_name '%s': %s", auth_name, err))
+				}
+				target.SetSymbol("user", user)
+				target.SetSymbol("password", password)
+				target.SetSymbol("auth_token", token)
+				if auth.authKey != "" {
+					target.SetSymbol("auth_key", auth.authKey)
+				}
 				target.Config().AuthName = auth_name
 			}
 		}
 
-		auth_key := params.Get("auth_key")
+		auth_key, err := authKeyFor(req, exporter.Config().Globals)
+		if err != nil {
+			HandleError(http.StatusBadRequest, err, *metricsPath, exporter, w, req)
+			return
+		}
 		if auth_key != "" {
 			target.SetSymbol("auth_key", auth_key)
 		}
@@ -130,8 +125,10 @@ func ExporterHandlerFor(exporter Exporter) http.Handler {
 		}()
 
 		// Go through prometheus.Gatherers to sanitize and sort metrics.
-		gatherer := prometheus.Gatherers{exporter.WithContext(ctx, target, health_only)}
+		units := &unitsGatherer{gatherer: exporter.WithContext(ctx, target, health_only)}
+		gatherer := prometheus.Gatherers{units}
 		mfs, err := gatherer.Gather()
+		units.restore(mfs)
 		if err != nil {
 			exporter.Logger().Error(
 				fmt.Sprintf("Error gathering metrics for '%s': %s", tName, err))
@@ -141,35 +138,126 @@ func ExporterHandlerFor(exporter Exporter) http.Handler {
 			}
 		}
 
-		contentType := expfmt.Negotiate(req.Header)
-		buf := getBuf()
-		defer giveBuf(buf)
-		writer, encoding := decorateWriter(req, buf)
-		enc := expfmt.NewEncoder(writer, contentType)
-		var errs prometheus.MultiError
-		for _, mf := range mfs {
-			if err := enc.Encode(mf); err != nil {
-				errs = append(errs, err)
-				exporter.Logger().Info(
-					fmt.Sprintf("Error encoding metric family %q: %s", mf.GetName(), err.Error()))
-			}
+		writeMetricFamilies(w, req, exporter, mfs)
+	})
+}
+
+// writeMetricFamilies encodes mfs in the format negotiated with the scrape request.
+func writeMetricFamilies(w http.ResponseWriter, req *http.Request, exporter Exporter, mfs []*dto.MetricFamily) {
+	contentType := expfmt.NegotiateIncludingOpenMetrics(req.Header)
+	buf := getBuf()
+	defer giveBuf(buf)
+	writer, encoding := decorateWriter(req, buf)
+	var opts []expfmt.EncoderOption
+	if contentType.FormatType() == expfmt.TypeOpenMetrics {
+		// expose created timestamps of counters and histograms
+		opts = append(opts, expfmt.WithCreatedLines())
+	}
+	enc := expfmt.NewEncoder(writer, contentType, opts...)
+	var errs prometheus.MultiError
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			errs = append(errs, err)
+			exporter.Logger().Info(
+				fmt.Sprintf("Error encoding metric family %q: %s", mf.GetName(), err.Error()))
 		}
-		if closer, ok := writer.(io.Closer); ok {
-			closer.Close()
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		// This in particular takes care of the final "# EOF\n" line for OpenMetrics.
+		closer.Close()
+	}
+	if closer, ok := writer.(io.Closer); ok {
+		closer.Close()
+	}
+	if errs.MaybeUnwrap() != nil && buf.Len() == 0 {
+		err := fmt.Errorf("no metrics encoded: %s, ", errs.Error())
+		HandleError(http.StatusInternalServerError, err, *metricsPath, exporter, w, req)
+		return
+	}
+	header := w.Header()
+	header.Set(contentTypeHeader, string(contentType))
+	header.Set(contentLengthHeader, fmt.Sprint(buf.Len()))
+	if encoding != "" {
+		header.Set(contentEncodingHeader, encoding)
+	}
+	w.Write(buf.Bytes())
+}
+
+// setSpecificCollectors restricts the next collect of target to the collectors names, if any.
+func setSpecificCollectors(exporter Exporter, target Target, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	// to store anc check name uniqueness
+	collectors := make(map[string]*CollectorConfig, len(names))
+	for _, collector_name := range names {
+		if _, ok := collectors[collector_name]; !ok {
+			// collectors of the target first: they may be instances with params.
+			var coll *CollectorConfig
+			for _, c := range target.Config().Collectors() {
+				if c.Name == collector_name {
+					coll = c
+					break
+				}
+			}
+			if coll == nil {
+				coll = exporter.Config().FindCollector(collector_name)
+			}
+			if coll != nil {
+				exporter.Config().logger.Debug(fmt.Sprintf("adding specific collector %s", collector_name),
+					"target", target.Name())
+				// target.SetSymbol("collector_name", collector_name)
+			} else {
+				return fmt.Errorf("collector name '%s' not found", collector_name)
+			}
+			collectors[collector_name] = coll
 		}
-		if errs.MaybeUnwrap() != nil && buf.Len() == 0 {
-			err = fmt.Errorf("no metrics encoded: %s, ", errs.Error())
-			HandleError(http.StatusInternalServerError, err, *metricsPath, exporter, w, req)
-			return
+	}
+	return target.SetSpecificCollectorConfig(collectors)
+}
+
+// authKeyFor returns the auth_key sent with the scrape request: from the auth_key header, else from the auth_key query
+// parameter if it is not disabled.
+func authKeyFor(req *http.Request, globals *GlobalConfig) (string, error) {
+	header := globals.AuthKeyHeader
+	if header == "" {
+		header = authKeyHeader
+	}
+	if auth_key := req.Header.Get(header); auth_key != "" {
+		return auth_key, nil
+	}
+	auth_key := req.URL.Query().Get("auth_key")
+	if auth_key != "" && globals.DisableAuthKeyParam {
+		return "", fmt.Errorf("auth_key query parameter is disabled: use '%s' header", header)
+	}
+	return auth_key, nil
+}
+
+// unitsGatherer keeps the units of the metric families, because prometheus.Gatherers drops them when merging.
+type unitsGatherer struct {
+	gatherer prometheus.Gatherer
+	units    map[string]*string
+}
+
+// Gather implements prometheus.Gatherer.
+func (u *unitsGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := u.gatherer.Gather()
+	u.units = make(map[string]*string)
+	for _, mf := range mfs {
+		if mf.Unit != nil {
+			u.units[mf.GetName()] = mf.Unit
 		}
-		header := w.Header()
-		header.Set(contentTypeHeader, string(contentType))
-		header.Set(contentLengthHeader, fmt.Sprint(buf.Len()))
-		if encoding != "" {
-			header.Set(contentEncodingHeader, encoding)
+	}
+	return mfs, err
+}
+
+// restore sets the units back to the metric families.
+func (u *unitsGatherer) restore(mfs []*dto.MetricFamily) {
+	for _, mf := range mfs {
+		if unit, ok := u.units[mf.GetName()]; ok {
+			mf.Unit = unit
 		}
-		w.Write(buf.Bytes())
-	})
+	}
 }
 
 func contextFor(req *http.Request, exporter Exporter, target Target) (context.Context, context.CancelFunc) {
@@ -19,17 +19,18 @@ import (
 // ***************************************************************************************
 // ***************************************************************************************
 type QueryActionConfig struct {
-	Query      string             `yaml:"url" json:"url"`
-	Method     string             `yaml:"method,omitempty" json:"method,omitempty"`
-	Data       string             `yaml:"data,omitempty" json:"data,omitempty"`
-	Debug      ConvertibleBoolean `yaml:"debug,omitempty" json:"debug,omitempty"`
-	VarName    string             `yaml:"var_name,omitempty" json:"var_name,omitempty"`
-	OkStatus   any                `yaml:"ok_status,omitempty" json:"ok_status,omitempty"`
-	AuthConfig *AuthConfig        `yaml:"auth_config,omitempty" json:"auth_config,omitempty"`
-	Timeout    int                `yaml:"timeout,omitempty" json:"timeout,omitempty"`
-	Parser     string             `yaml:"parser,omitempty" json:"parser,omitempty"`
-	Trace      ConvertibleBoolean `yaml:"trace,omitempty" json:"trace,omitempty"`
-	Status     ConvertibleBoolean `yaml:"status,omitempty" json:"status,omitempty"`
+	Query       string             `yaml:"url" json:"url"`
+	Method      string             `yaml:"method,omitempty" json:"method,omitempty"`
+	Data        string             `yaml:"data,omitempty" json:"data,omitempty"`
+	Debug       ConvertibleBoolean `yaml:"debug,omitempty" json:"debug,omitempty"`
+	VarName     string             `yaml:"var_name,omitempty" json:"var_name,omitempty"`
+	OkStatus    any                `yaml:"ok_status,omitempty" json:"ok_status,omitempty"`
+	AuthConfig  *AuthConfig        `yaml:"auth_config,omitempty" json:"auth_config,omitempty"`
+	Timeout     int                `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Parser      string             `yaml:"parser,omitempty" json:"parser,omitempty"`
+	Trace       ConvertibleBoolean `yaml:"trace,omitempty" json:"trace,omitempty"`
+	Status      ConvertibleBoolean `yaml:"status,omitempty" json:"status,omitempty"`
+	StatusLabel string             `yaml:"status_label,omitempty" json:"status_label,omitempty"`
 
 	registry *goja_modules.JSRegistry
 
@@ -37,6 +38,8 @@ type QueryActionConfig struct {
 	method   *Field
 	data     *Field
 	var_name *Field
+	// label for query_status metric
+	status_label *Field
 
 	auth_mode *Field
 	user      *Field
@@ -86,6 +89,13 @@ func (qc *QueryActionConfig) UnmarshalYAML(unmarshal func(interface{}) error) er
 		return fmt.Errorf("invalid template for var_name %q: %s", qc.VarName, err)
 	}
 
+	if qc.StatusLabel != "" {
+		qc.status_label, err = NewField(qc.StatusLabel, nil, qc.registry)
+		if err != nil {
+			return fmt.Errorf("invalid template for status_label %q: %s", qc.StatusLabel, err)
+		}
+	}
+
 	if qc.OkStatus != nil {
 		qc.ok_status = buildStatus(qc.OkStatus)
 	}
@@ -173,6 +183,7 @@ type QueryAction struct {
 	Query   *QueryActionConfig `yaml:"query" json:"query"`
 
 	vars [][]any
+	pos  SourcePos
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline" json:"-"`
@@ -241,6 +252,13 @@ func (a *QueryAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *QueryAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *QueryAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 func (a *QueryAction) setBasicElement(
 	registry *goja_modules.JSRegistry,
 	nameField *Field,
@@ -422,6 +440,19 @@ func (a *QueryAction) CustomAction(script *YAMLScript, symtab map[string]any, lo
 			"name", a.GetName(symtab, logger))
 	}
 
+	var status_label string
+	if a.Query.status_label != nil {
+		status_label, err = a.Query.status_label.GetValueString(symtab, logger)
+		if err != nil {
+			status_label = ""
+			logger.Warn(
+				fmt.Sprintf("invalid template for status_label '%s': %v", a.Query.StatusLabel, err),
+				"coll", CollectorId(symtab, logger),
+				"script", ScriptName(symtab, logger),
+				"name", a.GetName(symtab, logger))
+		}
+	}
+
 	params := &CallClientExecuteParams{
 		Payload:  payload,
 		Method:   method,
@@ -437,6 +468,8 @@ func (a *QueryAction) CustomAction(script *YAMLScript, symtab map[string]any, lo
 		Token:    auth_token,
 		Timeout:  time.Duration(a.Query.Timeout) * time.Second,
 		Parser:   a.Query.Parser,
+
+		StatusLabel: status_label,
 	}
 
 	logger.Debug(
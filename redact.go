@@ -0,0 +1,289 @@
+// cSpell:ignore symtab, passw, promslog
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/promslog"
+)
+
+// replacement of secret values in logs and debug outputs
+const redactedValue = "<redacted>"
+
+// patterns of the names of symbols, log attributes and headers whose values are always redacted.
+var defaultRedactKeys = []string{
+	"authorization",
+	"cookie",
+	"passw",
+	"token",
+	"auth_key",
+	"secret",
+	"api[_-]?key",
+	"session",
+	"ciphertext",
+}
+
+// secret values shorter than this are not replaced in free text: it would mangle too many messages.
+const minSecretLength = 4
+
+// redactor is the redaction layer used by loggers, debug action and debug scrape.
+var redactor = NewRedactor()
+
+// Redactor replaces secret values by redactedValue. Secrets are found by the name of their key (symbol, header,
+// log attribute, "key=value" or "key: value" in free text) and by their known literal values.
+type Redactor struct {
+	mutex sync.RWMutex
+	// matches secret key names
+	keys *regexp.Regexp
+	// matches "key=value" and "key: value" in free text
+	pairs *regexp.Regexp
+	// known secret values: passwords, tokens...
+	secrets map[string]struct{}
+}
+
+// matches the credentials of authorization schemes in free text
+var authSchemeRE = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`)
+
+// NewRedactor returns a redactor with default key patterns.
+func NewRedactor() *Redactor {
+	r := &Redactor{
+		secrets: make(map[string]struct{}),
+	}
+	// default patterns are always valid
+	_ = r.SetKeys(nil)
+	return r
+}
+
+// SetKeys sets the patterns of secret key names; they are added to the default ones.
+func (r *Redactor) SetKeys(patterns []string) error {
+	all := make([]string, 0, len(defaultRedactKeys)+len(patterns))
+	all = append(all, defaultRedactKeys...)
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid redact key pattern '%s': %s", pattern, err)
+		}
+		all = append(all, pattern)
+	}
+	keys := strings.Join(all, "|")
+	key_re := regexp.MustCompile(`(?i)` + keys)
+	pairs_re := regexp.MustCompile(`(?i)([\w.-]*(?:` + keys + `)[\w.-]*)(["']?\s*[:=]\s*["'\[]?\s*)(?:(?:bearer|basic)\s+)?([^\s"'&,;\]})]+)`)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.keys = key_re
+	r.pairs = pairs_re
+	return nil
+}
+
+// AddSecrets records values that must never be displayed.
+func (r *Redactor) AddSecrets(values ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, value := range values {
+		if len(value) >= minSecretLength {
+			r.secrets[value] = struct{}{}
+		}
+	}
+}
+
+// IsSecretKey returns true if values of key name are secrets.
+func (r *Redactor) IsSecretKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.keys.MatchString(key)
+}
+
+// String returns s with secrets replaced.
+func (r *Redactor) String(s string) string {
+	if s == "" {
+		return s
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	s = r.pairs.ReplaceAllString(s, "${1}${2}"+redactedValue)
+	return authSchemeRE.ReplaceAllString(s, "${1} "+redactedValue)
+}
+
+// StringWithSymbols returns s with secrets replaced, including the values of the secret symbols of symtab;
+// s is usually the result of a template evaluated with symtab.
+func (r *Redactor) StringWithSymbols(s string, symtab map[string]any) string {
+	for key, value := range symtab {
+		if str, ok := value.(string); ok && len(str) >= minSecretLength && r.IsSecretKey(key) {
+			s = strings.ReplaceAll(s, str, redactedValue)
+		}
+	}
+	return r.String(s)
+}
+
+// Value returns value with secrets replaced; key is the name of the value in its parent.
+func (r *Redactor) Value(key string, value any) any {
+	if r.IsSecretKey(key) {
+		if value == nil || value == "" {
+			return value
+		}
+		return redactedValue
+	}
+	switch val := value.(type) {
+	case string:
+		return r.String(val)
+	case map[string]any:
+		res := make(map[string]any, len(val))
+		for k, v := range val {
+			res[k] = r.Value(k, v)
+		}
+		return res
+	case []any:
+		res := make([]any, len(val))
+		for idx, v := range val {
+			res[idx] = r.Value("", v)
+		}
+		return res
+	}
+	return value
+}
+
+// Headers returns a copy of headers with secret values replaced.
+func (r *Redactor) Headers(headers http.Header) map[string][]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	res := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		if r.IsSecretKey(name) {
+			res[name] = []string{redactedValue}
+		} else {
+			res[name] = values
+		}
+	}
+	return res
+}
+
+// Attr returns the log attribute with secrets replaced.
+func (r *Redactor) Attr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if r.IsSecretKey(attr.Key) && attr.Value.Kind() != slog.KindGroup {
+		if attr.Value.Kind() == slog.KindString && attr.Value.String() == "" {
+			return attr
+		}
+		return slog.String(attr.Key, redactedValue)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(r.String(attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		attrs := make([]slog.Attr, len(group))
+		for idx, a := range group {
+			attrs[idx] = r.Attr(a)
+		}
+		attr.Value = slog.GroupValue(attrs...)
+	case slog.KindAny:
+		switch val := attr.Value.Any().(type) {
+		case error:
+			attr.Value = slog.StringValue(r.String(val.Error()))
+		case map[string]any, []any:
+			attr.Value = slog.AnyValue(r.Value("", val))
+		default:
+			attr.Value = slog.StringValue(r.String(fmt.Sprintf("%+v", val)))
+		}
+	}
+	return attr
+}
+
+// redactHandler is a slog.Handler that replaces secrets in messages and attributes before passing records to
+// handler.
+type redactHandler struct {
+	handler  slog.Handler
+	redactor *Redactor
+	// values of secret attributes set with WithAttrs(): they are also replaced in messages
+	secrets []string
+}
+
+// NewRedactHandler returns a handler that redacts secrets of records before passing them to handler.
+func NewRedactHandler(handler slog.Handler, redactor *Redactor) slog.Handler {
+	return &redactHandler{
+		handler:  handler,
+		redactor: redactor,
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	secrets := h.secrets
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		secrets = h.redactor.appendSecretValues(secrets, attr)
+		attrs = append(attrs, h.redactor.Attr(attr))
+		return true
+	})
+	msg := record.Message
+	for _, secret := range secrets {
+		msg = strings.ReplaceAll(msg, secret, redactedValue)
+	}
+	res := slog.NewRecord(record.Time, record.Level, h.redactor.String(msg), record.PC)
+	res.AddAttrs(attrs...)
+	return h.handler.Handle(ctx, res)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	secrets := h.secrets
+	res := make([]slog.Attr, len(attrs))
+	for idx, attr := range attrs {
+		secrets = h.redactor.appendSecretValues(secrets, attr)
+		res[idx] = h.redactor.Attr(attr)
+	}
+	return &redactHandler{
+		handler:  h.handler.WithAttrs(res),
+		redactor: h.redactor,
+		secrets:  secrets,
+	}
+}
+
+// WithGroup implements slog.Handler.
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{
+		handler:  h.handler.WithGroup(name),
+		redactor: h.redactor,
+		secrets:  h.secrets,
+	}
+}
+
+// appendSecretValues appends to secrets the string value of attr if its key is a secret one.
+func (r *Redactor) appendSecretValues(secrets []string, attr slog.Attr) []string {
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, a := range attr.Value.Group() {
+			secrets = r.appendSecretValues(secrets, a)
+		}
+		return secrets
+	}
+	if r.IsSecretKey(attr.Key) {
+		if value := attr.Value.Resolve().String(); len(value) >= minSecretLength {
+			secrets = append(secrets[:len(secrets):len(secrets)], value)
+		}
+	}
+	return secrets
+}
+
+// NewLogger returns a promslog logger whose records are redacted.
+func NewLogger(config *promslog.Config) *slog.Logger {
+	return slog.New(NewRedactHandler(promslog.New(config).Handler(), redactor))
+}
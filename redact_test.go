@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactString(t *testing.T) {
+	r := NewRedactor()
+	if err := r.SetKeys([]string{"x-private"}); !assert.Nil(t, err) {
+		return
+	}
+	r.AddSecrets("s3cr3t-value", "ab")
+
+	tests := map[string]string{
+		"password=foo&user=bar":                          "password=<redacted>&user=bar",
+		`{"auth_token": "abc.def", "name": "test"}`:      `{"auth_token": "<redacted>", "name": "test"}`,
+		"map[Authorization:[Bearer abcdefghijkl] Accept": "map[Authorization:[<redacted>] Accept",
+		"header X-Private: value":                        "header X-Private: <redacted>",
+		"sending Bearer abcdefghijkl to host":            "sending Bearer <redacted> to host",
+		"login with s3cr3t-value":                        "login with <redacted>",
+		// too short secrets are not replaced in free text
+		"about": "about",
+	}
+	for in, expected := range tests {
+		assert.Equal(t, expected, r.String(in), in)
+	}
+
+	assert.NotNil(t, r.SetKeys([]string{"("}))
+}
+
+func TestRedactHandler(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRedactor()
+	logger := slog.New(NewRedactHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), r))
+
+	logger.With("auth_key", "my-passphrase").Debug(
+		"auth_key detected: my-passphrase",
+		"ciphertext", "0123456789abcdef",
+		"user", "admin",
+		"headers", map[string]any{"Cookie": "session=1234", "Accept": "*/*"},
+		"errmsg", errors.New("invalid token=abcd1234"),
+		slog.Group("request", "password", "pass1234"))
+
+	out := buf.String()
+	for _, secret := range []string{"my-passphrase", "0123456789abcdef", "1234", "abcd1234", "pass1234"} {
+		assert.False(t, strings.Contains(out, secret), "secret %q found in %s", secret, out)
+	}
+	assert.Contains(t, out, `"user":"admin"`)
+	assert.Contains(t, out, `"Accept":"*/*"`)
+}
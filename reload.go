@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigDiff is the result of a configuration reload: the changes between current and new configurations, and the
+// validation errors of the new one.
+type ConfigDiff struct {
+	Applied           bool     `json:"applied"` // new configuration replaced the current one
+	TargetsAdded      []string `json:"targets_added"`
+	TargetsRemoved    []string `json:"targets_removed"`
+	TargetsChanged    []string `json:"targets_changed"`
+	TargetsUnchanged  []string `json:"targets_unchanged"` // their sessions are kept
+	CollectorsAdded   []string `json:"collectors_added"`
+	CollectorsRemoved []string `json:"collectors_removed"`
+	CollectorsChanged []string `json:"collectors_changed"`
+	Errors            []string `json:"errors,omitempty"`
+}
+
+// Error returns the validation errors of the new configuration as an error; nil if it is valid.
+func (d *ConfigDiff) Error() error {
+	if len(d.Errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: %s", strings.Join(d.Errors, "; "))
+}
+
+// String returns a summary of the changes for logs.
+func (d *ConfigDiff) String() string {
+	return fmt.Sprintf("targets: %d added, %d removed, %d changed, %d unchanged; collectors: %d added, %d removed, %d changed",
+		len(d.TargetsAdded), len(d.TargetsRemoved), len(d.TargetsChanged), len(d.TargetsUnchanged),
+		len(d.CollectorsAdded), len(d.CollectorsRemoved), len(d.CollectorsChanged))
+}
+
+// fingerprint returns a hash of the yaml representation of values.
+func fingerprint(values ...any) string {
+	h := sha256.New()
+	for _, value := range values {
+		buf, err := yaml.Marshal(value)
+		if err != nil {
+			// not comparable: always considered as changed.
+			buf = []byte(fmt.Sprintf("%p", value))
+		}
+		h.Write(buf)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// collectorFingerprint returns the hash of the definition of collector.
+func collectorFingerprint(coll *CollectorConfig) string {
+	return fingerprint(GetCollectorsDef([]*CollectorConfig{coll})[0])
+}
+
+// targetFingerprint returns the hash of all the definitions a target is built from: its config, including secrets
+// masked when marshaled, its profile, its collectors and the global config.
+func targetFingerprint(t *TargetConfig, globals *GlobalConfig) string {
+	values := []any{
+		t,
+		[]string{t.AuthConfig.Username, string(t.AuthConfig.Password), string(t.AuthConfig.Token), t.AuthConfig.authKey},
+		globals,
+	}
+	if t.profile != nil {
+		values = append(values, &DumpProfile{
+			MetricPrefix: t.profile.MetricPrefix,
+			Scripts:      GetScriptsDef(t.profile.Scripts),
+		})
+	}
+	for _, coll := range t.collectors {
+		values = append(values, GetCollectorsDef([]*CollectorConfig{coll})[0])
+	}
+	return fingerprint(values...)
+}
+
+// diffConfigs returns the changes of targets and collectors between cur and next configurations.
+func diffConfigs(cur, next *Config) *ConfigDiff {
+	diff := &ConfigDiff{}
+
+	cur_targets := make(map[string]string, len(cur.Targets))
+	for _, t := range cur.Targets {
+		cur_targets[t.Name] = targetFingerprint(t, cur.Globals)
+	}
+	for _, t := range next.Targets {
+		fp, found := cur_targets[t.Name]
+		switch {
+		case !found:
+			diff.TargetsAdded = append(diff.TargetsAdded, t.Name)
+		case fp != targetFingerprint(t, next.Globals):
+			diff.TargetsChanged = append(diff.TargetsChanged, t.Name)
+		default:
+			diff.TargetsUnchanged = append(diff.TargetsUnchanged, t.Name)
+		}
+		delete(cur_targets, t.Name)
+	}
+	for name := range cur_targets {
+		diff.TargetsRemoved = append(diff.TargetsRemoved, name)
+	}
+
+	cur_colls := make(map[string]string, len(cur.collectors))
+	for name, coll := range cur.collectors {
+		cur_colls[name] = collectorFingerprint(coll)
+	}
+	for name, coll := range next.collectors {
+		fp, found := cur_colls[name]
+		if !found {
+			diff.CollectorsAdded = append(diff.CollectorsAdded, name)
+		} else if fp != collectorFingerprint(coll) {
+			diff.CollectorsChanged = append(diff.CollectorsChanged, name)
+		}
+		delete(cur_colls, name)
+	}
+	for name := range cur_colls {
+		diff.CollectorsRemoved = append(diff.CollectorsRemoved, name)
+	}
+
+	for _, list := range [][]string{
+		diff.TargetsAdded, diff.TargetsRemoved, diff.TargetsChanged, diff.TargetsUnchanged,
+		diff.CollectorsAdded, diff.CollectorsRemoved, diff.CollectorsChanged,
+	} {
+		slices.Sort(list)
+	}
+	return diff
+}
+
+// validateConfig returns the semantic errors of config not detected by parsing: the scripts required to collect
+// the targets must be defined in their profiles. Unknown collectors, profiles and scripts of play_script actions are
+// reported by parsing. The other checks of the lint command (variables never set, scripts never played...) are
+// warnings that don't prevent the reload; the behaviour of the scripts against the targets is only checked with
+// `check=health`.
+func validateConfig(c *Config) []string {
+	var errs []string
+	checked := make(map[string]bool)
+	for _, t := range c.Targets {
+		if t.profile == nil || checked[t.ProfileName] {
+			continue
+		}
+		checked[t.ProfileName] = true
+		if script, ok := t.profile.Scripts["ping"]; !ok || script == nil {
+			errs = append(errs, fmt.Sprintf("profile '%s' used by target '%s' has no ping script", t.ProfileName, t.Name))
+		}
+	}
+	return errs
+}
+
+// checkTargetsHealth plays a health check (ping and login if required) on targets, in parallel, and returns
+// the errors of targets that are not up.
+func checkTargetsHealth(targets []Target) []string {
+	var (
+		errs  []string
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			if err := checkTargetHealth(t); err != nil {
+				mutex.Lock()
+				errs = append(errs, fmt.Sprintf("target '%s': %s", t.Name(), err))
+				mutex.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	slices.Sort(errs)
+	return errs
+}
+
+// checkTargetHealth returns an error if the up metric of target is not 1 after a health only collect.
+func checkTargetHealth(t Target) error {
+	timeout := time.Duration(t.Config().ScrapeTimeout)
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ch := make(chan Metric, capMetricChan)
+	go func() {
+		defer close(ch)
+		t.Collect(ctx, ch, true)
+	}()
+	up := -1.0
+	for metric := range ch {
+		if !strings.HasSuffix(metric.Desc().Name(), "_"+upMetricName) {
+			continue
+		}
+		m := &dto.Metric{}
+		if err := metric.Write(m); err == nil && m.Gauge != nil {
+			up = m.Gauge.GetValue()
+		}
+	}
+	switch up {
+	case 1:
+		return nil
+	case -1:
+		return errors.New("no up metric collected")
+	}
+	return errors.New("health check failed: target is down")
+}
+
+// MarshalJSON implements json.Marshaler: empty lists are output as [] instead of null.
+func (d *ConfigDiff) MarshalJSON() ([]byte, error) {
+	type plain ConfigDiff
+	res := *d
+	for _, list := range []*[]string{
+		&res.TargetsAdded, &res.TargetsRemoved, &res.TargetsChanged, &res.TargetsUnchanged,
+		&res.CollectorsAdded, &res.CollectorsRemoved, &res.CollectorsChanged,
+	} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return json.Marshal((*plain)(&res))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyDir copies the files of src tree into dst.
+func copyDir(t *testing.T, src, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o700)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), content, 0o600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	dir := t.TempDir()
+	copyDir(t, "contribs/apache/etc/apache", dir)
+	target_file := func(name, host string) {
+		content := "name: " + name + "\nhost: " + host + "\nprofile: apache\ncollectors:\n  - ~ apache_.*\n"
+		if err := os.WriteFile(filepath.Join(dir, "targets", name+".yml"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target_file("web1", "1.2.3.4")
+	target_file("web2", "1.2.3.5")
+
+	e, err := NewExporter(filepath.Join(dir, "config.yml"), "", logger, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	web1, _ := e.FindTarget("web1")
+
+	// web2 changed, web3 added
+	target_file("web2", "1.2.3.6")
+	target_file("web3", "1.2.3.7")
+	diff, err := e.ReloadConfig(false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, diff.Applied)
+	assert.Equal(t, []string{"web3"}, diff.TargetsAdded)
+	assert.Equal(t, []string{"web2"}, diff.TargetsChanged)
+	assert.Equal(t, []string{"default", "web1"}, diff.TargetsUnchanged)
+	assert.Empty(t, diff.CollectorsChanged)
+	// session of unchanged target is kept
+	t1, _ := e.FindTarget("web1")
+	assert.Same(t, web1, t1)
+
+	// web3 removed, collector changed: all targets using it are changed
+	os.Remove(filepath.Join(dir, "targets", "web3.yml"))
+	coll_file := filepath.Join(dir, "metrics", "apache_status.collector.yml")
+	content, _ := os.ReadFile(coll_file)
+	os.WriteFile(coll_file, []byte(strings.Replace(string(content), "metric_prefix: ", "metric_prefix: new_", 1)), 0o600)
+	diff, err = e.ReloadConfig(false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"web3"}, diff.TargetsRemoved)
+	assert.Equal(t, []string{"apache_status"}, diff.CollectorsChanged)
+	assert.Equal(t, []string{"default", "web1", "web2"}, diff.TargetsChanged)
+
+	// invalid profile: current config, redact keys and secrets settings are kept
+	cur := e.Config()
+	ttl := currentSecretsConfig().TTL
+	conf_file := filepath.Join(dir, "config.yml")
+	conf_content, _ := os.ReadFile(conf_file)
+	os.WriteFile(conf_file, []byte(strings.Replace(string(conf_content), "global:\n", "global:\n  redact_keys: [ x-private ]\n  secrets:\n    ttl: 42s\n", 1)), 0o600)
+	prof_file := filepath.Join(dir, "profiles", "apache_profile.yml")
+	content, _ = os.ReadFile(prof_file)
+	os.WriteFile(prof_file, []byte(strings.Replace(string(content), "      ping:", "      ping_off:", 1)), 0o600)
+	diff, err = e.ReloadConfig(false)
+	assert.Error(t, err)
+	if assert.NotNil(t, diff) {
+		assert.False(t, diff.Applied)
+		assert.Contains(t, diff.Errors[0], "has no ping script")
+	}
+	assert.Same(t, cur, e.Config())
+	assert.False(t, redactor.IsSecretKey("x-private"))
+	assert.Equal(t, ttl, currentSecretsConfig().TTL)
+	os.WriteFile(conf_file, conf_content, 0o600)
+
+	// play_script in a collector: current config is kept
+	os.WriteFile(prof_file, content, 0o600)
+	content, _ = os.ReadFile(coll_file)
+	os.WriteFile(coll_file, []byte(strings.Replace(string(content), "  analyze_status:\n", "  analyze_status:\n    - name: play init\n      play_script: init\n", 1)), 0o600)
+	_, err = e.ReloadConfig(false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "play_script 'init' is only allowed in profiles")
+	}
+	assert.Same(t, cur, e.Config())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// requestLimiter bounds the number of concurrent requests and their rate; a zero limit is not enforced.
+type requestLimiter struct {
+	maxConcurrent int
+	rate          float64
+
+	sem     chan struct{}
+	limiter *rate.Limiter
+}
+
+func newRequestLimiter(maxConcurrent int, rps float64) *requestLimiter {
+	l := &requestLimiter{
+		maxConcurrent: maxConcurrent,
+		rate:          rps,
+	}
+	if maxConcurrent > 0 {
+		l.sem = make(chan struct{}, maxConcurrent)
+	}
+	if rps > 0 {
+		l.limiter = rate.NewLimiter(rate.Limit(rps), 1)
+	}
+	return l
+}
+
+// acquire waits for a request slot, until ctx is done. The slot must be released after the request.
+// The rate reservation of a request that doesn't wait until its turn is given back.
+func (l *requestLimiter) acquire(ctx context.Context) error {
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if l.limiter != nil {
+		if err := l.limiter.Wait(ctx); err != nil {
+			l.release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *requestLimiter) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// limiterRegistry keeps the limiters shared by all clients: the global one, one per host and one per target.
+type limiterRegistry struct {
+	mutex    sync.Mutex
+	limiters map[string]*requestLimiter
+}
+
+var requestLimiters = &limiterRegistry{limiters: make(map[string]*requestLimiter)}
+
+// get returns the limiter of key; it is replaced if its limits have changed (config reload). Returns nil if there is
+// no limit.
+func (r *limiterRegistry) get(key string, maxConcurrent int, rps float64) *requestLimiter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if maxConcurrent <= 0 && rps <= 0 {
+		delete(r.limiters, key)
+		return nil
+	}
+	l, ok := r.limiters[key]
+	if !ok || l.maxConcurrent != maxConcurrent || l.rate != rps {
+		l = newRequestLimiter(maxConcurrent, rps)
+		r.limiters[key] = l
+	}
+	return l
+}
+
+// requestLimits are the limits of the requests sent by the client of a target, from global and target configs.
+type requestLimits struct {
+	maxConcurrent        int
+	maxConcurrentPerHost int
+	ratePerHost          float64
+
+	target              string
+	maxConcurrentTarget int
+	rateTarget          float64
+}
+
+func newRequestLimits(gc *GlobalConfig, target *TargetConfig) *requestLimits {
+	return &requestLimits{
+		maxConcurrent:        gc.MaxConcurrentRequests,
+		maxConcurrentPerHost: gc.MaxConcurrentRequestsPerHost,
+		ratePerHost:          gc.RequestsPerSecondPerHost,
+		target:               target.Name,
+		maxConcurrentTarget:  target.MaxConcurrentRequests,
+		rateTarget:           target.RequestsPerSecond,
+	}
+}
+
+// forTarget returns a copy of the limits with the ones of target.
+func (l *requestLimits) forTarget(target *TargetConfig) *requestLimits {
+	if l == nil {
+		return nil
+	}
+	res := *l
+	res.target = target.Name
+	res.maxConcurrentTarget = target.MaxConcurrentRequests
+	res.rateTarget = target.RequestsPerSecond
+	return &res
+}
+
+// acquire waits for a slot to send a request to uri: the target limits replace the per host ones, and the global
+// limit applies to all requests. The most specific limiters are acquired first, so that a request waiting for its
+// host doesn't hold a global slot. Returns the function to release the slots.
+func (l *requestLimits) acquire(ctx context.Context, uri string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	var limiters []*requestLimiter
+	if l.maxConcurrentTarget > 0 || l.rateTarget > 0 {
+		limiters = append(limiters, requestLimiters.get("target:"+l.target, l.maxConcurrentTarget, l.rateTarget))
+	} else if u, err := url.Parse(uri); err == nil && u.Host != "" {
+		if hl := requestLimiters.get("host:"+u.Host, l.maxConcurrentPerHost, l.ratePerHost); hl != nil {
+			limiters = append(limiters, hl)
+		}
+	}
+	if gl := requestLimiters.get("global", l.maxConcurrent, 0); gl != nil {
+		limiters = append(limiters, gl)
+	}
+
+	release := func(acquired []*requestLimiter) {
+		for idx := len(acquired) - 1; idx >= 0; idx-- {
+			acquired[idx].release()
+		}
+	}
+	for idx, limiter := range limiters {
+		if err := limiter.acquire(ctx); err != nil {
+			release(limiters[:idx])
+			return nil, fmt.Errorf("waiting for request slot: %w", err)
+		}
+	}
+	return func() { release(limiters) }, nil
+}
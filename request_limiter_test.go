@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLimiterConcurrency(t *testing.T) {
+	l := newRequestLimiter(2, 0)
+	var running, max_running atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !assert.NoError(t, l.acquire(context.Background())) {
+				return
+			}
+			defer l.release()
+			cur := running.Add(1)
+			for {
+				prev := max_running.Load()
+				if cur <= prev || max_running.CompareAndSwap(prev, cur) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), max_running.Load())
+
+	// no slot available: wait ends with context
+	l.acquire(context.Background())
+	l.acquire(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+}
+
+func TestRequestLimiterRate(t *testing.T) {
+	l := newRequestLimiter(0, 50)
+	start := time.Now()
+	for range 5 {
+		assert.NoError(t, l.acquire(context.Background()))
+		l.release()
+	}
+	// first request is immediate, next ones are 20ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
+
+func TestRequestLimiterRateCancel(t *testing.T) {
+	l := newRequestLimiter(1, 10)
+	start := time.Now()
+	assert.NoError(t, l.acquire(context.Background()))
+	l.release()
+
+	// request cancelled while waiting for its turn: its reservation is given back
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	assert.ErrorIs(t, l.acquire(ctx), context.Canceled)
+	assert.Equal(t, 0, len(l.sem))
+
+	// next request is sent at the next turn (100ms), not after the cancelled one (200ms)
+	assert.NoError(t, l.acquire(context.Background()))
+	l.release()
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 80*time.Millisecond)
+	assert.Less(t, elapsed, 180*time.Millisecond)
+
+	// requests whose deadline expire before their turn don't delay the next ones
+	for range 5 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		assert.Error(t, l.acquire(ctx))
+		cancel()
+	}
+	start = time.Now()
+	assert.NoError(t, l.acquire(context.Background()))
+	l.release()
+	assert.Less(t, time.Since(start), 180*time.Millisecond)
+}
+
+func TestRequestLimits(t *testing.T) {
+	gc := &GlobalConfig{MaxConcurrentRequests: 3, MaxConcurrentRequestsPerHost: 1}
+	limits := newRequestLimits(gc, &TargetConfig{Name: "limits_t1"})
+
+	release, err := limits.acquire(context.Background(), "https://limits-host:443/status")
+	if !assert.NoError(t, err) {
+		return
+	}
+	// host slot is used; the global one is not held while waiting for it
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limits.acquire(ctx, "https://limits-host:443/other")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, len(requestLimiters.get("global", 3, 0).sem))
+
+	// target limits replace host ones
+	t2 := limits.forTarget(&TargetConfig{Name: "limits_t2", MaxConcurrentRequests: 2})
+	release2, err := t2.acquire(context.Background(), "https://limits-host:443/status")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, len(requestLimiters.get("global", 3, 0).sem))
+		release2()
+	}
+	release()
+	assert.Equal(t, 0, len(requestLimiters.get("global", 3, 0).sem))
+
+	// changed limits replace the limiter; no limit is nil
+	l := requestLimiters.get("host:limits-host:443", 1, 0)
+	assert.Same(t, l, requestLimiters.get("host:limits-host:443", 1, 0))
+	assert.NotSame(t, l, requestLimiters.get("host:limits-host:443", 2, 0))
+	assert.Nil(t, requestLimiters.get("host:limits-host:443", 0, 0))
+
+	// no limits set
+	release, err = (*requestLimits)(nil).acquire(context.Background(), "https://limits-host:443/status")
+	if assert.NoError(t, err) {
+		release()
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// kinds of configuration files a schema can be generated for.
+const (
+	schemaKindConfig    = "config"
+	schemaKindCollector = "collector"
+	schemaKindProfiles  = "profiles"
+	schemaKindTarget    = "target"
+)
+
+var (
+	schemaCmd  = kingpin.Command("schema", "Output the JSON Schema of configuration files, for editors and CI linting.")
+	schemaKind = schemaCmd.Arg("kind", "Kind of file: config (main config file), collector (collector_files), profiles (profiles_file_config) or target (targets_files).").
+			Default(schemaKindConfig).Enum(schemaKindConfig, schemaKindCollector, schemaKindProfiles, schemaKindTarget)
+)
+
+// runSchemaCommand outputs the schema of the kind of file set on command line; it returns the exit code.
+func runSchemaCommand(stdout, stderr io.Writer) int {
+	schema, err := ConfigSchema(*schemaKind)
+	if err == nil {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(schema)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// schemaGenerator builds JSON schemas from the yaml tags of config structs. Each struct is a definition in $defs.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// names of definitions of unexported config structs
+var schemaDefNames = map[reflect.Type]string{
+	reflect.TypeFor[profileParser](): "ProfileConfig",
+}
+
+// schemas of types with a specific yaml format
+var schemaTypes = map[reflect.Type]map[string]any{
+	reflect.TypeFor[model.Duration](): {
+		"type":        "string",
+		"pattern":     `^(0|(\d+y)?(\d+w)?(\d+d)?(\d+h)?(\d+m)?(\d+s)?(\d+ms)?)$`,
+		"description": "duration: e.g. 30s, 1m30s",
+	},
+	reflect.TypeFor[ConvertibleBoolean](): {"type": []string{"boolean", "string"}},
+	reflect.TypeFor[Secret]():             {"type": "string"},
+	reflect.TypeFor[yaml.Node]():          {},
+	reflect.TypeFor[YAMLScript]():         {"$ref": "#/$defs/Script"},
+	reflect.TypeFor[CollectorRef](): {
+		"oneOf": []any{
+			map[string]any{"type": "string", "description": "collector name, or pattern: ~<regex> or !~<regex>"},
+			map[string]any{"$ref": "#/$defs/CollectorRefParams"},
+		},
+	},
+}
+
+// schemas of fields with a specific format, by definition name and yaml key.
+var schemaFields = map[string]map[string]any{
+	"ProfileConfig.scripts": {
+		"type": "object",
+		"additionalProperties": map[string]any{
+			"oneOf": []any{
+				map[string]any{"$ref": "#/$defs/Script"},
+				map[string]any{
+					"type":        "object",
+					"description": "with extends: actions added before and after the ones of the script of the extended profile",
+					"properties": map[string]any{
+						scriptPrepend: map[string]any{"$ref": "#/$defs/Script"},
+						scriptAppend:  map[string]any{"$ref": "#/$defs/Script"},
+					},
+					"additionalProperties": false,
+				},
+			},
+		},
+	},
+	"Config.httpapi_config": {"description": "obsolete: use profiles"},
+}
+
+// schemaScalar is the schema of a string field: yaml decodes any scalar into a string, e.g. port: 443.
+var schemaScalar = map[string]any{"type": []string{"string", "number", "boolean"}}
+
+// typeSchema returns the schema of values of type t.
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]any {
+	if s, ok := schemaTypes[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return schemaScalar
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	}
+	// interface: any value
+	return map[string]any{}
+}
+
+// structRef adds the definition of struct t to defs and returns a reference to it.
+func (g *schemaGenerator) structRef(t reflect.Type) map[string]any {
+	name, ok := schemaDefNames[t]
+	if !ok {
+		name = t.Name()
+	}
+	ref := map[string]any{"$ref": "#/$defs/" + name}
+	if _, ok := g.defs[name]; ok {
+		return ref
+	}
+	def := map[string]any{"type": "object"}
+	// set before the fields for recursive structs
+	g.defs[name] = def
+	properties := make(map[string]any)
+	g.structProperties(t, name, def, properties)
+	def["properties"] = properties
+	return ref
+}
+
+// structProperties sets in properties the schemas of the fields of struct t, and forbids undefined fields in def
+// if they are caught by an inline map: unmarshaling fails with checkOverflow().
+func (g *schemaGenerator) structProperties(t reflect.Type, name string, def, properties map[string]any) {
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		key, opts, _ := strings.Cut(tag, ",")
+		if key == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			if field.Type.Kind() == reflect.Map {
+				def["additionalProperties"] = false
+			} else {
+				g.structProperties(field.Type, name, def, properties)
+			}
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		if s, ok := schemaFields[name+"."+key]; ok {
+			properties[key] = s
+		} else {
+			properties[key] = g.typeSchema(field.Type)
+		}
+	}
+}
+
+// actionsSchemas sets in defs the schemas of scripts: lists of actions. Actions are parsed by ActionsListDecode()
+// from the keyword that defines their type.
+func (g *schemaGenerator) actionsSchemas() {
+	condition := map[string]any{
+		"oneOf": []any{schemaScalar, map[string]any{"type": "array", "items": schemaScalar}},
+	}
+	common := map[string]any{
+		"name":       map[string]any{"type": []string{"string", "number", "boolean", "null"}},
+		"vars":       map[string]any{"type": "object"},
+		"with_items": map[string]any{"description": "list or template returning a list"},
+		"loop":       map[string]any{"description": "list or template returning a list"},
+		"loop_var":   schemaScalar,
+		"when":       condition,
+		"until":      condition,
+	}
+	action := func(keyword string, schema map[string]any, others map[string]any) map[string]any {
+		properties := make(map[string]any, len(common)+len(others)+1)
+		maps.Copy(properties, common)
+		maps.Copy(properties, others)
+		properties[keyword] = schema
+		return map[string]any{
+			"type":                 "object",
+			"required":             []string{keyword},
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+	vars := map[string]any{"type": "object"}
+
+	g.defs["Script"] = map[string]any{
+		"description": "list of actions",
+		"type":        []string{"array", "null"},
+		"items":       map[string]any{"$ref": "#/$defs/Action"},
+	}
+	g.defs["Action"] = map[string]any{
+		"oneOf": []any{
+			action("debug", g.typeSchema(reflect.TypeFor[DebugActionConfig]()), nil),
+			action("set_fact", vars, nil),
+			action("set_stats", vars, nil),
+			action("query", g.typeSchema(reflect.TypeFor[QueryActionConfig]()), nil),
+			action("play_script", schemaScalar, nil),
+			action("actions", map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/Action"}}, nil),
+			action("metrics", map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/MetricAction"}},
+				map[string]any{"scope": schemaScalar, "metric_prefix": schemaScalar}),
+		},
+	}
+
+	// a metric of a metrics action: the fields of MetricConfig and the common ones.
+	g.typeSchema(reflect.TypeFor[MetricConfig]())
+	metric := g.defs["MetricConfig"].(map[string]any)
+	properties := make(map[string]any)
+	maps.Copy(properties, common)
+	maps.Copy(properties, metric["properties"].(map[string]any))
+	delete(g.defs, "MetricConfig")
+	g.defs["MetricAction"] = map[string]any{
+		"type":       "object",
+		"required":   []string{"metric_name"},
+		"properties": properties,
+	}
+
+	// map form of a collector reference of a target
+	ref := map[string]any{"type": "object", "required": []string{"name"}}
+	refProperties := make(map[string]any)
+	g.structProperties(reflect.TypeFor[CollectorRef](), "CollectorRefParams", ref, refProperties)
+	ref["properties"] = refProperties
+	g.defs["CollectorRefParams"] = ref
+}
+
+// ConfigSchema returns the JSON schema of a kind of configuration file: main config file, collector file, profiles
+// file or target file.
+func ConfigSchema(kind string) (map[string]any, error) {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	g.actionsSchemas()
+
+	schema := map[string]any{"$schema": schemaDraft}
+	switch kind {
+	case schemaKindConfig:
+		schema["title"] = "httpapi_exporter configuration file"
+		root := g.structRef(reflect.TypeFor[Config]())
+		// directive removed before the config is parsed
+		props := g.defs["Config"].(map[string]any)["properties"].(map[string]any)
+		props[includeKey] = map[string]any{
+			"description": "files merged into the configuration: glob or list of globs",
+			"oneOf":       []any{schemaScalar, map[string]any{"type": "array", "items": schemaScalar}},
+		}
+		maps.Copy(schema, root)
+	case schemaKindCollector:
+		schema["title"] = "httpapi_exporter collector file"
+		maps.Copy(schema, g.structRef(reflect.TypeFor[CollectorConfig]()))
+	case schemaKindProfiles:
+		schema["title"] = "httpapi_exporter profiles file"
+		schema["type"] = "object"
+		schema["additionalProperties"] = g.typeSchema(reflect.TypeFor[profileParser]())
+	case schemaKindTarget:
+		schema["title"] = "httpapi_exporter target file"
+		maps.Copy(schema, g.structRef(reflect.TypeFor[TargetConfig]()))
+	default:
+		return nil, fmt.Errorf("unknown kind of configuration file '%s'", kind)
+	}
+	schema["$defs"] = g.defs
+	return schema, nil
+}
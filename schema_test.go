@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// validateSchema checks value against the subset of JSON schema used by ConfigSchema(); it returns the errors.
+func validateSchema(root, schema map[string]any, value any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		def := root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")]
+		return validateSchema(root, def.(map[string]any), value, path)
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		var errs []string
+		matched := 0
+		for _, s := range oneOf {
+			e := validateSchema(root, s.(map[string]any), value, path)
+			if len(e) == 0 {
+				matched++
+			}
+			errs = append(errs, e...)
+		}
+		if matched != 1 {
+			return append(errs, fmt.Sprintf("%s: %d schemas of oneOf matched", path, matched))
+		}
+		return nil
+	}
+	if typ, ok := schema["type"]; ok {
+		var types []string
+		switch t := typ.(type) {
+		case string:
+			types = []string{t}
+		case []string:
+			types = t
+		}
+		var kind string
+		switch value.(type) {
+		case nil:
+			kind = "null"
+		case bool:
+			kind = "boolean"
+		case int, float64:
+			kind = "number"
+		case string:
+			kind = "string"
+		case []any:
+			kind = "array"
+		case map[string]any:
+			kind = "object"
+		}
+		_, isInt := value.(int)
+		if !slices.Contains(types, kind) && !(isInt && slices.Contains(types, "integer")) {
+			return []string{fmt.Sprintf("%s: %s is not %v", path, kind, types)}
+		}
+	}
+	var errs []string
+	switch v := value.(type) {
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for idx, item := range v {
+				errs = append(errs, validateSchema(root, items, item, fmt.Sprintf("%s[%d]", path, idx))...)
+			}
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]string); ok {
+			for _, key := range required {
+				if _, ok := v[key]; !ok {
+					errs = append(errs, fmt.Sprintf("%s: %s is required", path, key))
+				}
+			}
+		}
+		for key, item := range v {
+			if s, ok := properties[key]; ok {
+				errs = append(errs, validateSchema(root, s.(map[string]any), item, path+"."+key)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, fmt.Sprintf("%s: unknown field %s", path, key))
+				}
+			case map[string]any:
+				errs = append(errs, validateSchema(root, additional, item, path+"."+key)...)
+			}
+		}
+	}
+	return errs
+}
+
+func TestConfigSchema(t *testing.T) {
+	kinds := map[string]string{
+		"config.yml":     schemaKindConfig,
+		".collector.yml": schemaKindCollector,
+		"_profile.yml":   schemaKindProfiles,
+		"targets":        schemaKindTarget,
+	}
+	schemas := make(map[string]map[string]any)
+	for _, kind := range kinds {
+		schema, err := ConfigSchema(kind)
+		if !assert.NoError(t, err) {
+			return
+		}
+		// must be serializable
+		_, err = json.Marshal(schema)
+		assert.NoError(t, err)
+		schemas[kind] = schema
+	}
+	_, err := ConfigSchema("unknown")
+	assert.Error(t, err)
+
+	// all action types are defined
+	actions := schemas[schemaKindCollector]["$defs"].(map[string]any)["Action"].(map[string]any)["oneOf"].([]any)
+	var keywords []string
+	for _, action := range actions {
+		keywords = append(keywords, action.(map[string]any)["required"].([]string)...)
+	}
+	assert.ElementsMatch(t, []string{"debug", "set_fact", "set_stats", "query", "play_script", "actions", "metrics"}, keywords)
+
+	// configurations of contribs are valid
+	var files []string
+	for _, dir := range []string{"contribs/apache/etc/apache", "contribs/nginx/etc/nginx", "contribs/veeam/etc/veeam"} {
+		for _, pattern := range []string{"*.yml", "metrics/*.yml", "profiles/*.yml", "targets/*.yml"} {
+			more, _ := filepath.Glob(filepath.Join(dir, pattern))
+			files = append(files, more...)
+		}
+	}
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		var kind string
+		for suffix, k := range kinds {
+			if strings.HasSuffix(file, suffix) || strings.Contains(file, "/"+suffix+"/") {
+				kind = k
+			}
+		}
+		if kind == "" {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var value any
+		if !assert.NoError(t, yaml.Unmarshal(content, &value), file) {
+			continue
+		}
+		schema := schemas[kind]
+		assert.Empty(t, validateSchema(schema, schema, value, "$"), file)
+	}
+
+	// unknown fields are detected
+	var value any
+	_ = yaml.Unmarshal([]byte("collector_name: test\nscripts:\n  get:\n    - name: q\n      query:\n        url: /\n        unknown: 1\n"), &value)
+	schema := schemas[schemaKindCollector]
+	assert.NotEmpty(t, validateSchema(schema, schema, value, "$"))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// labels of service discovery groups
+const (
+	sdParamTargetLabel   = "__param_target"
+	sdParamAuthNameLabel = "__param_auth_name"
+	sdProfileLabel       = "__meta_httpapi_profile"
+	sdCollectorsLabel    = "__meta_httpapi_collectors"
+)
+
+// sdTargetGroup is a target group of prometheus http service discovery.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// ServiceDiscoveryHandlerFunc is the HTTP handler for the `/sd` page. It outputs the static targets in prometheus
+// http_sd_configs format: each target is a group whose address is the exporter, with the parameters to scrape the
+// target. Targets may be filtered by profile and collector query parameters.
+func ServiceDiscoveryHandlerFunc(metricsPath string, exporter Exporter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			HandleError(http.StatusMethodNotAllowed, fmt.Errorf("this endpoint requires a GET request"), metricsPath, exporter, w, r)
+			return
+		}
+		params := r.URL.Query()
+		// address used by prometheus to scrape the exporter: the one used to reach it by default.
+		address := params.Get("address")
+		if address == "" {
+			address = r.Host
+		}
+
+		groups := sdTargetGroups(exporter.Config().Targets, address, params["profile"], params["collector"])
+		res, err := json.Marshal(groups)
+		if err != nil {
+			HandleError(http.StatusInternalServerError, err, metricsPath, exporter, w, r)
+			return
+		}
+		w.Header().Set(contentTypeHeader, applicationJSON)
+		w.Header().Set(contentLengthHeader, fmt.Sprint(len(res)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+	}
+}
+
+// sdTargetGroups returns the service discovery groups of static targets that use one of profiles and one of
+// collectors, if set.
+func sdTargetGroups(targets []*TargetConfig, address string, profiles, collectors []string) []*sdTargetGroup {
+	groups := make([]*sdTargetGroup, 0, len(targets))
+	for _, t := range targets {
+		// models, dynamic targets and targets_files pseudo targets are not exposed.
+		if t.targetType != TargetTypeStatic || len(t.TargetsFiles) > 0 {
+			continue
+		}
+		if len(profiles) > 0 && !slices.Contains(profiles, t.ProfileName) {
+			continue
+		}
+		names := make([]string, 0, len(t.Collectors()))
+		for _, coll := range t.Collectors() {
+			names = append(names, coll.Name)
+		}
+		if len(collectors) > 0 && !slices.ContainsFunc(collectors, func(name string) bool {
+			return slices.Contains(names, name)
+		}) {
+			continue
+		}
+
+		labels := make(map[string]string, len(t.Labels)+4)
+		for name, value := range t.Labels {
+			labels[name] = value
+		}
+		labels[sdParamTargetLabel] = t.Name
+		if t.AuthName != "" {
+			labels[sdParamAuthNameLabel] = t.AuthName
+		}
+		labels[sdProfileLabel] = t.ProfileName
+		// same format as prometheus lists in meta labels: separators on both ends to ease regex matching.
+		labels[sdCollectorsLabel] = "," + strings.Join(names, ",") + ","
+
+		groups = append(groups, &sdTargetGroup{
+			Targets: []string{address},
+			Labels:  labels,
+		})
+	}
+	return groups
+}
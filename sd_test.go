@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceDiscoveryGroups(t *testing.T) {
+	coll_a := &CollectorConfig{Name: "coll_a"}
+	coll_b := &CollectorConfig{Name: "coll_b"}
+	targets := []*TargetConfig{
+		{
+			Name:        "target1",
+			ProfileName: "veeam",
+			AuthName:    "prod",
+			Labels:      map[string]string{"environment": "PROD"},
+			collectors:  []*CollectorConfig{coll_a, coll_b},
+		},
+		{
+			Name:        "target2",
+			ProfileName: "apache",
+			collectors:  []*CollectorConfig{coll_b},
+		},
+		{
+			Name:        "default",
+			ProfileName: "apache",
+			collectors:  []*CollectorConfig{coll_b},
+			targetType:  TargetTypeModel,
+		},
+		{
+			TargetsFiles: []string{"targets/*.yml"},
+		},
+	}
+
+	groups := sdTargetGroups(targets, "exporter:9321", nil, nil)
+	if !assert.Equal(t, 2, len(groups)) {
+		return
+	}
+	assert.Equal(t, []string{"exporter:9321"}, groups[0].Targets)
+	assert.Equal(t, map[string]string{
+		"environment":        "PROD",
+		sdParamTargetLabel:   "target1",
+		sdParamAuthNameLabel: "prod",
+		sdProfileLabel:       "veeam",
+		sdCollectorsLabel:    ",coll_a,coll_b,",
+	}, groups[0].Labels)
+	assert.NotContains(t, groups[1].Labels, sdParamAuthNameLabel)
+
+	groups = sdTargetGroups(targets, "exporter:9321", []string{"apache"}, nil)
+	if assert.Equal(t, 1, len(groups)) {
+		assert.Equal(t, "target2", groups[0].Labels[sdParamTargetLabel])
+	}
+	groups = sdTargetGroups(targets, "exporter:9321", nil, []string{"coll_a", "unknown"})
+	if assert.Equal(t, 1, len(groups)) {
+		assert.Equal(t, "target1", groups[0].Labels[sdParamTargetLabel])
+	}
+	assert.Equal(t, 0, len(sdTargetGroups(targets, "exporter:9321", []string{"veeam"}, []string{"unknown"})))
+}
@@ -0,0 +1,269 @@
+// cSpell:ignore passwd
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	kingpin "github.com/alecthomas/kingpin/v2"
+	"github.com/peekjef72/passwd_encrypt/encrypt"
+)
+
+// prefix of encrypted passwords in config files
+const encryptedPrefix = "/encrypted/"
+
+// matches the encrypted values in config files: base64 ciphertexts produced by encrypt.AESCipher.
+var encryptedValueRE = regexp.MustCompile(`/encrypted/([A-Za-z0-9+/]+=*)`)
+
+var (
+	secretCmd = kingpin.Command("secret", "Manage the passwords encrypted with the shared passphrase (auth_key).")
+
+	secretEncryptCmd     = secretCmd.Command("encrypt", "Encrypt a password; result can be set as password in auth_config.")
+	secretEncryptKey     = secretEncryptCmd.Flag("key", "The shared passphrase; read from stdin if neither key nor key-file is set.").String()
+	secretEncryptKeyFile = secretEncryptCmd.Flag("key-file", "File containing the shared passphrase.").String()
+	secretEncryptValue   = secretEncryptCmd.Arg("password", "The password to encrypt; read from stdin if not set.").String()
+
+	secretDecryptCmd     = secretCmd.Command("decrypt", "Decrypt an encrypted password.")
+	secretDecryptKey     = secretDecryptCmd.Flag("key", "The shared passphrase; read from stdin if neither key nor key-file is set.").String()
+	secretDecryptKeyFile = secretDecryptCmd.Flag("key-file", "File containing the shared passphrase.").String()
+	secretDecryptValue   = secretDecryptCmd.Arg("ciphertext", "The encrypted password, with or without '/encrypted/' prefix; read from stdin if not set.").String()
+
+	secretRotateCmd        = secretCmd.Command("rotate", "Re-encrypt all encrypted passwords of config file and targets files with a new passphrase; with --dry-run, only display the changes as a diff.")
+	secretRotateOldKey     = secretRotateCmd.Flag("old-key", "The current shared passphrase; read from stdin if neither old-key nor old-key-file is set.").String()
+	secretRotateOldKeyFile = secretRotateCmd.Flag("old-key-file", "File containing the current shared passphrase.").String()
+	secretRotateNewKey     = secretRotateCmd.Flag("new-key", "The new shared passphrase; read from stdin if neither new-key nor new-key-file is set.").String()
+	secretRotateNewKeyFile = secretRotateCmd.Flag("new-key-file", "File containing the new shared passphrase.").String()
+)
+
+// runSecretCommand runs the secret sub command; it returns the exit code of the program.
+func runSecretCommand(command string, stdin io.Reader, stdout, stderr io.Writer) int {
+	input := bufio.NewReader(stdin)
+	var err error
+	switch command {
+	case secretEncryptCmd.FullCommand():
+		err = secretEncrypt(input, stdout, stderr)
+	case secretDecryptCmd.FullCommand():
+		err = secretDecrypt(input, stdout, stderr)
+	case secretRotateCmd.FullCommand():
+		err = secretRotate(input, stdout, stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readSecretInput returns value if set, else the content of file if set, else a line read from input.
+func readSecretInput(value, file, prompt string, input *bufio.Reader, stderr io.Writer) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	fmt.Fprintf(stderr, "%s: ", prompt)
+	line, err := input.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("can't read %s: %s", prompt, err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// newCipher returns the cipher for key obtained from flags or input.
+func newCipher(key, key_file, prompt string, input *bufio.Reader, stderr io.Writer) (*encrypt.AESCipher, error) {
+	key, err := readSecretInput(key, key_file, prompt, input, stderr)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := encrypt.NewAESCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s (must be 16, 24 or 32 bytes long): %s", prompt, err)
+	}
+	return cipher, nil
+}
+
+func secretEncrypt(input *bufio.Reader, stdout, stderr io.Writer) error {
+	cipher, err := newCipher(*secretEncryptKey, *secretEncryptKeyFile, "key", input, stderr)
+	if err != nil {
+		return err
+	}
+	passwd, err := readSecretInput(*secretEncryptValue, "", "password", input, stderr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, encryptedPrefix+cipher.Encrypt([]byte(passwd), true))
+	return nil
+}
+
+func secretDecrypt(input *bufio.Reader, stdout, stderr io.Writer) error {
+	cipher, err := newCipher(*secretDecryptKey, *secretDecryptKeyFile, "key", input, stderr)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := readSecretInput(*secretDecryptValue, "", "ciphertext", input, stderr)
+	if err != nil {
+		return err
+	}
+	passwd, err := cipher.Decrypt(strings.TrimPrefix(ciphertext, encryptedPrefix), true)
+	if err != nil {
+		return fmt.Errorf("can't decrypt: %s", err)
+	}
+	fmt.Fprintln(stdout, passwd)
+	return nil
+}
+
+func secretRotate(input *bufio.Reader, stdout, stderr io.Writer) error {
+	old_cipher, err := newCipher(*secretRotateOldKey, *secretRotateOldKeyFile, "old key", input, stderr)
+	if err != nil {
+		return err
+	}
+	new_cipher, err := newCipher(*secretRotateNewKey, *secretRotateNewKeyFile, "new key", input, stderr)
+	if err != nil {
+		return err
+	}
+	files, err := secretFiles(*configFile, *configEnv)
+	if err != nil {
+		return err
+	}
+	// global --dry-run flag: only display the changes
+	return rotateFiles(files, old_cipher, new_cipher, *dry_run, stdout)
+}
+
+// secretFiles returns the config file, the files it includes, its overlay for environment and the files matching
+// targets_files globs of its targets.
+func secretFiles(config_file, environment string) ([]string, error) {
+	loader := &configLoader{}
+	node, err := loader.load(config_file, environment)
+	if err != nil {
+		return nil, err
+	}
+	// only the targets_files of targets are required
+	var config struct {
+		Targets []struct {
+			TargetsFiles []string `yaml:"targets_files"`
+		} `yaml:"targets"`
+	}
+	if err := node.Decode(&config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", config_file, err)
+	}
+
+	files := make([]string, 0, len(loader.files))
+	seen := map[string]bool{}
+	for _, file := range loader.files {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	baseDir := filepath.Dir(config_file)
+	for _, t := range config.Targets {
+		for _, tfglob := range t.TargetsFiles {
+			// Resolve relative paths by joining them to the configuration file's directory.
+			if len(tfglob) > 0 && !filepath.IsAbs(tfglob) {
+				tfglob = filepath.Join(baseDir, tfglob)
+			}
+			tfs, err := filepath.Glob(tfglob)
+			if err != nil {
+				return nil, fmt.Errorf("error resolving targets_files files for %s: %s", tfglob, err)
+			}
+			for _, tf := range tfs {
+				if !seen[tf] {
+					seen[tf] = true
+					files = append(files, tf)
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// rotateFiles re-encrypts with new_cipher all values of files encrypted with old_cipher. All values are checked
+// before any file is written: if one can't be decrypted, no file is modified. In dry-run mode, the changes are
+// displayed as a diff.
+func rotateFiles(files []string, old_cipher, new_cipher *encrypt.AESCipher, dry_run bool, stdout io.Writer) error {
+	type change struct {
+		file    string
+		mode    os.FileMode
+		old     []string
+		new     []string
+		changed []int
+	}
+	changes := make([]*change, 0, len(files))
+	count := 0
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		c := &change{
+			file: file,
+			mode: info.Mode().Perm(),
+			old:  strings.Split(string(content), "\n"),
+		}
+		c.new = make([]string, len(c.old))
+		for idx, line := range c.old {
+			var line_err error
+			c.new[idx] = encryptedValueRE.ReplaceAllStringFunc(line, func(value string) string {
+				passwd, err := old_cipher.Decrypt(value[len(encryptedPrefix):], true)
+				if err != nil {
+					if line_err == nil {
+						line_err = fmt.Errorf("%s:%d: can't decrypt value with old key: %s", file, idx+1, err)
+					}
+					return value
+				}
+				count++
+				return encryptedPrefix + new_cipher.Encrypt([]byte(passwd), true)
+			})
+			if line_err != nil {
+				return line_err
+			}
+			if c.new[idx] != line {
+				c.changed = append(c.changed, idx)
+			}
+		}
+		if len(c.changed) > 0 {
+			changes = append(changes, c)
+		}
+	}
+
+	for _, c := range changes {
+		if dry_run {
+			fmt.Fprintf(stdout, "--- %s\n+++ %s\n", c.file, c.file)
+			for _, idx := range c.changed {
+				fmt.Fprintf(stdout, "@@ -%d +%d @@\n-%s\n+%s\n", idx+1, idx+1, c.old[idx], c.new[idx])
+			}
+			continue
+		}
+		// write a temporary file then rename it, to never leave a partially written file.
+		tmp := c.file + ".rotate.tmp"
+		if err := os.WriteFile(tmp, []byte(strings.Join(c.new, "\n")), c.mode); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, c.file); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	action := "re-encrypted"
+	if dry_run {
+		action = "to re-encrypt"
+	}
+	fmt.Fprintf(stdout, "%d value(s) %s in %d file(s)\n", count, action, len(changes))
+	return nil
+}
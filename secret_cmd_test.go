@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peekjef72/passwd_encrypt/encrypt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretRotate(t *testing.T) {
+	old_cipher, _ := encrypt.NewAESCipher("0123456789abcdef")
+	new_cipher, _ := encrypt.NewAESCipher("fedcba9876543210")
+	other_cipher, _ := encrypt.NewAESCipher("abcdefabcdefabcd")
+
+	dir := t.TempDir()
+	config_file := filepath.Join(dir, "config.yml")
+	config := `
+auth_configs:
+  prod:
+    user: admin
+    password: ` + encryptedPrefix + old_cipher.Encrypt([]byte("secret1"), true) + `
+targets:
+  - targets_files: [ "targets/*.yml" ]
+`
+	target_file := filepath.Join(dir, "targets", "target1.yml")
+	target := `name: target1
+auth_config:
+  password: "` + encryptedPrefix + old_cipher.Encrypt([]byte("secret2"), true) + `"
+`
+	if err := os.MkdirAll(filepath.Dir(target_file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config_file, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target_file, []byte(target), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := secretFiles(config_file, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{config_file, target_file}, files)
+
+	// included fragments and environment overlay
+	fragment_file := filepath.Join(dir, "conf.d", "auth.yml")
+	overlay_file := filepath.Join(dir, "config.prod.yml")
+	if err := os.MkdirAll(filepath.Dir(fragment_file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(fragment_file, []byte("auth_configs:\n  other:\n    user: other\n"), 0o600)
+	os.WriteFile(overlay_file, []byte("global:\n  scrape_timeout: 5s\n"), 0o600)
+	os.WriteFile(config_file, []byte("include: conf.d/*.yml\n"+config), 0o600)
+	files, err = secretFiles(config_file, "prod")
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{config_file, fragment_file, overlay_file, target_file}, files)
+	}
+	files, _ = secretFiles(config_file, "")
+	assert.Equal(t, []string{config_file, fragment_file, target_file}, files)
+	os.WriteFile(config_file, []byte(config), 0o600)
+	os.Remove(fragment_file)
+	os.Remove(overlay_file)
+	files, _ = secretFiles(config_file, "")
+
+	// a value not encrypted with old key: nothing is modified
+	var out bytes.Buffer
+	assert.NotNil(t, rotateFiles(files, other_cipher, new_cipher, false, &out))
+
+	// dry-run: diff only
+	out.Reset()
+	if !assert.Nil(t, rotateFiles(files, old_cipher, new_cipher, true, &out)) {
+		return
+	}
+	assert.Contains(t, out.String(), "--- "+target_file)
+	assert.Contains(t, out.String(), "2 value(s) to re-encrypt in 2 file(s)")
+	content, _ := os.ReadFile(target_file)
+	assert.Equal(t, target, string(content))
+
+	out.Reset()
+	if !assert.Nil(t, rotateFiles(files, old_cipher, new_cipher, false, &out)) {
+		return
+	}
+	for file, passwd := range map[string]string{config_file: "secret1", target_file: "secret2"} {
+		content, _ := os.ReadFile(file)
+		match := encryptedValueRE.FindStringSubmatch(string(content))
+		if !assert.NotNil(t, match, file) {
+			continue
+		}
+		value, err := new_cipher.Decrypt(match[1], true)
+		assert.Nil(t, err, file)
+		assert.Equal(t, passwd, value, file)
+		assert.Equal(t, 1, strings.Count(string(content), encryptedPrefix))
+	}
+	info, _ := os.Stat(target_file)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+}
@@ -0,0 +1,337 @@
+// cSpell:ignore symtab
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// prefixes of the values of auth configs that are read from a secret provider.
+const (
+	secretFilePrefix  = "$file:"
+	secretExecPrefix  = "$exec:"
+	secretVaultPrefix = "$vault:"
+)
+
+const (
+	// default period of refresh of the secrets obtained by command or from vault
+	defaultSecretTTL = 5 * time.Minute
+	// default timeout of secret commands and vault requests
+	defaultSecretTimeout = 10 * time.Second
+	// default header used to send the token to vault
+	defaultVaultTokenHeader = "X-Vault-Token"
+)
+
+// SecretProvider obtains the value of a secret from an external source.
+type SecretProvider interface {
+	// Fetch returns the current value of the secret.
+	Fetch() (string, error)
+	// Cacheable is true if the value may be kept until the TTL expires; else Fetch() is called each time the value
+	// is required.
+	Cacheable() bool
+}
+
+// SecretsConfig defines the secret providers.
+type SecretsConfig struct {
+	TTL     model.Duration `yaml:"ttl,omitempty" json:"ttl,omitempty"`         // period of refresh of $exec and $vault secrets; default 5m
+	Timeout model.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"` // timeout of commands and vault requests; default 10s
+	Vault   *VaultConfig   `yaml:"vault,omitempty" json:"vault,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for SecretsConfig.
+func (sc *SecretsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain SecretsConfig
+	if err := unmarshal((*plain)(sc)); err != nil {
+		return err
+	}
+	if sc.TTL < 0 {
+		return fmt.Errorf("secrets: ttl must be positive, have %s", sc.TTL)
+	}
+	if sc.Timeout < 0 {
+		return fmt.Errorf("secrets: timeout must be positive, have %s", sc.Timeout)
+	}
+	return checkOverflow(sc.XXX, "secrets")
+}
+
+// VaultConfig defines the http secret store used by "$vault:<path>[#<field>]" values: the secret is the field of
+// the json document returned by GET <url>/<path>.
+type VaultConfig struct {
+	Url         string            `yaml:"url" json:"url"`
+	Token       Secret            `yaml:"token,omitempty" json:"token,omitempty"`
+	TokenFile   string            `yaml:"token_file,omitempty" json:"token_file,omitempty"`
+	TokenHeader string            `yaml:"token_header,omitempty" json:"token_header,omitempty"` // default X-Vault-Token
+	Headers     map[string]string `yaml:"headers,omitempty" json:"-"`
+	Field       string            `yaml:"field,omitempty" json:"field,omitempty"` // default field of the secret in the document: dotted path; default "value"
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for VaultConfig.
+func (vc *VaultConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain VaultConfig
+	if err := unmarshal((*plain)(vc)); err != nil {
+		return err
+	}
+	if vc.Url == "" {
+		return fmt.Errorf("secrets: vault url must be set")
+	}
+	if vc.TokenHeader == "" {
+		vc.TokenHeader = defaultVaultTokenHeader
+	}
+	if vc.Field == "" {
+		vc.Field = "value"
+	}
+	redactor.AddSecrets(string(vc.Token))
+	return checkOverflow(vc.XXX, "vault")
+}
+
+// config of secret providers; set when a config is applied.
+var secretsConfig atomic.Pointer[SecretsConfig]
+
+// currentSecretsConfig returns the config of secret providers with defaults set.
+func currentSecretsConfig() *SecretsConfig {
+	sc := &SecretsConfig{}
+	if cur := secretsConfig.Load(); cur != nil {
+		*sc = *cur
+	}
+	if sc.TTL == 0 {
+		sc.TTL = model.Duration(defaultSecretTTL)
+	}
+	if sc.Timeout == 0 {
+		sc.Timeout = model.Duration(defaultSecretTimeout)
+	}
+	return sc
+}
+
+// isSecretRef returns true if value must be obtained from a secret provider.
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretFilePrefix) ||
+		strings.HasPrefix(value, secretExecPrefix) ||
+		strings.HasPrefix(value, secretVaultPrefix)
+}
+
+// newSecretProvider returns the provider of ref value.
+func newSecretProvider(ref string) (SecretProvider, error) {
+	switch {
+	case strings.HasPrefix(ref, secretFilePrefix):
+		path := strings.TrimSpace(ref[len(secretFilePrefix):])
+		if path == "" {
+			return nil, fmt.Errorf("no file set for '%s'", ref)
+		}
+		return &fileSecretProvider{path: path}, nil
+	case strings.HasPrefix(ref, secretExecPrefix):
+		args := strings.Fields(ref[len(secretExecPrefix):])
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no command set for '%s'", ref)
+		}
+		return &execSecretProvider{args: args}, nil
+	case strings.HasPrefix(ref, secretVaultPrefix):
+		path, field, _ := strings.Cut(ref[len(secretVaultPrefix):], "#")
+		if path = strings.TrimSpace(path); path == "" {
+			return nil, fmt.Errorf("no path set for '%s'", ref)
+		}
+		return &vaultSecretProvider{path: path, field: strings.TrimSpace(field)}, nil
+	}
+	return nil, fmt.Errorf("unknown secret provider for '%s'", ref)
+}
+
+// fileSecretProvider reads the secret from a file; the content is trimmed. The file is read again when it has changed.
+type fileSecretProvider struct {
+	path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// Fetch implements SecretProvider.
+func (p *fileSecretProvider) Fetch() (string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", fmt.Errorf("secret file: %s", err)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.modTime.IsZero() && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.value, nil
+	}
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", fmt.Errorf("secret file: %s", err)
+	}
+	p.value = strings.TrimSpace(string(content))
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	return p.value, nil
+}
+
+// Cacheable implements SecretProvider: the file is checked each time, to detect changes.
+func (p *fileSecretProvider) Cacheable() bool {
+	return false
+}
+
+// execSecretProvider obtains the secret from the output of a command; the output is trimmed.
+type execSecretProvider struct {
+	args []string
+}
+
+// Fetch implements SecretProvider.
+func (p *execSecretProvider) Fetch() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(currentSecretsConfig().Timeout))
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.args[0], p.args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("secret command '%s': %s: %s", p.args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Cacheable implements SecretProvider.
+func (p *execSecretProvider) Cacheable() bool {
+	return true
+}
+
+// vaultSecretProvider obtains the secret from a field of the json document returned by the http secret store.
+type vaultSecretProvider struct {
+	path  string
+	field string
+}
+
+// Fetch implements SecretProvider.
+func (p *vaultSecretProvider) Fetch() (string, error) {
+	sc := currentSecretsConfig()
+	vc := sc.Vault
+	if vc == nil {
+		return "", fmt.Errorf("secret '%s': no vault defined in global secrets config", p.path)
+	}
+	url := strings.TrimSuffix(vc.Url, "/") + "/" + strings.TrimPrefix(p.path, "/")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sc.Timeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("vault secret '%s': %s", p.path, err)
+	}
+	token := string(vc.Token)
+	if vc.TokenFile != "" {
+		content, err := os.ReadFile(vc.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("vault token file: %s", err)
+		}
+		token = strings.TrimSpace(string(content))
+		redactor.AddSecrets(token)
+	}
+	if token != "" {
+		req.Header.Set(vc.TokenHeader, token)
+	}
+	for name, value := range vc.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(acceptHeader, applicationJSON)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault secret '%s': %s", p.path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("vault secret '%s': %s", p.path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault secret '%s': http status %d", p.path, resp.StatusCode)
+	}
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", fmt.Errorf("vault secret '%s': invalid json response: %s", p.path, err)
+	}
+	field := p.field
+	if field == "" {
+		field = vc.Field
+	}
+	for _, key := range strings.Split(field, ".") {
+		obj, ok := data.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("vault secret '%s': field '%s' not found", p.path, field)
+		}
+		if data, ok = obj[key]; !ok {
+			return "", fmt.Errorf("vault secret '%s': field '%s' not found", p.path, field)
+		}
+	}
+	switch value := data.(type) {
+	case string:
+		return value, nil
+	case nil, map[string]any, []any:
+		return "", fmt.Errorf("vault secret '%s': field '%s' is not a scalar", p.path, field)
+	default:
+		return fmt.Sprintf("%v", value), nil
+	}
+}
+
+// Cacheable implements SecretProvider.
+func (p *vaultSecretProvider) Cacheable() bool {
+	return true
+}
+
+// secretRef is a value of auth config obtained from a provider. It is resolved when required, and kept until
+// the TTL of secrets expires.
+type secretRef struct {
+	ref      string
+	provider SecretProvider
+
+	mutex   sync.Mutex
+	value   string
+	expires time.Time
+}
+
+// newSecretRef returns the secretRef for ref value.
+func newSecretRef(ref string) (*secretRef, error) {
+	provider, err := newSecretProvider(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &secretRef{
+		ref:      ref,
+		provider: provider,
+	}, nil
+}
+
+// Value returns the current value of secret; it is fetched again from provider if its TTL has expired.
+// On error, the last value obtained is returned with the error.
+func (s *secretRef) Value() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.provider.Cacheable() && !s.expires.IsZero() && time.Now().Before(s.expires) {
+		return s.value, nil
+	}
+	value, err := s.provider.Fetch()
+	if err != nil {
+		return s.value, err
+	}
+	// secrets must never be logged
+	redactor.AddSecrets(value)
+	s.value = value
+	s.expires = time.Now().Add(time.Duration(currentSecretsConfig().TTL))
+	return value, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretProviders(t *testing.T) {
+	// local stand-in for vault
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(defaultVaultTokenHeader) != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			w.Write([]byte(`{"data": {"data": {"password": "vault-pass"}}}`))
+		case "/v1/secret/token":
+			w.Write([]byte(`{"value": "vault-token-value"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+
+	secretsConfig.Store(&SecretsConfig{
+		TTL: model.Duration(time.Hour),
+		Vault: &VaultConfig{
+			Url:         vault.URL + "/v1",
+			Token:       "vault-token",
+			TokenHeader: defaultVaultTokenHeader,
+			Field:       "value",
+		},
+	})
+	defer secretsConfig.Store(nil)
+
+	user_file := filepath.Join(t.TempDir(), "user")
+	if err := os.WriteFile(user_file, []byte("admin\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var auth AuthConfig
+	config := `
+mode: basic
+user: $file:` + user_file + `
+password: $vault:secret/data/app#data.data.password
+token: $exec:echo exec-token
+`
+	if err := yaml.Unmarshal([]byte(config), &auth); !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, auth.HasSecretRefs())
+	user, password, token, err := auth.Values()
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "vault-pass", password)
+	assert.Equal(t, "exec-token", token)
+
+	// file is read again when it changes
+	if err := os.WriteFile(user_file, []byte("operator"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	user, _, _, _ = auth.Values()
+	assert.Equal(t, "operator", user)
+
+	// default field of vault
+	ref, err := newSecretRef("$vault:secret/token")
+	if assert.Nil(t, err) {
+		value, err := ref.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, "vault-token-value", value)
+	}
+
+	// last value is kept on error
+	ref, _ = newSecretRef("$exec:echo first")
+	value, _ := ref.Value()
+	assert.Equal(t, "first", value)
+	ref.provider = &execSecretProvider{args: []string{"false"}}
+	ref.expires = time.Time{}
+	value, err = ref.Value()
+	assert.NotNil(t, err)
+	assert.Equal(t, "first", value)
+
+	for _, invalid := range []string{"$file:", "$exec: ", "$vault:#field"} {
+		_, err := newSecretRef(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+	_, err = (&vaultSecretProvider{path: "secret/unknown"}).Fetch()
+	assert.NotNil(t, err)
+}
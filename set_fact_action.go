@@ -28,6 +28,7 @@ type SetFactAction struct {
 
 	setFact [][]any
 	vars    [][]any
+	pos     SourcePos
 }
 
 func (a *SetFactAction) Type() int {
@@ -92,6 +93,13 @@ func (a *SetFactAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *SetFactAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *SetFactAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 func (a *SetFactAction) setBasicElement(
 	registry *goja_modules.JSRegistry,
 	nameField *Field,
@@ -28,6 +28,7 @@ type SetStatsAction struct {
 
 	setStats [][]any
 	vars     [][]any
+	pos      SourcePos
 }
 
 func (a *SetStatsAction) Type() int {
@@ -92,6 +93,13 @@ func (a *SetStatsAction) SetUntil(until []*Field) {
 	a.Until = until
 }
 
+func (a *SetStatsAction) GetPos() SourcePos {
+	return a.pos
+}
+func (a *SetStatsAction) SetPos(pos SourcePos) {
+	a.pos = pos
+}
+
 func (a *SetStatsAction) setBasicElement(
 	registry *goja_modules.JSRegistry,
 	nameField *Field,
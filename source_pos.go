@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourcePos is the location of the definition of an action or a field of a script in a config file.
+type SourcePos struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// nodePos returns the position of node in file; file is empty for scripts of the main config file.
+func nodePos(file string, node *yaml.Node) SourcePos {
+	if node == nil {
+		return SourcePos{}
+	}
+	return SourcePos{
+		File:   file,
+		Line:   node.Line,
+		Column: node.Column,
+	}
+}
+
+// IsSet returns true if the position is known.
+func (p SourcePos) IsSet() bool {
+	return p.Line > 0
+}
+
+func (p SourcePos) String() string {
+	if !p.IsSet() {
+		return ""
+	}
+	if p.File == "" {
+		return fmt.Sprintf("line %d column %d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// sourceError is an error in the definition of a script, at pos.
+type sourceError struct {
+	pos SourcePos
+	err error
+}
+
+func (e *sourceError) Error() string {
+	return fmt.Sprintf("%s: %s", e.pos, e.err)
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.err
+}
+
+// withSourcePos returns err located at pos, unless it is already located by a nested definition.
+func withSourcePos(pos SourcePos, err error) error {
+	if err == nil || !pos.IsSet() {
+		return err
+	}
+	var se *sourceError
+	if errors.As(err, &se) {
+		return err
+	}
+	return &sourceError{pos: pos, err: err}
+}
+
+// mapValue returns the value of key in a mapping node; nil if not found.
+func mapValue(node *yaml.Node, key string) *yaml.Node {
+	_, value := mapEntry(node, key)
+	return value
+}
+
+// mapEntry returns the key and value nodes of key in a mapping node; nil if not found.
+func mapEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value == key {
+			return node.Content[idx], node.Content[idx+1]
+		}
+	}
+	return nil, nil
+}
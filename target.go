@@ -15,6 +15,8 @@ import (
 
 	"github.com/imdario/mergo"
 	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -25,14 +27,18 @@ const (
 	// Capacity for the channel to collect control message from collectors.
 	capCollectChan = 100
 
-	upMetricName        = "up"
-	upMetricHelp        = "if the target is reachable 1, or 0 if the scrape failed"
-	scrapeDurationName  = "scrape_duration_seconds"
-	scrapeDurationHelp  = "How long it took to scrape the target in seconds"
-	collectorStatusName = "collector_status"
-	collectorStatusHelp = "collector scripts status 0: error - 1: ok - 2: Invalid login 3: Timeout"
-	queryStatusName     = "query_status"
-	queryStatusHelp     = "query http status label by phase(url): http return code"
+	upMetricName          = "up"
+	upMetricHelp          = "if the target is reachable 1, or 0 if the scrape failed"
+	scrapeDurationName    = "scrape_duration_seconds"
+	scrapeDurationHelp    = "How long it took to scrape the target in seconds"
+	collectorStatusName   = "collector_status"
+	collectorStatusHelp   = "collector scripts status 0: error - 1: ok - 2: Invalid login 3: Timeout"
+	queryStatusName       = "query_status"
+	queryStatusHelp       = "query http status label by phase(url): http return code"
+	collectorDurationName = "collector_duration_seconds"
+	collectorDurationHelp = "How long it took to run the collector scripts in seconds"
+	cacheAgeName          = "collector_cache_age_seconds"
+	cacheAgeHelp          = "age in seconds of the cached metrics of collector with min_interval"
 )
 
 // Target collects SQL metrics from a single sql.DB instance. It aggregates one or more Collectors and it looks much
@@ -61,10 +67,12 @@ type target struct {
 	client     *Client
 	collectors []Collector
 	// httpAPIScript       map[string]*YAMLScript
-	upDesc              MetricDesc
-	scrapeDurationDesc  MetricDesc
-	collectorStatusDesc MetricDesc
-	queryStatusDesc     MetricDesc
+	upDesc                MetricDesc
+	scrapeDurationDesc    MetricDesc
+	collectorStatusDesc   MetricDesc
+	queryStatusDesc       MetricDesc
+	cacheAgeDesc          MetricDesc
+	collectorDurationDesc MetricDesc
 
 	logContext []any
 
@@ -186,6 +194,18 @@ func NewTarget(
 		dto.MetricType_GAUGE, constLabelPairs,
 		"collectorname")
 
+	collectorDurationDesc := NewAutomaticMetricDesc(logContext,
+		profile.MetricPrefix+"_"+collectorDurationName,
+		collectorDurationHelp,
+		dto.MetricType_GAUGE, constLabelPairs,
+		"collectorname")
+
+	cacheAgeDesc := NewAutomaticMetricDesc(logContext,
+		profile.MetricPrefix+"_"+cacheAgeName,
+		cacheAgeHelp,
+		dto.MetricType_GAUGE, constLabelPairs,
+		"collectorname")
+
 	// testHisto := prometheus.NewHistogramVec(
 	// 	prometheus.HistogramOpts{
 	// 		Namespace: profile.MetricPrefix,
@@ -202,14 +222,16 @@ func NewTarget(
 		client:     newClient(tPar, profile.Scripts, logger, gc),
 		collectors: collectors,
 		// httpAPIScript:       profile.Scripts,
-		upDesc:              upDesc,
-		scrapeDurationDesc:  scrapeDurationDesc,
-		collectorStatusDesc: collectorStatusDesc,
-		queryStatusDesc:     queryStatusDesc,
-		logContext:          logContext,
-		logger:              logger,
-		content_mutex:       &sync.Mutex{},
-		queries_status:      make(map[string]any),
+		upDesc:                upDesc,
+		scrapeDurationDesc:    scrapeDurationDesc,
+		collectorStatusDesc:   collectorStatusDesc,
+		queryStatusDesc:       queryStatusDesc,
+		cacheAgeDesc:          cacheAgeDesc,
+		collectorDurationDesc: collectorDurationDesc,
+		logContext:            logContext,
+		logger:                logger,
+		content_mutex:         &sync.Mutex{},
+		queries_status:        make(map[string]any),
 	}
 	if t.client == nil {
 		return nil, errors.New("internal http client undefined")
@@ -443,6 +465,18 @@ func (t *target) Collect(ctx context.Context, met_ch chan<- Metric, health_only
 		colls_init  bool = false
 	)
 
+	scrapesInFlight.WithLabelValues(metricsTargetLabel(t.config)).Inc()
+	defer scrapesInFlight.WithLabelValues(metricsTargetLabel(t.config)).Dec()
+
+	ctx, span := tracer.Start(ctx, "collect "+t.name,
+		trace.WithAttributes(
+			attribute.String("target", t.name),
+			attribute.Bool("health_only", health_only)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("up", targetUp))
+		endSpan(span, err)
+	}()
+
 	// wait for all collectors are over
 	defer func() {
 		wg_coll.Wait()
@@ -460,6 +494,21 @@ func (t *target) Collect(ctx context.Context, met_ch chan<- Metric, health_only
 	t.client.symtab["__coll_channel"] = collectChan
 	msg_done_count := 0
 	t.client.SetContext(ctx)
+	if tracingEnabled {
+		// scripts played by target (ping, login) are traced as children of the collect span.
+		t.client.symtab[traceContextKey] = ctx
+	}
+	// debug scrape: actions and requests of target and collectors are recorded.
+	if report := scrapeReportFromContext(ctx); report != nil {
+		t.client.symtab[debugScrapeKey] = report
+	}
+	defer func() {
+		delete(t.client.symtab, traceContextKey)
+		delete(t.client.symtab, debugScrapeKey)
+	}()
+
+	// credentials obtained from secret providers may have been rotated
+	t.client.RefreshAuth()
 
 	// determine list of collectors: required in any cases to send status
 	if !health_only {
@@ -744,6 +793,16 @@ func (t *target) Collect(ctx context.Context, met_ch chan<- Metric, health_only
 						} else {
 							coll_ctx, cancel = context.WithDeadline(ctx, deadline)
 						}
+						// collector specific timeout: only this collector is stopped when it is reached.
+						if timeout := collector.GetTimeout(); timeout > 0 {
+							deadline_cancel := cancel
+							var timeout_cancel context.CancelFunc
+							coll_ctx, timeout_cancel = context.WithTimeoutCause(coll_ctx, timeout, errCollectorTimeout)
+							cancel = func() {
+								timeout_cancel()
+								deadline_cancel()
+							}
+						}
 						defer func() {
 							if r := recover(); r != nil {
 								err, ok := r.(error)
@@ -921,6 +980,14 @@ func (t *target) Collect(ctx context.Context, met_ch chan<- Metric, health_only
 					"coll", t.name)
 
 				met_ch <- NewMetric(t.collectorStatusDesc, float64(c.GetStatus()), labels_name, labels_value)
+				met_ch <- NewMetric(t.collectorDurationDesc, c.GetDuration().Seconds(), labels_name, labels_value)
+
+				// age of the cache for collector with min_interval
+				if cc, ok := c.(*cachingCollector); ok {
+					if age, ok := cc.CacheAge(); ok {
+						met_ch <- NewMetric(t.cacheAgeDesc, age.Seconds(), labels_name, labels_value)
+					}
+				}
 
 				// obtain set_stats from collector
 				c.SetSetStats(t)
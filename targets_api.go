@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// max size of a target definition sent to targets api
+const targetsApiMaxBody = 1 << 20
+
+// TargetsApiConfig defines the api to create, update and delete targets at runtime.
+type TargetsApiConfig struct {
+	Token      Secret `yaml:"token,omitempty" json:"token,omitempty"`             // bearer token required by the api
+	TokenFile  string `yaml:"token_file,omitempty" json:"token_file,omitempty"`   // file containing the token
+	TargetsDir string `yaml:"targets_dir,omitempty" json:"targets_dir,omitempty"` // directory to persist the targets; not persisted if not set
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for TargetsApiConfig.
+func (tc *TargetsApiConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TargetsApiConfig
+	if err := unmarshal((*plain)(tc)); err != nil {
+		return err
+	}
+	if tc.TokenFile != "" {
+		content, err := os.ReadFile(tc.TokenFile)
+		if err != nil {
+			return fmt.Errorf("targets_api: can't read token_file: %s", err)
+		}
+		tc.Token = Secret(strings.TrimSpace(string(content)))
+	}
+	if tc.Token == "" {
+		return fmt.Errorf("targets_api: a token or a token_file is required")
+	}
+	if tc.TargetsDir != "" {
+		if info, err := os.Stat(tc.TargetsDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("targets_api: targets_dir '%s' is not a directory", tc.TargetsDir)
+		}
+	}
+	redactor.AddSecrets(string(tc.Token))
+	return checkOverflow(tc.XXX, "targets_api")
+}
+
+// chars not allowed in the names of the files of persisted targets.
+var targetFileNameRE = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// targetFile returns the path of the file that persists target name. Chars not allowed are escaped as %XX, and '%'
+// itself, so that two targets never share the same file.
+func (tc *TargetsApiConfig) targetFile(name string) string {
+	file_name := targetFileNameRE.ReplaceAllStringFunc(name, func(char string) string {
+		var res strings.Builder
+		for _, b := range []byte(char) {
+			fmt.Fprintf(&res, "%%%02X", b)
+		}
+		return res.String()
+	})
+	return filepath.Join(tc.TargetsDir, file_name+".yml")
+}
+
+// apiError is the body of targets api error responses.
+type apiError struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// writeApiResponse sends value as json body.
+func writeApiResponse(w http.ResponseWriter, status int, value any) {
+	res, err := json.Marshal(value)
+	if err != nil {
+		status = http.StatusInternalServerError
+		res, _ = json.Marshal(&apiError{Status: "error", Error: err.Error()})
+	}
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	w.Header().Set(contentLengthHeader, fmt.Sprint(len(res)))
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+// writeApiError sends err as json body.
+func writeApiError(w http.ResponseWriter, status int, err error) {
+	writeApiResponse(w, status, &apiError{Status: "error", Error: err.Error()})
+}
+
+// TargetsApiHandlerFunc is the HTTP handler for the `/api/v1/targets` api. It lists (GET), creates (POST), updates
+// (PUT) and deletes (DELETE) static targets at runtime. Requests must be authenticated with the bearer token of
+// targets_api global config.
+func TargetsApiHandlerFunc(metricsPath string, exporter Exporter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api := exporter.Config().Globals.TargetsApi
+		if api == nil {
+			writeApiError(w, http.StatusNotFound, errors.New("targets api is not enabled"))
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(api.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeApiError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+
+		var name string
+		if ctxVal, ok := r.Context().Value(ctxKey{}).(*ctxValue); ok {
+			name = ctxVal.path
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if name == "" {
+				targets := make([]*TargetConfig, 0, len(exporter.Config().Targets))
+				for _, t := range exporter.Config().Targets {
+					if t.targetType == TargetTypeStatic {
+						targets = append(targets, t)
+					}
+				}
+				writeApiResponse(w, http.StatusOK, targets)
+				return
+			}
+			target, err := exporter.FindTarget(name)
+			if err != nil {
+				writeApiError(w, http.StatusNotFound, err)
+				return
+			}
+			writeApiResponse(w, http.StatusOK, target.Config())
+
+		case http.MethodPost, http.MethodPut:
+			if r.Method == http.MethodPost && name != "" {
+				writeApiError(w, http.StatusMethodNotAllowed, errors.New("POST is only allowed on /api/v1/targets"))
+				return
+			}
+			if r.Method == http.MethodPut && name == "" {
+				writeApiError(w, http.StatusMethodNotAllowed, errors.New("PUT requires a target name: /api/v1/targets/<name>"))
+				return
+			}
+			status, tg_config, err := setTargetFromApi(exporter, api, name, r.Body)
+			if err != nil {
+				writeApiError(w, status, err)
+				return
+			}
+			exporter.Logger().Info(fmt.Sprintf("target '%s' set by api", tg_config.Name), "file", tg_config.fromFile)
+			writeApiResponse(w, status, tg_config)
+
+		case http.MethodDelete:
+			if name == "" {
+				writeApiError(w, http.StatusMethodNotAllowed, errors.New("DELETE requires a target name: /api/v1/targets/<name>"))
+				return
+			}
+			target, err := exporter.FindTarget(name)
+			if err != nil {
+				writeApiError(w, http.StatusNotFound, err)
+				return
+			}
+			if err := checkApiManaged(target.Config(), api); err != nil {
+				writeApiError(w, http.StatusConflict, err)
+				return
+			}
+			if file := target.Config().fromFile; file != "" {
+				if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+					writeApiError(w, http.StatusInternalServerError, err)
+					return
+				}
+			}
+			if err := exporter.RemoveTarget(name); err != nil {
+				writeApiError(w, http.StatusNotFound, err)
+				return
+			}
+			exporter.Logger().Info(fmt.Sprintf("target '%s' deleted by api", name))
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	}
+}
+
+// checkApiManaged returns an error if target is defined in config files not managed by the api: changes would be
+// lost or conflict with the files at next reload.
+func checkApiManaged(t *TargetConfig, api *TargetsApiConfig) error {
+	if t.targetType != TargetTypeStatic {
+		return fmt.Errorf("target '%s' is not a static target", t.Name)
+	}
+	if t.fromApi {
+		return nil
+	}
+	if t.fromFile != "" && api.TargetsDir != "" && filepath.Dir(t.fromFile) == filepath.Clean(api.TargetsDir) {
+		return nil
+	}
+	if t.fromFile != "" {
+		return fmt.Errorf("target '%s' is defined in file '%s': it can't be modified by api", t.Name, t.fromFile)
+	}
+	return fmt.Errorf("target '%s' is defined in config file: it can't be modified by api", t.Name)
+}
+
+// checkApiTargetValues returns an error if the definition of a target sent to the api reads values on the exporter
+// host: environment variables, files or output of commands; they would be sent as credentials to the host of the
+// target. Such credentials must be set in the auth_configs of the config and referenced by auth_name.
+func checkApiTargetValues(raw map[string]any) error {
+	if auth, ok := raw["auth_config"].(map[string]any); ok {
+		if _, found := auth["auth_key_file"]; found {
+			return errors.New("auth_config: auth_key_file is not allowed: use auth_name to reference an auth config of the exporter")
+		}
+		for _, key := range []string{"user", "password", "token"} {
+			if value, ok := auth[key].(string); ok && (isSecretRef(value) || strings.HasPrefix(value, "$env:")) {
+				return fmt.Errorf("auth_config: %s can't be read from a secret provider or an environment variable: use auth_name to reference an auth config of the exporter", key)
+			}
+		}
+	}
+	var check func(path string, value any) error
+	check = func(path string, value any) error {
+		switch val := value.(type) {
+		case string:
+			// env vars would be replaced when the persisted file is loaded again
+			if strings.Contains(val, "${") || strings.HasPrefix(val, "$env:") {
+				return fmt.Errorf("%s: environment variables are not allowed", path)
+			}
+		case map[string]any:
+			for key, sub := range val {
+				if err := check(path+"."+key, key); err != nil {
+					return err
+				}
+				if err := check(path+"."+key, sub); err != nil {
+					return err
+				}
+			}
+		case map[any]any:
+			for key, sub := range val {
+				if err := check(fmt.Sprintf("%s.%v", path, key), sub); err != nil {
+					return err
+				}
+			}
+		case []any:
+			for idx, sub := range val {
+				if err := check(fmt.Sprintf("%s[%d]", path, idx), sub); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for key, value := range raw {
+		if err := check(key, key); err != nil {
+			return err
+		}
+		if err := check(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTargetFromApi validates the target definition of body and creates it if name is empty, else replaces target
+// name. It returns the status of the response.
+func setTargetFromApi(exporter Exporter, api *TargetsApiConfig, name string, body io.Reader) (int, *TargetConfig, error) {
+	buf, err := io.ReadAll(io.LimitReader(body, targetsApiMaxBody))
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	// definition may be sent in json or yaml format; it is kept as sent to be persisted: secrets are not masked.
+	var raw map[string]any
+	if err := yaml.Unmarshal(buf, &raw); err != nil {
+		return http.StatusBadRequest, nil, fmt.Errorf("invalid target definition: %s", err)
+	}
+	if raw == nil {
+		return http.StatusBadRequest, nil, errors.New("empty target definition")
+	}
+	if err := checkApiTargetValues(raw); err != nil {
+		return http.StatusBadRequest, nil, fmt.Errorf("invalid target definition: %s", err)
+	}
+	if name != "" {
+		if body_name, ok := raw["name"]; ok && body_name != name {
+			return http.StatusBadRequest, nil, fmt.Errorf("target name '%v' differs from url '%s'", body_name, name)
+		}
+		raw["name"] = name
+	}
+	content, err := yaml.Marshal(raw)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	tg_config := &TargetConfig{}
+	if err := yaml.Unmarshal(content, tg_config); err != nil {
+		return http.StatusBadRequest, nil, fmt.Errorf("invalid target definition: %s", err)
+	}
+	if tg_config.targetType != TargetTypeStatic {
+		return http.StatusBadRequest, nil, fmt.Errorf("target '%s' is not a static target", tg_config.Name)
+	}
+	if err := exporter.Config().SetupTarget(tg_config); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	status := http.StatusCreated
+	existing, err := exporter.FindTarget(tg_config.Name)
+	if err == nil {
+		if name == "" {
+			return http.StatusConflict, nil, fmt.Errorf("target '%s' already exists", tg_config.Name)
+		}
+		if err := checkApiManaged(existing.Config(), api); err != nil {
+			return http.StatusConflict, nil, err
+		}
+		// target is replaced in the file that defines it, else it would be defined twice at next reload.
+		tg_config.setFromFile(existing.Config().fromFile)
+		status = http.StatusOK
+	} else if name != "" {
+		return http.StatusNotFound, nil, err
+	}
+
+	tg_config.fromApi = true
+	if api.TargetsDir != "" {
+		file := tg_config.fromFile
+		if file == "" {
+			file = api.targetFile(tg_config.Name)
+		}
+		// write a temporary file then rename it, to never leave a partially written file.
+		tmp := file + ".tmp"
+		if err := os.WriteFile(tmp, content, 0o600); err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		if err := os.Rename(tmp, file); err != nil {
+			os.Remove(tmp)
+			return http.StatusInternalServerError, nil, err
+		}
+		tg_config.setFromFile(file)
+	}
+	if _, err := exporter.SetTarget(tg_config); err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	return status, tg_config, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTargetsApiConfig(t *testing.T) {
+	dir := t.TempDir()
+	token_file := filepath.Join(dir, "token")
+	if err := os.WriteFile(token_file, []byte("api-token-value\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &TargetsApiConfig{}
+	err := yaml.Unmarshal([]byte("token_file: "+token_file+"\ntargets_dir: "+dir+"\n"), api)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Secret("api-token-value"), api.Token)
+	assert.Equal(t, filepath.Join(dir, "my%2Ftarget%3A8443.yml"), api.targetFile("my/target:8443"))
+	// escaped names don't collide
+	files := map[string]string{}
+	for _, name := range []string{"a/b", "a:b", "a_b", "a%2Fb", "a%b"} {
+		file := api.targetFile(name)
+		assert.NotContains(t, files, file, name)
+		files[file] = name
+	}
+
+	assert.Error(t, yaml.Unmarshal([]byte("targets_dir: "+dir+"\n"), &TargetsApiConfig{}), "token is required")
+	assert.Error(t, yaml.Unmarshal([]byte("token: xxxx\ntargets_dir: "+filepath.Join(dir, "none")+"\n"), &TargetsApiConfig{}))
+	assert.Error(t, yaml.Unmarshal([]byte("token: xxxx\nunknown: 1\n"), &TargetsApiConfig{}))
+
+	// only targets created by api or persisted in targets_dir may be modified
+	assert.NoError(t, checkApiManaged(&TargetConfig{Name: "t1", fromApi: true}, api))
+	assert.NoError(t, checkApiManaged(&TargetConfig{Name: "t2", fromFile: filepath.Join(dir, "t2.yml")}, api))
+	assert.Error(t, checkApiManaged(&TargetConfig{Name: "t3", fromFile: "/etc/targets/t3.yml"}, api))
+	assert.Error(t, checkApiManaged(&TargetConfig{Name: "t4"}, api))
+	assert.Error(t, checkApiManaged(&TargetConfig{Name: "default", targetType: TargetTypeModel, fromApi: true}, api))
+}
+
+func TestTargetsApiHandler(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	dir := t.TempDir()
+	copyDir(t, "contribs/apache/etc/apache", dir)
+	api_dir := filepath.Join(dir, "api")
+	if err := os.Mkdir(api_dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	config_file := filepath.Join(dir, "config.yml")
+	content, _ := os.ReadFile(config_file)
+	content = []byte(strings.Replace(string(content), "global:\n",
+		"global:\n  targets_api:\n    token: api-secret\n    targets_dir: "+api_dir+"\n", 1))
+	content = []byte(strings.Replace(string(content), `  - targets_files: [ "targets/*.yml" ]`,
+		`  - targets_files: [ "targets/*.yml", "api/*.yml" ]`, 1))
+	if err := os.WriteFile(config_file, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	target_def := func(name, host string) string {
+		return "name: " + name + "\nhost: " + host + "\nprofile: apache\ncollectors:\n  - ~ apache_.*\n"
+	}
+	// target defined in a file not managed by api, and a hand-written file of targets_dir
+	if err := os.WriteFile(filepath.Join(dir, "targets", "static.yml"), []byte(target_def("static", "1.2.3.1")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(api_dir, "foo.yml"), []byte(target_def("bar", "1.2.3.2")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewExporter(config_file, "", logger, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	handler := BuildHandler(e, nil)
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// authentication
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/v1/targets", "", "").Code)
+	w := request(http.MethodGet, "/api/v1/targets", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	// create: persisted in targets_dir
+	w = request(http.MethodPost, "/api/v1/targets", "api-secret", target_def("web:8443", "1.2.3.4"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	web_file := filepath.Join(api_dir, "web%3A8443.yml")
+	assert.FileExists(t, web_file)
+	target, err := e.FindTarget("web:8443")
+	if assert.NoError(t, err) {
+		assert.Equal(t, web_file, target.Config().fromFile)
+	}
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/api/v1/targets", "api-secret", target_def("web:8443", "1.2.3.4")).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/v1/targets", "api-secret", "name: [").Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/v1/targets", "api-secret",
+		"name: web2\nhost: 1.2.3.5\ncollectors:\n  - unknown\n").Code)
+
+	// values read on the exporter host are rejected: env vars, files, commands output
+	for _, def := range []string{
+		"auth_config:\n  user: admin\n  password: \"$exec:cat /etc/shadow\"\n",
+		"auth_config:\n  user: admin\n  password: \"$file:/etc/shadow\"\n",
+		"auth_config:\n  user: admin\n  token: \"$vault:secret/data/app#token\"\n",
+		"auth_config:\n  user: \"$env:AWS_SECRET_ACCESS_KEY\"\n",
+		"auth_config:\n  user: admin\n  password: /encrypted/xxxx\n  auth_key_file: /etc/shadow\n",
+		"labels:\n  env: \"${AWS_SECRET_ACCESS_KEY}\"\n",
+		"customs:\n  path: \"/x/${HOME:-none}\"\n",
+		"port: \"$env:HOME\"\n",
+	} {
+		w = request(http.MethodPost, "/api/v1/targets", "api-secret", target_def("leak", "1.2.3.10")+def)
+		assert.Equal(t, http.StatusBadRequest, w.Code, def)
+		assert.NoFileExists(t, filepath.Join(api_dir, "leak.yml"), def)
+		_, err = e.FindTarget("leak")
+		assert.Error(t, err, def)
+	}
+	// plain credentials are allowed
+	w = request(http.MethodPost, "/api/v1/targets", "api-secret", target_def("plain", "1.2.3.11")+"auth_config:\n  user: admin\n  password: secret\n")
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// get
+	w = request(http.MethodGet, "/api/v1/targets/web:8443", "api-secret", "")
+	if assert.Equal(t, http.StatusOK, w.Code) {
+		var res map[string]any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, "1.2.3.4", res["host"])
+	}
+	w = request(http.MethodGet, "/api/v1/targets", "api-secret", "")
+	if assert.Equal(t, http.StatusOK, w.Code) {
+		var res []map[string]any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		names := []any{}
+		for _, tg := range res {
+			names = append(names, tg["name"])
+		}
+		assert.Contains(t, names, "web:8443")
+		assert.NotContains(t, names, "default")
+	}
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/v1/targets/unknown", "api-secret", "").Code)
+
+	// update
+	w = request(http.MethodPut, "/api/v1/targets/web:8443", "api-secret", target_def("web:8443", "1.2.3.6"))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	content, _ = os.ReadFile(web_file)
+	assert.Contains(t, string(content), "1.2.3.6")
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPut, "/api/v1/targets/unknown", "api-secret", target_def("unknown", "1.2.3.7")).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, "/api/v1/targets/web:8443", "api-secret", target_def("other", "1.2.3.7")).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPut, "/api/v1/targets", "api-secret", target_def("web:8443", "1.2.3.7")).Code)
+
+	// update of a target of targets_dir: the file that defines it is replaced
+	w = request(http.MethodPut, "/api/v1/targets/bar", "api-secret", target_def("bar", "1.2.3.8"))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	content, _ = os.ReadFile(filepath.Join(api_dir, "foo.yml"))
+	assert.Contains(t, string(content), "1.2.3.8")
+	assert.NoFileExists(t, filepath.Join(api_dir, "bar.yml"))
+
+	// targets of files not managed by api, and models, can't be changed
+	assert.Equal(t, http.StatusConflict, request(http.MethodPut, "/api/v1/targets/static", "api-secret", target_def("static", "1.2.3.9")).Code)
+	assert.Equal(t, http.StatusConflict, request(http.MethodDelete, "/api/v1/targets/static", "api-secret", "").Code)
+	assert.Equal(t, http.StatusConflict, request(http.MethodDelete, "/api/v1/targets/default", "api-secret", "").Code)
+	assert.FileExists(t, filepath.Join(dir, "targets", "static.yml"))
+
+	// delete: file is removed
+	assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/api/v1/targets/web:8443", "api-secret", "").Code)
+	assert.NoFileExists(t, web_file)
+	_, err = e.FindTarget("web:8443")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/api/v1/targets/web:8443", "api-secret", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodDelete, "/api/v1/targets", "api-secret", "").Code)
+}
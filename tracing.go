@@ -0,0 +1,170 @@
+// cSpell:ignore otel, otlp, otlptrace, otlptracehttp, stdouttrace, sdktrace, semconv
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/prometheus/common/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// key of the symbols table that stores the context of the current span:
+// scripts and actions spans are children of it.
+const traceContextKey = "__context"
+
+// tracer used for all spans; it is a no-op tracer until InitTracing() has set a provider.
+var (
+	tracer         trace.Tracer = otel.Tracer(exporter_name)
+	tracingEnabled bool
+)
+
+// TracingConfig defines the OpenTelemetry exporter of the spans of scrapes.
+type TracingConfig struct {
+	Exporter    string             `yaml:"exporter,omitempty" json:"exporter,omitempty"`         // otlp, stdout or file; default otlp
+	Endpoint    string             `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`         // otlp http receiver: host:port or url
+	Insecure    ConvertibleBoolean `yaml:"insecure,omitempty" json:"insecure,omitempty"`         // use http instead of https for otlp
+	Headers     map[string]string  `yaml:"headers,omitempty" json:"-"`                           // headers sent with otlp requests; not exposed: may contain credentials
+	File        string             `yaml:"file,omitempty" json:"file,omitempty"`                 // path of the file for file exporter
+	SampleRatio *float64           `yaml:"sample_ratio,omitempty" json:"sample_ratio,omitempty"` // ratio of scrapes traced; default 1
+	ServiceName string             `yaml:"service_name,omitempty" json:"service_name,omitempty"` // default exporter_name
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for TracingConfig.
+func (tc *TracingConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TracingConfig
+	if err := unmarshal((*plain)(tc)); err != nil {
+		return err
+	}
+	tc.Exporter = strings.ToLower(tc.Exporter)
+	switch tc.Exporter {
+	case "":
+		tc.Exporter = "otlp"
+	case "otlp", "stdout":
+	case "file":
+		if tc.File == "" {
+			return fmt.Errorf("tracing: file exporter requires a file")
+		}
+	default:
+		return fmt.Errorf("tracing: invalid exporter '%s': should be ('otlp', 'stdout', 'file')", tc.Exporter)
+	}
+	if tc.SampleRatio != nil && (*tc.SampleRatio < 0 || *tc.SampleRatio > 1) {
+		return fmt.Errorf("tracing: sample_ratio must be between 0 and 1, have %g", *tc.SampleRatio)
+	}
+	return checkOverflow(tc.XXX, "tracing")
+}
+
+// InitTracing sets the global tracer provider from config.
+// It returns a function to flush and stop the exporter.
+func InitTracing(tc *TracingConfig, service_name string) (func(context.Context) error, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch tc.Exporter {
+	case "stdout", "file":
+		var w io.Writer = os.Stdout
+		if tc.Exporter == "file" {
+			f, err := os.OpenFile(tc.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("tracing: can't open file: %s", err)
+			}
+			w = f
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		opts := make([]otlptracehttp.Option, 0, 3)
+		if tc.Endpoint != "" {
+			if strings.Contains(tc.Endpoint, "://") {
+				opts = append(opts, otlptracehttp.WithEndpointURL(tc.Endpoint))
+			} else {
+				opts = append(opts, otlptracehttp.WithEndpoint(tc.Endpoint))
+			}
+		}
+		if tc.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(tc.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(tc.Headers))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: can't build %s exporter: %s", tc.Exporter, err)
+	}
+
+	if tc.ServiceName != "" {
+		service_name = tc.ServiceName
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(service_name),
+		semconv.ServiceVersion(version.Version),
+	)
+	ratio := 1.0
+	if tc.SampleRatio != nil {
+		ratio = *tc.SampleRatio
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	tracingEnabled = true
+
+	return provider.Shutdown, nil
+}
+
+// symtabContext returns the context of the current span stored in symbols table, or ctx if not set.
+func symtabContext(symtab map[string]any, ctx context.Context) context.Context {
+	if raw, ok := symtab[traceContextKey]; ok {
+		if s_ctx, ok := raw.(context.Context); ok {
+			return s_ctx
+		}
+	}
+	return ctx
+}
+
+// startSymtabSpan starts a child span of the one stored in symbols table, and stores it as the current one.
+// The returned function must be called to end the span and restore the parent one.
+func startSymtabSpan(symtab map[string]any, name string, attrs ...attribute.KeyValue) (trace.Span, func(error)) {
+	if !tracingEnabled {
+		return trace.SpanFromContext(context.Background()), func(error) {}
+	}
+	old_ctx, has_old := symtab[traceContextKey]
+	ctx, span := tracer.Start(symtabContext(symtab, context.Background()), name, trace.WithAttributes(attrs...))
+	symtab[traceContextKey] = ctx
+
+	return span, func(err error) {
+		endSpan(span, err)
+		if has_old {
+			symtab[traceContextKey] = old_ctx
+		} else {
+			delete(symtab, traceContextKey)
+		}
+	}
+}
+
+// endSpan sets the error status on span if any, then ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
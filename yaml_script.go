@@ -10,6 +10,7 @@ import (
 	"text/template"
 
 	"github.com/peekjef72/httpapi_exporter/goja_modules"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v3"
 )
 
@@ -23,6 +24,10 @@ type YAMLScript struct {
 	setStatsActions []*SetStatsAction
 	queryActions    []*QueryAction
 	registry        *goja_modules.JSRegistry
+
+	// file where the script is defined, and files of actions defined in another one (inherited by a profile)
+	file      string
+	nodeFiles map[*yaml.Node]string
 }
 
 //******************************************************************
@@ -66,7 +71,14 @@ type Action interface {
 
 type ActionsList []Action
 
-func (sc *YAMLScript) Play(symtab map[string]any, ignore_errors bool, logger *slog.Logger) error {
+func (sc *YAMLScript) Play(symtab map[string]any, ignore_errors bool, logger *slog.Logger) (err error) {
+	_, end_span := startSymtabSpan(symtab, "script "+sc.name,
+		attribute.String("script", sc.name),
+		attribute.String("collector", GetMapValueString(symtab, "__collector_id")))
+	defer func() {
+		end_span(err)
+	}()
+
 	symtab["__name__"] = sc.name
 	symtab["__logger"] = logger
 	for _, ac := range sc.Actions {
@@ -141,6 +153,8 @@ type BaseAction interface {
 	SetVars([][]any)
 	GetUntil() []*Field
 	SetUntil([]*Field)
+	GetPos() SourcePos
+	SetPos(SourcePos)
 }
 
 func setBasicElement(
@@ -463,7 +477,26 @@ func preserve_sym_tab(symtab map[string]any, old_values map[string]any, key stri
 	return nil
 }
 
-func PlayBaseAction(script *YAMLScript, symtab map[string]any, logger *slog.Logger, ba Action, customAction func(*YAMLScript, map[string]any, *slog.Logger) error) error {
+func PlayBaseAction(script *YAMLScript, symtab map[string]any, logger *slog.Logger, ba Action, customAction func(*YAMLScript, map[string]any, *slog.Logger) error) (err error) {
+	if tracingEnabled {
+		action_name := ba.GetName(symtab, logger)
+		action_type := strings.TrimSuffix(ba.TypeName(), "_action")
+		_, end_span := startSymtabSpan(symtab, action_type+" "+action_name,
+			attribute.String("action.type", action_type),
+			attribute.String("action.name", action_name))
+		defer func() {
+			end_span(err)
+		}()
+	}
+
+	// record action for debug scrape: symbols changes are computed once local vars are restored.
+	var dbg_act *ActionTrace
+	if report := scrapeReportFromSymtab(symtab); report != nil {
+		dbg_act = report.StartAction(symtab, strings.TrimSuffix(ba.TypeName(), "_action"), ba.GetName(symtab, logger), ba.GetPos())
+		defer func() {
+			report.EndAction(dbg_act, symtab, err)
+		}()
+	}
 
 	// to preserve values from symtab
 	old_values := make(map[string]any)
@@ -616,6 +649,9 @@ func PlayBaseAction(script *YAMLScript, symtab map[string]any, logger *slog.Logg
 			}
 		}
 		items = final_items
+		if dbg_act != nil {
+			dbg_act.SetLoopItems(items)
+		}
 		baLoopVar := ba.GetLoopVar()
 		if baLoopVar != "" {
 			loop_var = baLoopVar
@@ -659,6 +695,9 @@ func PlayBaseAction(script *YAMLScript, symtab map[string]any, logger *slog.Logg
 
 				for _, cond_var := range baWhen {
 					cond, err := cond_var.EvalCond(symtab, logger)
+					if dbg_act != nil {
+						dbg_act.AddWhen(idx, cond_var.String(), cond, err)
+					}
 					if err != nil {
 						return fmt.Errorf("invalid value for 'when' %s: %s", cond_var.String(), err)
 					}
@@ -696,6 +735,9 @@ func PlayBaseAction(script *YAMLScript, symtab map[string]any, logger *slog.Logg
 			baUntil := ba.GetUntil()
 			for _, cond_var := range baUntil {
 				cond, err := cond_var.EvalCond(symtab, logger)
+				if dbg_act != nil {
+					dbg_act.AddWhen(idx, cond_var.String(), cond, err)
+				}
 				if err != nil {
 					err := fmt.Errorf("invalid template value for 'until' %s: %s", cond_var.String(), err)
 					logger.Warn(
@@ -933,7 +975,7 @@ func (script *YAMLScript) UnmarshalYAML(value *yaml.Node) error {
 	if err := value.Decode(&tmp); err != nil {
 		return err
 	}
-	actions, err := ActionsListDecode(script, make(ActionsList, 0, len(tmp)), tmp, value)
+	actions, err := ActionsListDecode(script, make(ActionsList, 0, len(tmp)), tmp, value, script.file)
 	if err != nil {
 		return err
 	}
@@ -942,7 +984,13 @@ func (script *YAMLScript) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
-func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions, parentNode *yaml.Node) (ActionsList, error) {
+// ActionsListDecode parses the actions of tmp, defined by the nodes of parentNode in file. Errors are prefixed by the
+// position of the action.
+func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions, parentNode *yaml.Node, file string) (_ ActionsList, err error) {
+	var pos SourcePos
+	defer func() {
+		err = withSourcePos(pos, err)
+	}()
 	main_checker := map[string]bool{
 		"name":       true,
 		"loop":       true,
@@ -964,6 +1012,15 @@ func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions,
 		skip_checker := false
 		cur_act := tmp[i]
 
+		// actions inherited from another profile are defined in its file
+		act_node := parentNode.Content[i]
+		act_file := file
+		if f, ok := script.nodeFiles[act_node]; ok {
+			act_file = f
+		}
+		pos = nodePos(act_file, act_node)
+		nb_actions := len(actions)
+
 		// parse name
 		if raw, ok := cur_act["name"]; ok {
 			nameVal = raw.Value
@@ -1134,8 +1191,9 @@ func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions,
 			checker["metric_name"] = true
 			mc := &MetricConfig{
 				registry: script.registry,
+				pos:      pos,
 			}
-			if err := parentNode.Content[i].Decode(mc); err != nil {
+			if err := act_node.Decode(mc); err != nil {
 				return nil, err
 			}
 			// MAYBE mc.Name should be a Field so that the name could be a template !!
@@ -1159,7 +1217,7 @@ func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions,
 					return nil, err
 				}
 
-				acta, err := ActionsListDecode(script, make(ActionsList, 0, len(tmp_sub)), tmp_sub, &raw)
+				acta, err := ActionsListDecode(script, make(ActionsList, 0, len(tmp_sub)), tmp_sub, &raw, act_file)
 				if err != nil {
 					return nil, err
 				}
@@ -1192,7 +1250,7 @@ func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions,
 					return nil, err
 				}
 
-				acta, err := ActionsListDecode(script, make(ActionsList, 0, len(tmp_sub)), tmp_sub, &raw)
+				acta, err := ActionsListDecode(script, make(ActionsList, 0, len(tmp_sub)), tmp_sub, &raw, act_file)
 				if err != nil {
 					return nil, err
 				}
@@ -1294,6 +1352,10 @@ func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions,
 			// return nil, fmt.Errorf("unknown action type: +%v", cur_act)
 		}
 
+		if len(actions) > nb_actions {
+			locateAction(actions[len(actions)-1], act_file, act_node)
+		}
+
 		if !skip_checker {
 			for name, raw := range cur_act {
 				if _, ok := checker[name]; !ok {
@@ -1306,4 +1368,111 @@ func ActionsListDecode(script *YAMLScript, actions ActionsList, tmp tmpActions,
 	return actions, nil
 }
 
+// locateAction sets the position of the definition of the action a and of its fields, from the yaml node of the
+// action.
+func locateAction(a Action, file string, node *yaml.Node) {
+	a.SetPos(nodePos(file, node))
+	// locate sets the position of the node of key in parent on the fields of value; the action one if not found.
+	locate := func(parent *yaml.Node, key string, value any) {
+		pos := nodePos(file, mapValue(parent, key))
+		if !pos.IsSet() {
+			pos = a.GetPos()
+		}
+		setFieldsPos(value, pos)
+	}
+	// locateList sets the position of each element of a list, or of the node of key for a single element.
+	locateList := func(key string, values []any) {
+		list := mapValue(node, key)
+		if list == nil || list.Kind != yaml.SequenceNode || len(list.Content) != len(values) {
+			locate(node, key, values)
+			return
+		}
+		for idx, value := range values {
+			setFieldsPos(value, nodePos(file, list.Content[idx]))
+		}
+	}
+	// locatePairs sets the position of the keys and values of a set_fact, set_stats or vars map.
+	locatePairs := func(key string, pairs [][]any) {
+		values := mapValue(node, key)
+		for _, pair := range pairs {
+			key, ok := pair[0].(*Field)
+			if !ok || len(pair) < 2 {
+				continue
+			}
+			key_node, value_node := mapEntry(values, key.raw)
+			if key_node == nil {
+				key_node, value_node = values, values
+			}
+			setFieldsPos(pair[0], nodePos(file, key_node))
+			setFieldsPos(pair[1], nodePos(file, value_node))
+		}
+	}
+	asList := func(fields []*Field) []any {
+		values := make([]any, len(fields))
+		for idx, f := range fields {
+			values[idx] = f
+		}
+		return values
+	}
+
+	locate(node, "name", a.GetNameField())
+	locateList("when", asList(a.GetWhen()))
+	locateList("until", asList(a.GetUntil()))
+	if mapValue(node, "with_items") != nil {
+		locateList("with_items", a.GetWith())
+	} else {
+		locateList("loop", a.GetWith())
+	}
+	locatePairs("vars", a.GetVars())
+
+	switch act := a.(type) {
+	case *SetFactAction:
+		locatePairs("set_fact", act.setFact)
+	case *SetStatsAction:
+		locatePairs("set_stats", act.setStats)
+	case *DebugAction:
+		locate(mapValue(node, "debug"), "msg", act.Debug.msg)
+	case *QueryAction:
+		q := act.Query
+		query := mapValue(node, "query")
+		locate(query, "url", q.query)
+		locate(query, "method", q.method)
+		locate(query, "data", q.data)
+		locate(query, "var_name", q.var_name)
+		locate(query, "status_label", q.status_label)
+		auth := mapValue(query, "auth_config")
+		locate(auth, "mode", q.auth_mode)
+		locate(auth, "user", q.user)
+		locate(auth, "password", q.passwd)
+		locate(auth, "token", q.token)
+	case *MetricAction:
+		mc := act.mc
+		locate(node, "metric_name", mc.name)
+		locate(node, "help", mc.help)
+		locate(node, "type", mc.metric_type)
+		locate(node, "key_labels", mc.key_labels)
+		locate(node, "created", mc.created)
+		locate(node, "exemplar", mc.exemplar)
+	}
+}
+
+// setFieldsPos sets pos on the fields of value not already located: a field, or lists and maps of fields.
+func setFieldsPos(value any, pos SourcePos) {
+	switch v := value.(type) {
+	case *Field:
+		if v != nil && !v.pos.IsSet() {
+			v.pos = pos
+		}
+	case []any:
+		for _, elmt := range v {
+			setFieldsPos(elmt, pos)
+		}
+	case map[any]any:
+		for key, elmt := range v {
+			setFieldsPos(key, pos)
+			setFieldsPos(elmt, pos)
+		}
+	}
+}
+
 // ***************************************************************************************
@@ -3,12 +3,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"os"
 	"strings"
 	"testing"
 
+	"github.com/peekjef72/httpapi_exporter/goja_modules"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -1394,3 +1400,184 @@ func TestValorizeValueMap2LvlVarName2VarWithIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestPlayTracing(t *testing.T) {
+	initTest()
+	logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	// record spans in memory
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	old_tracer := tracer
+	tracer = provider.Tracer("test")
+	tracingEnabled = true
+	defer func() {
+		tracer = old_tracer
+		tracingEnabled = false
+	}()
+
+	code := `
+    - name: set var
+      set_fact:
+        test: 1
+    - name: loop
+      actions:
+        - name: sub set var
+          set_fact:
+            test2: 2
+`
+	registry, _ := goja_modules.InitJSRegistry(logger, nil)
+	script := &YAMLScript{
+		name:     "test",
+		registry: registry,
+	}
+	if err := yaml.Unmarshal([]byte(code), &script); !assert.Nil(t, err) {
+		return
+	}
+	symtab["__collector_id"] = "yaml_script_test.go"
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	symtab[traceContextKey] = ctx
+	if err := script.Play(symtab, false, logger); !assert.Nil(t, err) {
+		return
+	}
+	root.End()
+
+	// parent context is restored after play
+	assert.Equal(t, ctx, symtab[traceContextKey])
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	if !assert.Equal(t, 5, len(spans)) {
+		return
+	}
+	assert.Equal(t, spans["root"].SpanContext().SpanID(), spans["script test"].Parent().SpanID())
+	assert.Equal(t, spans["script test"].SpanContext().SpanID(), spans["set_fact set var"].Parent().SpanID())
+	assert.Equal(t, spans["script test"].SpanContext().SpanID(), spans["actions loop"].Parent().SpanID())
+	assert.Equal(t, spans["actions loop"].SpanContext().SpanID(), spans["set_fact sub set var"].Parent().SpanID())
+}
+
+func TestPlayDebugScrape(t *testing.T) {
+	initTest()
+	logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	code := `
+    - name: set vars
+      set_fact:
+        test: 1
+        password: secret
+    - name: loop
+      set_fact:
+        item_{{ .item }}: "{{ .item }}"
+      with_items: [ "a", "b" ]
+      when:
+        - eq .item "a"
+`
+	registry, _ := goja_modules.InitJSRegistry(logger, nil)
+	script := &YAMLScript{
+		name:     "test",
+		registry: registry,
+	}
+	if err := yaml.Unmarshal([]byte(code), &script); !assert.Nil(t, err) {
+		return
+	}
+	symtab["__collector_id"] = "yaml_script_test.go"
+
+	report := NewScrapeReport("test", nil)
+	symtab[debugScrapeKey] = report
+	defer delete(symtab, debugScrapeKey)
+	if err := script.Play(symtab, false, logger); !assert.Nil(t, err) {
+		return
+	}
+
+	if !assert.Equal(t, 2, len(report.Actions)) {
+		return
+	}
+	set_vars := report.Actions[0]
+	assert.Equal(t, "set_fact", set_vars.Type)
+	assert.Equal(t, "set vars", set_vars.Name)
+	assert.Equal(t, 1, set_vars.Symbols["test"])
+	// secrets are never exposed
+	assert.Equal(t, redactedValue, set_vars.Symbols["password"])
+
+	loop := report.Actions[1]
+	assert.Equal(t, 2, loop.LoopCount)
+	if assert.Equal(t, 2, len(loop.When)) {
+		assert.True(t, loop.When[0].Result)
+		assert.False(t, loop.When[1].Result)
+	}
+	assert.Equal(t, "a", loop.Symbols["item_a"])
+	assert.NotContains(t, loop.Symbols, "item_b")
+	// unchanged symbols are not reported again
+	assert.NotContains(t, loop.Symbols, "test")
+}
+
+func TestSourcePos(t *testing.T) {
+	initTest()
+	logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	registry, _ := goja_modules.InitJSRegistry(logger, nil)
+
+	code := `
+- name: set vars
+  set_fact:
+    test: 1
+- name: loop
+  actions:
+    - name: invalid
+      set_fact:
+        value: "js: unknown.attr"
+`
+	script := &YAMLScript{
+		name:     "test",
+		registry: registry,
+		file:     "metrics/test.collector.yml",
+	}
+	if err := yaml.Unmarshal([]byte(code), &script); !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Equal(t, 2, len(script.Actions)) {
+		return
+	}
+	assert.Equal(t, SourcePos{File: "metrics/test.collector.yml", Line: 2, Column: 3}, script.Actions[0].GetPos())
+	sub := script.Actions[1].(*ActionsAction).Actions[0].(*SetFactAction)
+	assert.Equal(t, SourcePos{File: "metrics/test.collector.yml", Line: 7, Column: 7}, sub.GetPos())
+	assert.Equal(t, SourcePos{File: "metrics/test.collector.yml", Line: 9, Column: 16}, sub.setFact[0][1].(*Field).Pos())
+
+	// evaluation errors are located
+	_, err := sub.setFact[0][1].(*Field).GetValueObject(symtab, logger)
+	assert.ErrorContains(t, err, "metrics/test.collector.yml:9:16: ")
+	if val_err, ok := err.(VarError); assert.True(t, ok) {
+		assert.Equal(t, error_var_invalid_javascript_code, val_err.Code())
+	}
+
+	// action is located in debug trace
+	symtab["__collector_id"] = "yaml_script_test.go"
+	report := NewScrapeReport("test", nil)
+	symtab[debugScrapeKey] = report
+	defer delete(symtab, debugScrapeKey)
+	script.Play(symtab, true, logger)
+	if assert.NotEmpty(t, report.Actions) {
+		assert.Equal(t, "metrics/test.collector.yml:2:3", report.Actions[0].Source)
+	}
+
+	// parse errors are located
+	code = `
+- name: first
+  debug:
+    msg: ok
+- name: invalid
+  actions:
+    - name: invalid template
+      set_fact:
+        value: "{{ .unclosed "
+`
+	script = &YAMLScript{
+		name:     "test",
+		registry: registry,
+		file:     "metrics/test.collector.yml",
+	}
+	err = yaml.Unmarshal([]byte(code), &script)
+	assert.ErrorContains(t, err, "metrics/test.collector.yml:7:7: ")
+}